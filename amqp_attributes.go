@@ -0,0 +1,178 @@
+package servicebus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// AMQPAttributes holds the AMQP 1.0 header fields, properties and sections of a Message which have no dedicated
+	// field on Message. They are populated on receive and sent as they are, so that a message relayed to another AMQP
+	// broker keeps all of its metadata.
+	AMQPAttributes struct {
+		// Durable is the durable field of the header section.
+		Durable bool
+		// Priority is the priority field of the header section. When nil, the AMQP default priority of 4 applies.
+		Priority *uint8
+		// FirstAcquirer is the first-acquirer field of the header section.
+		FirstAcquirer bool
+
+		// UserID is the identity of the user responsible for producing the message.
+		UserID []byte
+		// ContentEncoding is a modifier to the ContentType of the message, such as "gzip".
+		ContentEncoding string
+		// AbsoluteExpiryTime is the absolute time when the message is considered to be expired.
+		AbsoluteExpiryTime *time.Time
+		// CreationTime is the absolute time when the message was created.
+		CreationTime *time.Time
+
+		// MessageID is the message-id of the message when it is not a string: a uint64, amqp.UUID or []byte. Message.ID
+		// holds its string form, and MessageID is sent in place of Message.ID for as long as Message.ID is unchanged.
+		MessageID interface{}
+		// CorrelationID is the correlation-id of the message when it is not a string. It behaves like MessageID with
+		// regards to Message.CorrelationID.
+		CorrelationID interface{}
+
+		// DeliveryAnnotations are the delivery annotations of the message, other than the lock token, which is exposed
+		// as Message.LockToken.
+		DeliveryAnnotations amqp.Annotations
+		// Footer is the footer section of the message.
+		Footer amqp.Annotations
+	}
+)
+
+// formatAMQPID returns the string form of an AMQP message-id or correlation-id: a string as it is, a uint64 in decimal,
+// a UUID in its canonical form and binary in hexadecimal.
+func formatAMQPID(id interface{}) string {
+	switch v := id.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case amqp.UUID:
+		return uuid.UUID(v).String()
+	case []byte:
+		return hex.EncodeToString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// amqpID returns the AMQP message-id or correlation-id to send: typed when the string form still matches id.
+func amqpID(id string, typed interface{}) interface{} {
+	if typed != nil && formatAMQPID(typed) == id {
+		return typed
+	}
+	return id
+}
+
+// attributes returns the AMQPAttributes of the message, creating them if needed.
+func (m *Message) attributes() *AMQPAttributes {
+	if m.AMQP == nil {
+		m.AMQP = new(AMQPAttributes)
+	}
+	return m.AMQP
+}
+
+// setAMQPAttributes copies the AMQPAttributes of the message onto the header, properties, delivery annotations and
+// footer of the AMQP message.
+func (m *Message) setAMQPAttributes(amqpMsg *amqp.Message) {
+	attrs := m.AMQP
+	if attrs == nil {
+		return
+	}
+
+	if attrs.Durable || attrs.Priority != nil || attrs.FirstAcquirer {
+		if amqpMsg.Header == nil {
+			amqpMsg.Header = &amqp.MessageHeader{Priority: 4}
+		}
+		amqpMsg.Header.Durable = attrs.Durable
+		amqpMsg.Header.FirstAcquirer = attrs.FirstAcquirer
+		if attrs.Priority != nil {
+			amqpMsg.Header.Priority = *attrs.Priority
+		}
+	}
+
+	amqpMsg.Properties.UserID = attrs.UserID
+	amqpMsg.Properties.ContentEncoding = attrs.ContentEncoding
+	if attrs.AbsoluteExpiryTime != nil {
+		amqpMsg.Properties.AbsoluteExpiryTime = *attrs.AbsoluteExpiryTime
+	}
+	if attrs.CreationTime != nil {
+		amqpMsg.Properties.CreationTime = *attrs.CreationTime
+	}
+
+	if len(attrs.DeliveryAnnotations) > 0 {
+		amqpMsg.DeliveryAnnotations = make(amqp.Annotations, len(attrs.DeliveryAnnotations))
+		for key, val := range attrs.DeliveryAnnotations {
+			amqpMsg.DeliveryAnnotations[key] = val
+		}
+	}
+
+	if len(attrs.Footer) > 0 {
+		amqpMsg.Footer = make(amqp.Annotations, len(attrs.Footer))
+		for key, val := range attrs.Footer {
+			amqpMsg.Footer[key] = val
+		}
+	}
+}
+
+// readAMQPAttributes populates the AMQPAttributes of the message from a received AMQP message. AMQPAttributes are only
+// created when the AMQP message carries something to put in them.
+func (m *Message) readAMQPAttributes(amqpMsg *amqp.Message) {
+	if h := amqpMsg.Header; h != nil && (h.Durable || h.Priority != 4 || h.FirstAcquirer) {
+		attrs := m.attributes()
+		attrs.Durable = h.Durable
+		attrs.FirstAcquirer = h.FirstAcquirer
+		if h.Priority != 4 {
+			priority := h.Priority
+			attrs.Priority = &priority
+		}
+	}
+
+	if p := amqpMsg.Properties; p != nil {
+		if len(p.UserID) > 0 {
+			m.attributes().UserID = p.UserID
+		}
+		if p.ContentEncoding != "" {
+			m.attributes().ContentEncoding = p.ContentEncoding
+		}
+		if !p.AbsoluteExpiryTime.IsZero() {
+			t := p.AbsoluteExpiryTime
+			m.attributes().AbsoluteExpiryTime = &t
+		}
+		if !p.CreationTime.IsZero() {
+			t := p.CreationTime
+			m.attributes().CreationTime = &t
+		}
+		if _, ok := p.MessageID.(string); !ok && p.MessageID != nil {
+			m.attributes().MessageID = p.MessageID
+		}
+		if _, ok := p.CorrelationID.(string); !ok && p.CorrelationID != nil {
+			m.attributes().CorrelationID = p.CorrelationID
+		}
+	}
+
+	for key, val := range amqpMsg.DeliveryAnnotations {
+		if key == lockTokenName {
+			continue
+		}
+
+		attrs := m.attributes()
+		if attrs.DeliveryAnnotations == nil {
+			attrs.DeliveryAnnotations = make(amqp.Annotations)
+		}
+		attrs.DeliveryAnnotations[key] = val
+	}
+
+	if len(amqpMsg.Footer) > 0 {
+		m.attributes().Footer = amqpMsg.Footer
+	}
+}
@@ -0,0 +1,73 @@
+package servicebus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAMQPAttributes_RoundTrip(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	expires := created.Add(time.Hour)
+	priority := uint8(7)
+	ttl := time.Minute
+
+	m := NewMessageFromString("foo")
+	m.ID = "id"
+	m.TTL = &ttl
+	m.AMQP = &AMQPAttributes{
+		Durable:             true,
+		Priority:            &priority,
+		FirstAcquirer:       true,
+		UserID:              []byte("user"),
+		ContentEncoding:     "gzip",
+		AbsoluteExpiryTime:  &expires,
+		CreationTime:        &created,
+		CorrelationID:       uint64(42),
+		DeliveryAnnotations: amqp.Annotations{"x-opt-custom": "delivery"},
+		Footer:              amqp.Annotations{"hash": "abc"},
+	}
+	m.CorrelationID = "42"
+
+	received := roundTripMessage(t, m)
+	require.NotNil(t, received.AMQP)
+	assert.Equal(t, m.AMQP, received.AMQP)
+	assert.Equal(t, "id", received.ID)
+	assert.Equal(t, "42", received.CorrelationID)
+	assert.Equal(t, ttl, *received.TTL)
+}
+
+func TestAMQPAttributes_TypedMessageID(t *testing.T) {
+	id := amqp.UUID{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	amqpMsg := amqp.NewMessage([]byte("foo"))
+	amqpMsg.Properties = &amqp.MessageProperties{
+		MessageID:     id,
+		CorrelationID: []byte{0xca, 0xfe},
+	}
+
+	m, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", m.ID)
+	assert.Equal(t, "cafe", m.CorrelationID)
+
+	// the typed IDs are sent as long as the string forms are unchanged
+	sent, err := m.toMsg()
+	require.NoError(t, err)
+	assert.Equal(t, id, sent.Properties.MessageID)
+	assert.Equal(t, []byte{0xca, 0xfe}, sent.Properties.CorrelationID)
+
+	m.ID = "replaced"
+	sent, err = m.toMsg()
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", sent.Properties.MessageID)
+}
+
+func TestAMQPAttributes_NotCreatedForPlainMessages(t *testing.T) {
+	m := NewMessageFromString("foo")
+	m.ID = "id"
+	received := roundTripMessage(t, m)
+	assert.Nil(t, received.AMQP)
+}
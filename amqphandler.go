@@ -68,6 +68,43 @@ func (h *amqpAdapterHandler) Handle(ctx context.Context, msg *amqp.Message, r *a
 		span.AddAttributes(tab.StringAttribute("amqp.message.id", idStr))
 	}
 
+	if err := h.receiver.restorePayload(ctx, event); err != nil {
+		tab.For(ctx).Error(err)
+		if h.receiver.mode == PeekLockMode {
+			return settleUnrestorable(ctx, event, err)
+		}
+
+		h.receiver.lastError = err
+		if h.receiver.doneListening != nil {
+			h.receiver.doneListening()
+		}
+		return err
+	}
+
+	duplicate, err := h.receiver.isDuplicate(ctx, event)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		if h.receiver.mode == PeekLockMode {
+			// the store may be available on redelivery
+			return event.Abandon(ctx)
+		}
+
+		h.receiver.lastError = err
+		if h.receiver.doneListening != nil {
+			h.receiver.doneListening()
+		}
+		return err
+	}
+
+	if duplicate {
+		tab.For(ctx).Debug("skipping a message which was already processed")
+		if h.receiver.mode == ReceiveAndDeleteMode {
+			return nil
+		}
+		return event.Complete(ctx)
+	}
+
+	h.receiver.trackProcessed(event)
 	if err := h.next.Handle(ctx, event); err != nil {
 		// stop handling messages since the message consumer ran into an unexpected error
 		h.receiver.lastError = err
@@ -79,6 +116,8 @@ func (h *amqpAdapterHandler) Handle(ctx context.Context, msg *amqp.Message, r *a
 
 	// nothing more to be done. The message was settled when it was accepted by the Receiver
 	if h.receiver.mode == ReceiveAndDeleteMode {
+		event.deletePayload(ctx)
+		event.markProcessed(ctx)
 		return nil
 	}
 
@@ -0,0 +1,686 @@
+package servicebus
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/Azure/go-amqp"
+	"github.com/devigned/tab"
+)
+
+type (
+	// ArchiveWriter streams messages to an io.Writer as JSON lines, one message per line, in the schema of
+	// Message.MarshalJSON.
+	ArchiveWriter struct {
+		enc *json.Encoder
+	}
+
+	// ArchiveReader reads messages written by an ArchiveWriter. It is a MessageIterator, so archived messages can be
+	// handled the same way as peeked ones.
+	ArchiveReader struct {
+		dec *json.Decoder
+	}
+
+	// jsonMessage is the archived form of a Message. Fields are only ever added to it; any other change requires a new
+	// archiveSchemaVersion.
+	jsonMessage struct {
+		Version          int                   `json:"version"`
+		ID               string                `json:"id,omitempty"`
+		CorrelationID    string                `json:"correlationId,omitempty"`
+		SessionID        *string               `json:"sessionId,omitempty"`
+		GroupSequence    *uint32               `json:"groupSequence,omitempty"`
+		ContentType      string                `json:"contentType,omitempty"`
+		Label            string                `json:"label,omitempty"`
+		ReplyTo          string                `json:"replyTo,omitempty"`
+		ReplyToGroupID   string                `json:"replyToGroupId,omitempty"`
+		To               string                `json:"to,omitempty"`
+		TTL              *int64                `json:"ttlMilliseconds,omitempty"`
+		DeliveryCount    uint32                `json:"deliveryCount,omitempty"`
+		LockToken        *uuid.UUID            `json:"lockToken,omitempty"`
+		Body             jsonBody              `json:"body"`
+		UserProperties   map[string]jsonValue  `json:"userProperties,omitempty"`
+		SystemProperties *jsonSystemProperties `json:"systemProperties,omitempty"`
+		AMQP             *jsonAMQPAttributes   `json:"amqp,omitempty"`
+	}
+
+	jsonBody struct {
+		Type  string     `json:"type"`
+		Data  [][]byte   `json:"data,omitempty"`
+		Value *jsonValue `json:"value,omitempty"`
+	}
+
+	jsonSystemProperties struct {
+		LockedUntil            *time.Time           `json:"lockedUntil,omitempty"`
+		SequenceNumber         *int64               `json:"sequenceNumber,omitempty"`
+		PartitionID            *int16               `json:"partitionId,omitempty"`
+		PartitionKey           *string              `json:"partitionKey,omitempty"`
+		EnqueuedTime           *time.Time           `json:"enqueuedTime,omitempty"`
+		DeadLetterSource       *string              `json:"deadLetterSource,omitempty"`
+		ScheduledEnqueueTime   *time.Time           `json:"scheduledEnqueueTime,omitempty"`
+		EnqueuedSequenceNumber *int64               `json:"enqueuedSequenceNumber,omitempty"`
+		ViaPartitionKey        *string              `json:"viaPartitionKey,omitempty"`
+		Annotations            map[string]jsonValue `json:"annotations,omitempty"`
+	}
+
+	jsonAMQPAttributes struct {
+		Durable             bool                 `json:"durable,omitempty"`
+		Priority            *uint8               `json:"priority,omitempty"`
+		FirstAcquirer       bool                 `json:"firstAcquirer,omitempty"`
+		UserID              []byte               `json:"userId,omitempty"`
+		ContentEncoding     string               `json:"contentEncoding,omitempty"`
+		AbsoluteExpiryTime  *time.Time           `json:"absoluteExpiryTime,omitempty"`
+		CreationTime        *time.Time           `json:"creationTime,omitempty"`
+		MessageID           *jsonValue           `json:"messageId,omitempty"`
+		CorrelationID       *jsonValue           `json:"correlationId,omitempty"`
+		DeliveryAnnotations map[string]jsonValue `json:"deliveryAnnotations,omitempty"`
+		Footer              map[string]jsonValue `json:"footer,omitempty"`
+	}
+
+	// jsonValue is an AMQP value tagged with its type, so that it is decoded back into the same Go type. Lists and
+	// maps are stored as nested jsonValues.
+	jsonValue struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+)
+
+const (
+	archiveSchemaVersion = 1
+)
+
+var (
+	// types whose JSON encoding is exact
+	plainJSONTypes = map[string]func() interface{}{
+		"bool":    func() interface{} { return new(bool) },
+		"string":  func() interface{} { return new(string) },
+		"int8":    func() interface{} { return new(int8) },
+		"int16":   func() interface{} { return new(int16) },
+		"int32":   func() interface{} { return new(int32) },
+		"uint8":   func() interface{} { return new(uint8) },
+		"uint16":  func() interface{} { return new(uint16) },
+		"uint32":  func() interface{} { return new(uint32) },
+		"float32": func() interface{} { return new(float32) },
+		"float64": func() interface{} { return new(float64) },
+	}
+)
+
+// NewArchiveWriter creates an ArchiveWriter writing to w.
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &ArchiveWriter{enc: enc}
+}
+
+// Write appends a message to the archive.
+func (aw *ArchiveWriter) Write(m *Message) error {
+	return aw.enc.Encode(m)
+}
+
+// WriteAll appends every message of the iterator to the archive, until the iterator is done or reports that there are
+// no more messages, and returns the number of messages written. A peek iterator can be used to snapshot the messages
+// of an entity, or of its dead-letter queue, without receiving them.
+func (aw *ArchiveWriter) WriteAll(ctx context.Context, it MessageIterator) (int, error) {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.ArchiveWriter.WriteAll")
+	defer span.End()
+
+	count := 0
+	for !it.Done() {
+		m, err := it.Next(ctx)
+		if _, ok := err.(ErrNoMessages); ok {
+			break
+		}
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return count, err
+		}
+
+		if err := aw.Write(m); err != nil {
+			tab.For(ctx).Error(err)
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// NewArchiveReader creates an ArchiveReader reading from r.
+func NewArchiveReader(r io.Reader) *ArchiveReader {
+	return &ArchiveReader{dec: json.NewDecoder(r)}
+}
+
+// Done communicates whether there are more messages remaining in the archive.
+func (ar *ArchiveReader) Done() bool {
+	return !ar.dec.More()
+}
+
+// Next reads the next message of the archive. It returns io.EOF once the archive is exhausted.
+func (ar *ArchiveReader) Next(_ context.Context) (*Message, error) {
+	m := new(Message)
+	if err := ar.dec.Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Replay sends a copy of every message remaining in the archive with send, such as the Send method of a Queue, and
+// returns the number of messages sent. Each message is copied with Message.Clone and opts, so that the state assigned
+// by the broker the message was archived from is left out.
+func (ar *ArchiveReader) Replay(ctx context.Context, send func(context.Context, *Message) error, opts ...CloneOption) (int, error) {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.ArchiveReader.Replay")
+	defer span.End()
+
+	count := 0
+	for !ar.Done() {
+		m, err := ar.Next(ctx)
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return count, err
+		}
+
+		if err := send(ctx, m.Clone(opts...)); err != nil {
+			tab.For(ctx).Error(err)
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// MarshalBinary encodes the message in the AMQP 1.0 message format, exactly as it would be transferred to or from
+// Service Bus, including its system properties, lock token and delivery count.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	amqpMsg, err := m.toMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	if m.DeliveryCount > 0 {
+		if amqpMsg.Header == nil {
+			amqpMsg.Header = &amqp.MessageHeader{Priority: 4}
+		}
+		// the header counts prior deliveries, while DeliveryCount includes the current one
+		amqpMsg.Header.DeliveryCount = m.DeliveryCount - 1
+	}
+	return amqpMsg.MarshalBinary()
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary. The message is not tied to a receiver, so it cannot be
+// completed, abandoned or dead-lettered.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	var amqpMsg amqp.Message
+	if err := amqpMsg.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	decoded, err := messageFromAMQPMessage(&amqpMsg, nil)
+	if err != nil {
+		return err
+	}
+
+	decoded.message = nil
+	*m = *decoded
+	return nil
+}
+
+// MarshalJSON encodes the message, with its system properties, in a stable and versioned JSON schema. The AMQP types of
+// user properties, annotations and amqp-value bodies are recorded, so that UnmarshalJSON restores them exactly.
+func (m *Message) MarshalJSON() ([]byte, error) {
+	jm := jsonMessage{
+		Version:        archiveSchemaVersion,
+		ID:             m.ID,
+		CorrelationID:  m.CorrelationID,
+		SessionID:      m.SessionID,
+		GroupSequence:  m.GroupSequence,
+		ContentType:    m.ContentType,
+		Label:          m.Label,
+		ReplyTo:        m.ReplyTo,
+		ReplyToGroupID: m.ReplyToGroupID,
+		To:             m.To,
+		DeliveryCount:  m.DeliveryCount,
+		LockToken:      m.LockToken,
+	}
+
+	if m.TTL != nil {
+		ttl := int64(*m.TTL / time.Millisecond)
+		jm.TTL = &ttl
+	}
+
+	var err error
+	if jm.Body, err = newJSONBody(m); err != nil {
+		return nil, err
+	}
+
+	if jm.UserProperties, err = newJSONValueMap(m.UserProperties); err != nil {
+		return nil, err
+	}
+
+	if sp := m.SystemProperties; sp != nil {
+		jm.SystemProperties = &jsonSystemProperties{
+			LockedUntil:            sp.LockedUntil,
+			SequenceNumber:         sp.SequenceNumber,
+			PartitionID:            sp.PartitionID,
+			PartitionKey:           sp.PartitionKey,
+			EnqueuedTime:           sp.EnqueuedTime,
+			DeadLetterSource:       sp.DeadLetterSource,
+			ScheduledEnqueueTime:   sp.ScheduledEnqueueTime,
+			EnqueuedSequenceNumber: sp.EnqueuedSequenceNumber,
+			ViaPartitionKey:        sp.ViaPartitionKey,
+		}
+		if jm.SystemProperties.Annotations, err = newJSONValueMap(sp.Annotations); err != nil {
+			return nil, err
+		}
+	}
+
+	if a := m.AMQP; a != nil {
+		ja := &jsonAMQPAttributes{
+			Durable:            a.Durable,
+			Priority:           a.Priority,
+			FirstAcquirer:      a.FirstAcquirer,
+			UserID:             a.UserID,
+			ContentEncoding:    a.ContentEncoding,
+			AbsoluteExpiryTime: a.AbsoluteExpiryTime,
+			CreationTime:       a.CreationTime,
+		}
+		if ja.MessageID, err = newOptionalJSONValue(a.MessageID); err != nil {
+			return nil, err
+		}
+		if ja.CorrelationID, err = newOptionalJSONValue(a.CorrelationID); err != nil {
+			return nil, err
+		}
+		if ja.DeliveryAnnotations, err = newJSONAnnotations(a.DeliveryAnnotations); err != nil {
+			return nil, err
+		}
+		if ja.Footer, err = newJSONAnnotations(a.Footer); err != nil {
+			return nil, err
+		}
+		jm.AMQP = ja
+	}
+
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON. The message is not tied to a receiver, so it cannot be
+// completed, abandoned or dead-lettered.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var jm jsonMessage
+	if err := json.Unmarshal(data, &jm); err != nil {
+		return err
+	}
+
+	if jm.Version != archiveSchemaVersion {
+		return fmt.Errorf("unsupported message schema version %d", jm.Version)
+	}
+
+	decoded := Message{
+		ID:             jm.ID,
+		CorrelationID:  jm.CorrelationID,
+		SessionID:      jm.SessionID,
+		GroupSequence:  jm.GroupSequence,
+		ContentType:    jm.ContentType,
+		Label:          jm.Label,
+		ReplyTo:        jm.ReplyTo,
+		ReplyToGroupID: jm.ReplyToGroupID,
+		To:             jm.To,
+		DeliveryCount:  jm.DeliveryCount,
+		LockToken:      jm.LockToken,
+	}
+
+	if jm.TTL != nil {
+		ttl := time.Duration(*jm.TTL) * time.Millisecond
+		decoded.TTL = &ttl
+	}
+
+	var err error
+	if err = jm.Body.decodeInto(&decoded); err != nil {
+		return err
+	}
+
+	if decoded.UserProperties, err = decodeJSONValueMap(jm.UserProperties); err != nil {
+		return err
+	}
+
+	if jsp := jm.SystemProperties; jsp != nil {
+		decoded.SystemProperties = &SystemProperties{
+			LockedUntil:            jsp.LockedUntil,
+			SequenceNumber:         jsp.SequenceNumber,
+			PartitionID:            jsp.PartitionID,
+			PartitionKey:           jsp.PartitionKey,
+			EnqueuedTime:           jsp.EnqueuedTime,
+			DeadLetterSource:       jsp.DeadLetterSource,
+			ScheduledEnqueueTime:   jsp.ScheduledEnqueueTime,
+			EnqueuedSequenceNumber: jsp.EnqueuedSequenceNumber,
+			ViaPartitionKey:        jsp.ViaPartitionKey,
+		}
+		if decoded.SystemProperties.Annotations, err = decodeJSONValueMap(jsp.Annotations); err != nil {
+			return err
+		}
+	}
+
+	if ja := jm.AMQP; ja != nil {
+		a := &AMQPAttributes{
+			Durable:            ja.Durable,
+			Priority:           ja.Priority,
+			FirstAcquirer:      ja.FirstAcquirer,
+			UserID:             ja.UserID,
+			ContentEncoding:    ja.ContentEncoding,
+			AbsoluteExpiryTime: ja.AbsoluteExpiryTime,
+			CreationTime:       ja.CreationTime,
+		}
+		if a.MessageID, err = ja.MessageID.decodeOptional(); err != nil {
+			return err
+		}
+		if a.CorrelationID, err = ja.CorrelationID.decodeOptional(); err != nil {
+			return err
+		}
+		if a.DeliveryAnnotations, err = decodeJSONAnnotations(ja.DeliveryAnnotations); err != nil {
+			return err
+		}
+		if a.Footer, err = decodeJSONAnnotations(ja.Footer); err != nil {
+			return err
+		}
+		decoded.AMQP = a
+	}
+
+	*m = decoded
+	return nil
+}
+
+func newJSONBody(m *Message) (jsonBody, error) {
+	jb := jsonBody{Type: m.BodyType().String()}
+	switch m.BodyType() {
+	case BodyTypeData:
+		jb.Data = m.DataSections()
+	case BodyTypeValue:
+		v, err := newJSONValue(m.Body.Value)
+		if err != nil {
+			return jb, err
+		}
+		jb.Value = &v
+	default:
+		return jb, fmt.Errorf("unknown message body type %s", m.BodyType())
+	}
+	return jb, nil
+}
+
+func (jb jsonBody) decodeInto(m *Message) error {
+	switch jb.Type {
+	case BodyTypeData.String():
+		if len(jb.Data) == 1 {
+			m.Data = jb.Data[0]
+			return nil
+		}
+		m.Data, m.Body = bodyFromAMQPMessage(&amqp.Message{Data: jb.Data})
+	case BodyTypeValue.String():
+		if jb.Value == nil {
+			return errors.New("amqp-value body has no value")
+		}
+		v, err := jb.Value.decode()
+		if err != nil {
+			return err
+		}
+		m.Data, m.Body = bodyFromAMQPMessage(&amqp.Message{Value: v})
+	default:
+		return fmt.Errorf("unknown message body type %q", jb.Type)
+	}
+	return nil
+}
+
+func newJSONValueMap(values map[string]interface{}) (map[string]jsonValue, error) {
+	if values == nil {
+		return nil, nil
+	}
+
+	encoded := make(map[string]jsonValue, len(values))
+	for key, val := range values {
+		v, err := newJSONValue(val)
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %v", key, err)
+		}
+		encoded[key] = v
+	}
+	return encoded, nil
+}
+
+func decodeJSONValueMap(encoded map[string]jsonValue) (map[string]interface{}, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	values := make(map[string]interface{}, len(encoded))
+	for key, jv := range encoded {
+		v, err := jv.decode()
+		if err != nil {
+			return nil, fmt.Errorf("property %q: %v", key, err)
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+// newJSONAnnotations encodes annotations, whose keys are symbols or, in rare cases, unsigned longs. Unsigned long keys
+// are prefixed with '#' to keep them apart from symbols.
+func newJSONAnnotations(a amqp.Annotations) (map[string]jsonValue, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	values := make(map[string]interface{}, len(a))
+	for key, val := range a {
+		switch k := key.(type) {
+		case string:
+			values[k] = val
+		case uint64:
+			values["#"+strconv.FormatUint(k, 10)] = val
+		default:
+			return nil, fmt.Errorf("annotation key of type %T is not supported", key)
+		}
+	}
+	return newJSONValueMap(values)
+}
+
+func decodeJSONAnnotations(encoded map[string]jsonValue) (amqp.Annotations, error) {
+	values, err := decodeJSONValueMap(encoded)
+	if values == nil || err != nil {
+		return nil, err
+	}
+
+	a := make(amqp.Annotations, len(values))
+	for key, val := range values {
+		if len(key) > 1 && key[0] == '#' {
+			if n, err := strconv.ParseUint(key[1:], 10, 64); err == nil {
+				a[n] = val
+				continue
+			}
+		}
+		a[key] = val
+	}
+	return a, nil
+}
+
+func newOptionalJSONValue(v interface{}) (*jsonValue, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	jv, err := newJSONValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return &jv, nil
+}
+
+func (jv *jsonValue) decodeOptional() (interface{}, error) {
+	if jv == nil {
+		return nil, nil
+	}
+	return jv.decode()
+}
+
+// newJSONValue tags an AMQP value with its type.
+func newJSONValue(v interface{}) (jsonValue, error) {
+	var typeName string
+	var raw interface{} = v
+
+	switch val := v.(type) {
+	case nil:
+		return jsonValue{Type: "null"}, nil
+	case bool:
+		typeName = "bool"
+	case string:
+		typeName = "string"
+	case int8:
+		typeName = "int8"
+	case int16:
+		typeName = "int16"
+	case int32:
+		typeName = "int32"
+	case int64:
+		typeName, raw = "int64", strconv.FormatInt(val, 10)
+	case int:
+		typeName, raw = "int", strconv.FormatInt(int64(val), 10)
+	case uint8:
+		typeName = "uint8"
+	case uint16:
+		typeName = "uint16"
+	case uint32:
+		typeName = "uint32"
+	case uint64:
+		typeName, raw = "uint64", strconv.FormatUint(val, 10)
+	case uint:
+		typeName, raw = "uint", strconv.FormatUint(uint64(val), 10)
+	case float32:
+		typeName = "float32"
+	case float64:
+		typeName = "float64"
+	case time.Time:
+		typeName, raw = "timestamp", val.UTC().Format(time.RFC3339Nano)
+	case time.Duration:
+		typeName, raw = "duration", strconv.FormatInt(int64(val), 10)
+	case []byte:
+		typeName, raw = "binary", base64.StdEncoding.EncodeToString(val)
+	case amqp.UUID:
+		typeName, raw = "uuid", uuid.UUID(val).String()
+	case uuid.UUID:
+		typeName, raw = "uuid", val.String()
+	case []interface{}:
+		list := make([]jsonValue, len(val))
+		for i := range val {
+			item, err := newJSONValue(val[i])
+			if err != nil {
+				return jsonValue{}, err
+			}
+			list[i] = item
+		}
+		typeName, raw = "list", list
+	case map[string]interface{}:
+		m, err := newJSONValueMap(val)
+		if err != nil {
+			return jsonValue{}, err
+		}
+		typeName, raw = "map", m
+	default:
+		// AMQP symbols are decoded into a type of go-amqp which is not exported, and are archived as strings.
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.String {
+			typeName, raw = "string", rv.String()
+			break
+		}
+		return jsonValue{}, fmt.Errorf("values of type %T cannot be archived", v)
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return jsonValue{}, err
+	}
+	return jsonValue{Type: typeName, Value: encoded}, nil
+}
+
+// decode restores an AMQP value from its tagged form.
+func (jv jsonValue) decode() (interface{}, error) {
+	switch jv.Type {
+	case "null":
+		return nil, nil
+	case "list":
+		var items []jsonValue
+		if err := json.Unmarshal(jv.Value, &items); err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, len(items))
+		for i := range items {
+			item, err := items[i].decode()
+			if err != nil {
+				return nil, err
+			}
+			list[i] = item
+		}
+		return list, nil
+	case "map":
+		var m map[string]jsonValue
+		if err := json.Unmarshal(jv.Value, &m); err != nil {
+			return nil, err
+		}
+		return decodeJSONValueMap(m)
+	}
+
+	if newValue, ok := plainJSONTypes[jv.Type]; ok {
+		ptr := newValue()
+		if err := json.Unmarshal(jv.Value, ptr); err != nil {
+			return nil, err
+		}
+		return reflect.ValueOf(ptr).Elem().Interface(), nil
+	}
+
+	// the remaining types are stored as strings
+	var s string
+	if err := json.Unmarshal(jv.Value, &s); err != nil {
+		return nil, err
+	}
+
+	switch jv.Type {
+	case "int64":
+		return strconv.ParseInt(s, 10, 64)
+	case "int":
+		n, err := strconv.ParseInt(s, 10, 64)
+		return int(n), err
+	case "uint64":
+		return strconv.ParseUint(s, 10, 64)
+	case "uint":
+		n, err := strconv.ParseUint(s, 10, 64)
+		return uint(n), err
+	case "timestamp":
+		return time.Parse(time.RFC3339Nano, s)
+	case "duration":
+		n, err := strconv.ParseInt(s, 10, 64)
+		return time.Duration(n), err
+	case "binary":
+		return base64.StdEncoding.DecodeString(s)
+	case "uuid":
+		return parseUUID(s)
+	default:
+		return nil, fmt.Errorf("unknown value type %q", jv.Type)
+	}
+}
+
+// parseUUID parses the canonical form of a UUID.
+func parseUUID(s string) (amqp.UUID, error) {
+	var id amqp.UUID
+	b, err := hex.DecodeString(strings.Replace(s, "-", "", -1))
+	if err != nil {
+		return id, err
+	}
+
+	if len(b) != len(id) {
+		return id, fmt.Errorf("invalid UUID %q", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
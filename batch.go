@@ -1,6 +1,8 @@
 package servicebus
 
 import (
+	"errors"
+
 	"github.com/Azure/azure-amqp-common-go/v3/uuid"
 	"github.com/Azure/go-amqp"
 )
@@ -9,6 +11,15 @@ type (
 	// BatchOptions are optional information to add to a batch of messages
 	BatchOptions struct {
 		SessionID *string
+		// Compression is applied to each message added to the batch, so that the size of the batch accounts for
+		// the compressed payloads
+		Compression *Compression
+		// MaxSize is the size of the batches built by a MessageBatchIterator which has no MaxSize of its own. Senders
+		// set it to the maximum message size advertised by the broker.
+		MaxSize MaxMessageSizeInBytes
+
+		// transforms are applied by Senders to each message added to the batch, after compression
+		transforms []func(*amqp.Message) error
 	}
 
 	// BatchIterator offers a simple mechanism for batching a list of messages
@@ -30,6 +41,8 @@ type (
 		marshaledMessages [][]byte
 		MaxSize           MaxMessageSizeInBytes
 		size              int
+		compression       *Compression
+		transforms        []func(*amqp.Message) error
 	}
 
 	// MaxMessageSizeInBytes is the max number of bytes allowed by Azure Service Bus
@@ -44,7 +57,8 @@ const (
 
 	batchMessageFormat uint32 = 0x80013700
 
-	batchMessageWrapperSize = 100
+	// the descriptor of a data section, which precedes its binary value
+	dataSectionDescriptorSize = 3
 )
 
 // NewMessageBatchIterator wraps a slice of Message pointers to allow it to be made into a MessageIterator.
@@ -70,7 +84,12 @@ func (mbi *MessageBatchIterator) Next(messageID string, opts *BatchOptions) (*Me
 		opts = &BatchOptions{}
 	}
 
-	mb := NewMessageBatch(mbi.MaxSize, messageID, opts)
+	maxSize := mbi.MaxSize
+	if maxSize == 0 {
+		maxSize = opts.MaxSize
+	}
+
+	mb := NewMessageBatch(maxSize, messageID, opts)
 	for mbi.Cursor < len(mbi.Messages) {
 		ok, err := mb.Add(mbi.Messages[mbi.Cursor])
 		if err != nil {
@@ -92,7 +111,9 @@ func NewMessageBatch(maxSize MaxMessageSizeInBytes, messageID string, opts *Batc
 	}
 
 	mb := &MessageBatch{
-		MaxSize: maxSize,
+		MaxSize:     maxSize,
+		compression: opts.Compression,
+		transforms:  opts.transforms,
 		Message: &Message{
 			ID:        messageID,
 			SessionID: opts.SessionID,
@@ -121,16 +142,26 @@ func (mb *MessageBatch) Add(m *Message) (bool, error) {
 		msg.Properties.GroupID = *mb.SessionID
 	}
 
+	if err := mb.compression.apply(msg); err != nil {
+		return false, err
+	}
+
+	for _, transform := range mb.transforms {
+		if err := transform(msg); err != nil {
+			return false, err
+		}
+	}
+
 	bin, err := msg.MarshalBinary()
 	if err != nil {
 		return false, err
 	}
 
-	if mb.Size()+len(bin) > int(mb.MaxSize) {
+	if mb.Size()+dataSectionSize(len(bin)) > int(mb.MaxSize) {
 		return false, nil
 	}
 
-	mb.size += len(bin)
+	mb.size += dataSectionSize(len(bin))
 	mb.marshaledMessages = append(mb.marshaledMessages, bin)
 	return true, nil
 }
@@ -141,10 +172,37 @@ func (mb *MessageBatch) Clear() {
 	mb.size = 0
 }
 
-// Size is the number of bytes in the message batch
+// Size is the number of bytes the message batch takes once encoded for the wire
 func (mb *MessageBatch) Size() int {
-	// calculated data size + batch message wrapper + data wrapper portions of the message
-	return mb.size + batchMessageWrapperSize + (len(mb.marshaledMessages) * 5)
+	// the batch message is its properties followed by one data section per message, which are already accounted for
+	envelope, _ := mb.amqpBatchMessage().MarshalBinary()
+	return len(envelope) + mb.size
+}
+
+// dataSectionSize returns the encoded size of a data section holding n bytes.
+func dataSectionSize(n int) int {
+	if n < 256 {
+		// vbin8: a type code and a 1 byte length
+		return dataSectionDescriptorSize + 2 + n
+	}
+	// vbin32: a type code and a 4 byte length
+	return dataSectionDescriptorSize + 5 + n
+}
+
+// MarshalBinary encodes the batch in the AMQP 1.0 message format, exactly as it is transferred to Service Bus: a
+// message holding each batched message, itself encoded, in a data section of its own.
+func (mb *MessageBatch) MarshalBinary() ([]byte, error) {
+	batchMessage, err := mb.toMsg()
+	if err != nil {
+		return nil, err
+	}
+	return batchMessage.MarshalBinary()
+}
+
+// MarshalJSON fails, as the JSON schema of Message.MarshalJSON has no room for the batched messages. Archive the
+// messages before adding them to the batch instead, or encode the batch with MarshalBinary.
+func (mb *MessageBatch) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("a MessageBatch cannot be encoded as JSON")
 }
 
 func (mb *MessageBatch) toMsg() (*amqp.Message, error) {
@@ -159,7 +217,6 @@ func (mb *MessageBatch) toMsg() (*amqp.Message, error) {
 
 func (mb *MessageBatch) amqpBatchMessage() *amqp.Message {
 	return &amqp.Message{
-		Data:   make([][]byte, len(mb.marshaledMessages)),
 		Format: batchMessageFormat,
 		Properties: &amqp.MessageProperties{
 			MessageID: mb.ID,
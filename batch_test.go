@@ -1,9 +1,12 @@
 package servicebus
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewMessageBatch(t *testing.T) {
@@ -31,7 +34,7 @@ func TestMessageBatch_AddManyMessages(t *testing.T) {
 	assert.NoError(t, err)
 	msgSize := mb.Size() - wrapperSize
 
-	limit := ((int(mb.MaxSize) - 100) / msgSize) - 1
+	limit := ((int(mb.MaxSize) - wrapperSize) / msgSize) - 1
 	for i := 0; i < limit; i++ {
 		ok, err := mb.Add(msg)
 		assert.True(t, ok)
@@ -48,8 +51,101 @@ func TestMessageBatch_Clear(t *testing.T) {
 	ok, err := mb.Add(NewMessageFromString("foo"))
 	assert.True(t, ok)
 	assert.NoError(t, err)
-	assert.Equal(t, 175, mb.Size())
+	assert.Equal(t, 109, mb.Size())
 
 	mb.Clear()
-	assert.Equal(t, 100, mb.Size())
+	assert.Equal(t, 34, mb.Size())
+}
+
+func TestMessageBatch_SizeIsEncodedSize(t *testing.T) {
+	mb := NewMessageBatch(StandardMaxMessageSizeInBytes, "messageID", &BatchOptions{SessionID: ptrString("session")})
+	for _, size := range []int{0, 10, 250, 300, 70000} {
+		ok, err := mb.Add(NewMessage(make([]byte, size)))
+		assert.True(t, ok)
+		assert.NoError(t, err)
+
+		msg, err := mb.toMsg()
+		assert.NoError(t, err)
+		bin, err := msg.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Equal(t, len(bin), mb.Size())
+	}
+}
+
+func TestMessageBatchIterator_MaxSizeFromOptions(t *testing.T) {
+	msgs := []*Message{NewMessage(make([]byte, 600)), NewMessage(make([]byte, 600))}
+
+	mbi := NewMessageBatchIterator(0, msgs...)
+	mb, err := mbi.Next("batch", &BatchOptions{MaxSize: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, MaxMessageSizeInBytes(1000), mb.MaxSize)
+	assert.False(t, mbi.Done(), "only one message fits in the batch")
+
+	mbi = NewMessageBatchIterator(StandardMaxMessageSizeInBytes, msgs...)
+	mb, err = mbi.Next("batch", &BatchOptions{MaxSize: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, StandardMaxMessageSizeInBytes, mb.MaxSize)
+	assert.True(t, mbi.Done())
+}
+
+func TestMessage_EncodedSize(t *testing.T) {
+	for _, size := range []int{0, 255, 256, 100000} {
+		m := NewMessage(make([]byte, size))
+		m.ID = "id"
+		m.Set("key", "value")
+
+		encodedSize, err := m.EncodedSize()
+		assert.NoError(t, err)
+
+		msg, err := m.toMsg()
+		assert.NoError(t, err)
+		bin, err := msg.MarshalBinary()
+		assert.NoError(t, err)
+		assert.Equal(t, len(bin), encodedSize)
+	}
+
+	m := NewMessageFromString("foo")
+	m.Set("bad", struct{}{})
+	_, err := m.EncodedSize()
+	assert.Error(t, err)
+}
+
+func TestSender_NewBatch(t *testing.T) {
+	s := &Sender{maxMessageSize: 1000, sessionID: ptrString("session")}
+	mb, err := s.NewBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, MaxMessageSizeInBytes(1000), mb.MaxSize)
+	assert.Equal(t, "session", *mb.SessionID)
+	assert.NotEmpty(t, mb.ID)
+
+	s = new(Sender)
+	assert.Equal(t, StandardMaxMessageSizeInBytes, s.MaxMessageSize(), "the broker advertised no maximum")
+}
+
+func TestMessageBatch_MarshalBinary(t *testing.T) {
+	mb := NewMessageBatch(StandardMaxMessageSizeInBytes, "batchID", &BatchOptions{})
+	for _, body := range []string{"foo", "bar"} {
+		ok, err := mb.Add(NewMessageFromString(body))
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	bin, err := mb.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, mb.Size(), len(bin))
+
+	// the batched messages are encoded, rather than the embedded Message alone
+	var decoded Message
+	require.NoError(t, decoded.UnmarshalBinary(bin))
+	assert.Equal(t, "batchID", decoded.ID)
+	require.NotNil(t, decoded.Body)
+	require.Len(t, decoded.Body.Data, 2)
+	for i, body := range []string{"foo", "bar"} {
+		var batched Message
+		require.NoError(t, batched.UnmarshalBinary(decoded.Body.Data[i]))
+		assert.Equal(t, body, string(batched.Data))
+	}
+
+	_, err = json.Marshal(mb)
+	assert.Error(t, err)
 }
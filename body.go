@@ -0,0 +1,181 @@
+package servicebus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// BodyType identifies the kind of AMQP body section(s) carrying the payload of a Message.
+	BodyType int
+
+	// MessageBody describes the payload of a Message as it is laid out in AMQP body sections: either one or more data
+	// sections, or a single amqp-value section. amqp-sequence sections are not supported by the AMQP transport.
+	//
+	// A Message without a Body, or with a Body of BodyTypeData and no Data sections, is sent with Message.Data as its
+	// single data section. Received messages only carry a Body when the payload is not a single data section, so that
+	// senders and receivers which only use Message.Data are unaffected. If the Data of a received message is changed,
+	// the message is sent with the new Data as its single data section in place of the Body it was received with.
+	MessageBody struct {
+		Type  BodyType
+		Data  [][]byte
+		Value interface{}
+
+		// received is set on the Body of a received message, whose Data is derived from the Body
+		received bool
+	}
+
+	// ErrUnsupportedBodyType is returned when a message body cannot be sent or received with the AMQP transport in use.
+	ErrUnsupportedBodyType BodyType
+)
+
+const (
+	// BodyTypeData is a body of one or more data sections of opaque binary data
+	BodyTypeData BodyType = iota
+	// BodyTypeValue is a body of a single amqp-value section holding any AMQP value, such as a string or a map
+	BodyTypeValue
+)
+
+func (bt BodyType) String() string {
+	switch bt {
+	case BodyTypeData:
+		return "data"
+	case BodyTypeValue:
+		return "amqp-value"
+	default:
+		return fmt.Sprintf("BodyType(%d)", int(bt))
+	}
+}
+
+func (e ErrUnsupportedBodyType) Error() string {
+	return fmt.Sprintf("message body of type %s is not supported by the AMQP transport", BodyType(e))
+}
+
+// NewMessageFromAMQPValue builds a Message whose body is a single amqp-value section holding value.
+func NewMessageFromAMQPValue(value interface{}) *Message {
+	return &Message{
+		Body: &MessageBody{
+			Type:  BodyTypeValue,
+			Value: value,
+		},
+	}
+}
+
+// BodyType returns the kind of body section(s) the Message is sent or was received with.
+func (m *Message) BodyType() BodyType {
+	if m.Body == nil || m.dataChanged() {
+		return BodyTypeData
+	}
+	return m.Body.Type
+}
+
+// DataSections returns the data sections of the Message body, or nil if the body is not made of data sections.
+func (m *Message) DataSections() [][]byte {
+	if m.BodyType() != BodyTypeData {
+		return nil
+	}
+
+	if m.Body != nil && len(m.Body.Data) > 0 && !m.dataChanged() {
+		return m.Body.Data
+	}
+	return [][]byte{m.Data}
+}
+
+// AMQPValue returns the value of an amqp-value body, and whether the Message has such a body.
+func (m *Message) AMQPValue() (interface{}, bool) {
+	if m.BodyType() != BodyTypeValue {
+		return nil, false
+	}
+	return m.Body.Value, true
+}
+
+// SendWithAMQPValueBody configures the message to be sent with a single amqp-value section holding value in place of
+// its Data.
+func SendWithAMQPValueBody(value interface{}) SendOption {
+	return func(m *Message) error {
+		if value == nil {
+			return errors.New("amqp-value body must not be nil")
+		}
+
+		m.Body = &MessageBody{
+			Type:  BodyTypeValue,
+			Value: value,
+		}
+		return nil
+	}
+}
+
+// SendWithDataSections configures the message to be sent with one data section per slice in place of its Data.
+func SendWithDataSections(sections ...[]byte) SendOption {
+	return func(m *Message) error {
+		if len(sections) == 0 {
+			return errors.New("data body must have at least one section")
+		}
+
+		m.Body = &MessageBody{
+			Type: BodyTypeData,
+			Data: sections,
+		}
+		return nil
+	}
+}
+
+// setAMQPBody lays out the body of the Message on the AMQP message, replacing whatever body it carried.
+func (m *Message) setAMQPBody(amqpMsg *amqp.Message) error {
+	amqpMsg.Data = nil
+	amqpMsg.Value = nil
+
+	switch m.BodyType() {
+	case BodyTypeData:
+		amqpMsg.Data = m.DataSections()
+	case BodyTypeValue:
+		if m.Body.Value == nil {
+			return errors.New("amqp-value body must not be nil")
+		}
+		amqpMsg.Value = m.Body.Value
+	default:
+		return ErrUnsupportedBodyType(m.BodyType())
+	}
+	return nil
+}
+
+// bodyFromAMQPMessage returns the payload of an AMQP message as Message.Data, and a MessageBody if the payload is not
+// a single data section.
+func bodyFromAMQPMessage(amqpMsg *amqp.Message) ([]byte, *MessageBody) {
+	var body *MessageBody
+	switch {
+	case amqpMsg.Value != nil:
+		body = &MessageBody{Type: BodyTypeValue, Value: amqpMsg.Value, received: true}
+	case len(amqpMsg.Data) > 1:
+		body = &MessageBody{Type: BodyTypeData, Data: amqpMsg.Data, received: true}
+	default:
+		return amqpMsg.GetData(), nil
+	}
+	return body.data(), body
+}
+
+// data returns the Message.Data a received body is exposed as. Multiple data sections are concatenated. The Data of an
+// amqp-value body holding a string or binary value is that value, and nil for other values.
+func (b *MessageBody) data() []byte {
+	if b.Type == BodyTypeData {
+		return bytes.Join(b.Data, nil)
+	}
+
+	switch v := b.Value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return nil
+	}
+}
+
+// dataChanged reports whether the Data of a received message no longer matches the Body it was received with, in
+// which case the Data is sent in place of the Body.
+func (m *Message) dataChanged() bool {
+	return m.Body != nil && m.Body.received && !bytes.Equal(m.Data, m.Body.data())
+}
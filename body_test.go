@@ -0,0 +1,92 @@
+package servicebus
+
+import (
+	"testing"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripMessage encodes a Message the way it is sent and decodes it the way it is received.
+func roundTripMessage(t *testing.T, m *Message) *Message {
+	amqpMsg, err := m.toMsg()
+	require.NoError(t, err)
+
+	bin, err := amqpMsg.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded amqp.Message
+	require.NoError(t, decoded.UnmarshalBinary(bin))
+
+	received, err := messageFromAMQPMessage(&decoded, nil)
+	require.NoError(t, err)
+	return received
+}
+
+func TestMessageBody_Data(t *testing.T) {
+	received := roundTripMessage(t, NewMessageFromString("foo"))
+	assert.Equal(t, BodyTypeData, received.BodyType())
+	assert.Nil(t, received.Body)
+	assert.Equal(t, []byte("foo"), received.Data)
+	assert.Equal(t, [][]byte{[]byte("foo")}, received.DataSections())
+
+	m := NewMessage(nil)
+	require.NoError(t, SendWithDataSections([]byte("foo"), []byte("bar"))(m))
+	received = roundTripMessage(t, m)
+	assert.Equal(t, BodyTypeData, received.BodyType())
+	assert.Equal(t, [][]byte{[]byte("foo"), []byte("bar")}, received.DataSections())
+	assert.Equal(t, []byte("foobar"), received.Data)
+}
+
+func TestMessageBody_Value(t *testing.T) {
+	received := roundTripMessage(t, NewMessageFromAMQPValue("hello"))
+	assert.Equal(t, BodyTypeValue, received.BodyType())
+	value, ok := received.AMQPValue()
+	assert.True(t, ok)
+	assert.Equal(t, "hello", value)
+	assert.Equal(t, []byte("hello"), received.Data)
+	assert.Nil(t, received.DataSections())
+
+	m := NewMessage(nil)
+	require.NoError(t, SendWithAMQPValueBody(map[string]interface{}{"count": int64(3)})(m))
+	received = roundTripMessage(t, m)
+	value, ok = received.AMQPValue()
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"count": int64(3)}, value)
+
+	// a received value body is sent on unchanged when the message is forwarded
+	received = roundTripMessage(t, received)
+	value, _ = received.AMQPValue()
+	assert.Equal(t, map[string]interface{}{"count": int64(3)}, value)
+
+	assert.Error(t, SendWithAMQPValueBody(nil)(m))
+}
+
+func TestMessageBody_ChangedDataIsSent(t *testing.T) {
+	m := NewMessage(nil)
+	require.NoError(t, SendWithDataSections([]byte("foo"), []byte("bar"))(m))
+	received := roundTripMessage(t, m)
+	received.Data = []byte("baz")
+	assert.Equal(t, [][]byte{[]byte("baz")}, received.DataSections())
+
+	resent := roundTripMessage(t, received)
+	assert.Nil(t, resent.Body)
+	assert.Equal(t, []byte("baz"), resent.Data)
+
+	received = roundTripMessage(t, NewMessageFromAMQPValue("hello"))
+	received.Data = []byte("goodbye")
+	assert.Equal(t, BodyTypeData, received.BodyType())
+	resent = roundTripMessage(t, received)
+	assert.Nil(t, resent.Body)
+	assert.Equal(t, []byte("goodbye"), resent.Data)
+
+	// a Body set before sending still takes the place of Data
+	m = NewMessageFromString("ignored")
+	require.NoError(t, SendWithAMQPValueBody("hello")(m))
+	value, ok := roundTripMessage(t, m).AMQPValue()
+	assert.True(t, ok)
+	assert.Equal(t, "hello", value)
+
+	assert.Equal(t, ErrUnsupportedBodyType(42), (&Message{Body: &MessageBody{Type: 42}}).setAMQPBody(new(amqp.Message)))
+}
@@ -0,0 +1,403 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/devigned/tab"
+)
+
+type (
+	// BufferedSender queues messages in memory and sends them in the background, grouped into MessageBatches by
+	// SessionID. A batch is sent once the next message does not fit in it, or once its first message has waited for
+	// the linger duration. The queue is bounded: Send blocks while it is full.
+	//
+	// The outcome of each message is reported through the SendResult returned by Send, and through the result handler
+	// if one is configured. A BufferedSender is safe for concurrent use.
+	BufferedSender struct {
+		linger        time.Duration
+		sendTimeout   time.Duration
+		resultHandler func(*Message, error)
+		queue         chan bufferedItem
+		done          chan struct{}
+		closed        bool
+		closedMu      sync.RWMutex
+
+		// batchOptions and sendBatch are those of the Sender, and replaceable for testing
+		batchOptions func() *BatchOptions
+		sendBatch    func(context.Context, *MessageBatch) error
+	}
+
+	// BufferedSenderOption provides a way to customize a BufferedSender
+	BufferedSenderOption func(*BufferedSender) error
+
+	// SendResult is the outcome of a message sent through a BufferedSender, available once the message is settled.
+	SendResult struct {
+		msg  *Message
+		err  error
+		done chan struct{}
+	}
+
+	// bufferedItem is either a message to send, or a request to send everything queued before it
+	bufferedItem struct {
+		result *SendResult
+		flush  chan struct{}
+	}
+
+	// bufferedGroup is the batch being filled for a SessionID
+	bufferedGroup struct {
+		sessionID *string
+		batch     *MessageBatch
+		results   []*SendResult
+		deadline  time.Time
+	}
+)
+
+const (
+	// DefaultBufferedSenderLinger is how long a BufferedSender waits for a batch to fill up by default
+	DefaultBufferedSenderLinger = 50 * time.Millisecond
+	// DefaultBufferedSenderQueueSize is the number of messages a BufferedSender queues by default
+	DefaultBufferedSenderQueueSize = 1000
+
+	defaultBufferedSenderSendTimeout = time.Minute
+)
+
+var (
+	// ErrBufferedSenderClosed is returned when a message is sent through a BufferedSender which was closed
+	ErrBufferedSenderClosed = errors.New("buffered sender is closed")
+)
+
+// BufferedSenderWithLinger configures how long the first message of a batch waits for more messages before the batch
+// is sent. The default is DefaultBufferedSenderLinger; a linger of zero sends each message in a batch of its own.
+func BufferedSenderWithLinger(linger time.Duration) BufferedSenderOption {
+	return func(bs *BufferedSender) error {
+		if linger < 0 {
+			return fmt.Errorf("linger must not be negative, but was %v", linger)
+		}
+		bs.linger = linger
+		return nil
+	}
+}
+
+// BufferedSenderWithQueueSize configures how many messages can be queued before Send blocks. The default is
+// DefaultBufferedSenderQueueSize.
+func BufferedSenderWithQueueSize(size int) BufferedSenderOption {
+	return func(bs *BufferedSender) error {
+		if size < 1 {
+			return fmt.Errorf("queue size must be at least 1, but was %d", size)
+		}
+		bs.queue = make(chan bufferedItem, size)
+		return nil
+	}
+}
+
+// BufferedSenderWithResultHandler configures a func called with each message once it is settled, along with the error
+// sending it, if any. It is called from the background goroutine of the BufferedSender, so it must not block.
+func BufferedSenderWithResultHandler(handler func(msg *Message, err error)) BufferedSenderOption {
+	return func(bs *BufferedSender) error {
+		bs.resultHandler = handler
+		return nil
+	}
+}
+
+// BufferedSenderWithSendTimeout configures how long the sending of a batch may take. The default is one minute.
+func BufferedSenderWithSendTimeout(timeout time.Duration) BufferedSenderOption {
+	return func(bs *BufferedSender) error {
+		if timeout <= 0 {
+			return fmt.Errorf("send timeout must be positive, but was %v", timeout)
+		}
+		bs.sendTimeout = timeout
+		return nil
+	}
+}
+
+// NewBufferedSender creates a BufferedSender sending batches with sender. Batches are sized to the maximum message
+// size of sender, and messages are compressed and encrypted as sender transforms them. The BufferedSender must be
+// closed to release its goroutine; closing it does not close sender.
+func NewBufferedSender(sender *Sender, opts ...BufferedSenderOption) (*BufferedSender, error) {
+	if sender == nil {
+		return nil, errors.New("sender must not be nil")
+	}
+
+	// messages are added to batches by the background goroutine, which outlives the context of any call to Send
+	batchOptions := func() *BatchOptions {
+		return sender.batchOptions(context.Background())
+	}
+	return newBufferedSender(batchOptions, sender.SendBatch, opts...)
+}
+
+func newBufferedSender(batchOptions func() *BatchOptions, sendBatch func(context.Context, *MessageBatch) error, opts ...BufferedSenderOption) (*BufferedSender, error) {
+	bs := &BufferedSender{
+		linger:       DefaultBufferedSenderLinger,
+		sendTimeout:  defaultBufferedSenderSendTimeout,
+		queue:        make(chan bufferedItem, DefaultBufferedSenderQueueSize),
+		done:         make(chan struct{}),
+		batchOptions: batchOptions,
+		sendBatch:    sendBatch,
+	}
+
+	for _, opt := range opts {
+		if err := opt(bs); err != nil {
+			return nil, err
+		}
+	}
+
+	go bs.run()
+	return bs, nil
+}
+
+// Send queues msg to be sent in the background, blocking while the queue is full until ctx is done. The returned
+// SendResult reports the outcome of the message once it is settled.
+func (bs *BufferedSender) Send(ctx context.Context, msg *Message) (*SendResult, error) {
+	result := &SendResult{
+		msg:  msg,
+		done: make(chan struct{}),
+	}
+
+	if err := bs.enqueue(ctx, bufferedItem{result: result}); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Flush blocks until every message queued before the call is settled, or until ctx is done.
+func (bs *BufferedSender) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	err := bs.enqueue(ctx, bufferedItem{flush: flushed})
+	if err == ErrBufferedSenderClosed {
+		// closing flushes everything
+		flushed = bs.done
+	} else if err != nil {
+		return err
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the BufferedSender from accepting messages, and blocks until every queued message is settled or until
+// ctx is done. Messages still queued when ctx is done are settled in the background.
+func (bs *BufferedSender) Close(ctx context.Context) error {
+	bs.closedMu.Lock()
+	if !bs.closed {
+		bs.closed = true
+		close(bs.queue)
+	}
+	bs.closedMu.Unlock()
+
+	select {
+	case <-bs.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (bs *BufferedSender) enqueue(ctx context.Context, item bufferedItem) error {
+	bs.closedMu.RLock()
+	defer bs.closedMu.RUnlock()
+
+	if bs.closed {
+		return ErrBufferedSenderClosed
+	}
+
+	select {
+	case bs.queue <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run groups queued messages into batches and sends them until the queue is closed and drained.
+func (bs *BufferedSender) run() {
+	defer close(bs.done)
+
+	groups := make(map[string]*bufferedGroup)
+	for {
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if deadline, ok := nextDeadline(groups); ok {
+			timer = time.NewTimer(time.Until(deadline))
+			timeout = timer.C
+		}
+
+		select {
+		case item, ok := <-bs.queue:
+			if !ok {
+				bs.sendGroups(groups, time.Time{})
+				return
+			}
+
+			if item.flush != nil {
+				bs.sendGroups(groups, time.Time{})
+				close(item.flush)
+			} else {
+				bs.add(groups, item.result)
+			}
+		case now := <-timeout:
+			bs.sendGroups(groups, now)
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// add adds a message to the batch of its SessionID, sending the batch first if the message does not fit in it.
+func (bs *BufferedSender) add(groups map[string]*bufferedGroup, result *SendResult) {
+	var key string
+	if result.msg.SessionID != nil {
+		key = *result.msg.SessionID
+	}
+
+	group, ok := groups[key]
+	if !ok {
+		group = &bufferedGroup{sessionID: result.msg.SessionID}
+		groups[key] = group
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if group.batch == nil {
+			batch, err := bs.newBatch(group.sessionID)
+			if err != nil {
+				bs.settle(result, err)
+				return
+			}
+			group.batch = batch
+		}
+
+		added, err := group.batch.Add(result.msg)
+		if err != nil {
+			bs.settle(result, err)
+			return
+		}
+
+		if added {
+			if len(group.results) == 0 {
+				group.deadline = time.Now().Add(bs.linger)
+			}
+			group.results = append(group.results, result)
+			if bs.linger == 0 {
+				bs.send(group)
+			}
+			return
+		}
+
+		if len(group.results) == 0 {
+			// the batch is empty, so the message will never fit
+			break
+		}
+		bs.send(group)
+	}
+
+	bs.settle(result, fmt.Errorf("message does not fit in a batch of %d bytes", group.batch.MaxSize))
+}
+
+func (bs *BufferedSender) newBatch(sessionID *string) (*MessageBatch, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	// messages without a SessionID are sent in the session of the Sender, if it has one
+	opts := *bs.batchOptions()
+	if sessionID != nil {
+		opts.SessionID = sessionID
+	}
+	return NewMessageBatch(opts.MaxSize, id.String(), &opts), nil
+}
+
+// sendGroups sends the batches whose linger expired by now, or every batch if now is the zero time.
+func (bs *BufferedSender) sendGroups(groups map[string]*bufferedGroup, now time.Time) {
+	for key, group := range groups {
+		if len(group.results) == 0 {
+			delete(groups, key)
+			continue
+		}
+
+		if now.IsZero() || !group.deadline.After(now) {
+			bs.send(group)
+			delete(groups, key)
+		}
+	}
+}
+
+// send sends the batch of the group and settles its messages.
+func (bs *BufferedSender) send(group *bufferedGroup) {
+	ctx, cancel := context.WithTimeout(context.Background(), bs.sendTimeout)
+	defer cancel()
+
+	ctx, span := startProducerSpanFromContext(ctx, "sb.BufferedSender.send")
+	defer span.End()
+
+	err := bs.sendBatch(ctx, group.batch)
+	if err != nil {
+		tab.For(ctx).Error(err)
+	}
+
+	for _, result := range group.results {
+		bs.settle(result, err)
+	}
+	group.batch = nil
+	group.results = nil
+}
+
+func (bs *BufferedSender) settle(result *SendResult, err error) {
+	result.err = err
+	close(result.done)
+
+	if bs.resultHandler != nil {
+		bs.resultHandler(result.msg, err)
+	}
+}
+
+// nextDeadline returns the earliest time a batch should be sent at, if any batch is being filled.
+func nextDeadline(groups map[string]*bufferedGroup) (time.Time, bool) {
+	var next time.Time
+	for _, group := range groups {
+		if len(group.results) > 0 && (next.IsZero() || group.deadline.Before(next)) {
+			next = group.deadline
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// Message returns the message the result is for.
+func (r *SendResult) Message() *Message {
+	return r.msg
+}
+
+// Done returns a channel which is closed once the message is settled.
+func (r *SendResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the error sending the message, once Done is closed.
+func (r *SendResult) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the message is settled and returns the error sending it, or until ctx is done.
+func (r *SendResult) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
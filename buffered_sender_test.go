@@ -0,0 +1,195 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBatchSender struct {
+	maxSize   MaxMessageSizeInBytes
+	sessionID *string
+	err       error
+	block     chan struct{}
+	batches   []*MessageBatch
+	mu        sync.Mutex
+}
+
+func (f *fakeBatchSender) batchOptions() *BatchOptions {
+	return &BatchOptions{MaxSize: f.maxSize, SessionID: f.sessionID}
+}
+
+func (f *fakeBatchSender) sendBatch(ctx context.Context, mb *MessageBatch) error {
+	if f.block != nil {
+		<-f.block
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, mb)
+	return f.err
+}
+
+func (f *fakeBatchSender) batchSizes() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var sizes []int
+	for _, mb := range f.batches {
+		sizes = append(sizes, len(mb.marshaledMessages))
+	}
+	return sizes
+}
+
+func newTestBufferedSender(t *testing.T, f *fakeBatchSender, opts ...BufferedSenderOption) *BufferedSender {
+	if f.maxSize == 0 {
+		f.maxSize = StandardMaxMessageSizeInBytes
+	}
+
+	bs, err := newBufferedSender(f.batchOptions, f.sendBatch, opts...)
+	require.NoError(t, err)
+	return bs
+}
+
+func TestBufferedSender_Linger(t *testing.T) {
+	ctx := context.Background()
+	f := new(fakeBatchSender)
+	bs := newTestBufferedSender(t, f, BufferedSenderWithLinger(20*time.Millisecond))
+	defer bs.Close(ctx)
+
+	var results []*SendResult
+	for i := 0; i < 3; i++ {
+		result, err := bs.Send(ctx, NewMessageFromString("foo"))
+		require.NoError(t, err)
+		results = append(results, result)
+	}
+
+	for _, result := range results {
+		assert.NoError(t, result.Wait(ctx))
+	}
+	assert.Equal(t, []int{3}, f.batchSizes())
+}
+
+func TestBufferedSender_GroupsBySessionAndSize(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeBatchSender{maxSize: 1000}
+	bs := newTestBufferedSender(t, f, BufferedSenderWithLinger(time.Hour))
+
+	for _, session := range []string{"a", "b", "a"} {
+		msg := NewMessageFromString("foo")
+		msg.SessionID = ptrString(session)
+		_, err := bs.Send(ctx, msg)
+		require.NoError(t, err)
+	}
+
+	// one message of 600 bytes fits in a batch of 1000 bytes, but two do not
+	for i := 0; i < 2; i++ {
+		_, err := bs.Send(ctx, NewMessage(make([]byte, 600)))
+		require.NoError(t, err)
+	}
+
+	tooLarge, err := bs.Send(ctx, NewMessage(make([]byte, 1200)))
+	require.NoError(t, err)
+	assert.Error(t, tooLarge.Wait(ctx))
+
+	require.NoError(t, bs.Flush(ctx))
+	assert.ElementsMatch(t, []int{2, 1, 1, 1}, f.batchSizes())
+
+	for _, mb := range f.batches {
+		if mb.SessionID != nil && *mb.SessionID == "a" {
+			assert.Len(t, mb.marshaledMessages, 2)
+		}
+	}
+	require.NoError(t, bs.Close(ctx))
+}
+
+func TestBufferedSender_SenderSession(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeBatchSender{sessionID: ptrString("sender")}
+	bs := newTestBufferedSender(t, f, BufferedSenderWithLinger(time.Hour))
+
+	_, err := bs.Send(ctx, NewMessageFromString("foo"))
+	require.NoError(t, err)
+	msg := NewMessageFromString("bar")
+	msg.SessionID = ptrString("message")
+	_, err = bs.Send(ctx, msg)
+	require.NoError(t, err)
+
+	require.NoError(t, bs.Close(ctx))
+	var sessions []string
+	for _, mb := range f.batches {
+		require.NotNil(t, mb.SessionID)
+		sessions = append(sessions, *mb.SessionID)
+	}
+	assert.ElementsMatch(t, []string{"sender", "message"}, sessions)
+}
+
+func TestBufferedSender_Results(t *testing.T) {
+	ctx := context.Background()
+	sendErr := errors.New("send failed")
+	f := &fakeBatchSender{err: sendErr}
+
+	var handled []error
+	bs := newTestBufferedSender(t, f, BufferedSenderWithResultHandler(func(_ *Message, err error) {
+		handled = append(handled, err)
+	}))
+
+	msg := NewMessageFromString("foo")
+	result, err := bs.Send(ctx, msg)
+	require.NoError(t, err)
+	assert.Nil(t, result.Err(), "the message is not settled yet")
+
+	require.NoError(t, bs.Close(ctx))
+	assert.Equal(t, sendErr, result.Err())
+	assert.Equal(t, msg, result.Message())
+	assert.Equal(t, []error{sendErr}, handled)
+
+	_, err = bs.Send(ctx, msg)
+	assert.Equal(t, ErrBufferedSenderClosed, err)
+	assert.NoError(t, bs.Flush(ctx))
+	assert.NoError(t, bs.Close(ctx))
+}
+
+func TestBufferedSender_BackPressure(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeBatchSender{block: make(chan struct{})}
+	bs := newTestBufferedSender(t, f, BufferedSenderWithLinger(0), BufferedSenderWithQueueSize(1))
+
+	// the first message is being sent, the second is queued
+	for i := 0; i < 2; i++ {
+		_, err := bs.Send(ctx, NewMessageFromString("foo"))
+		require.NoError(t, err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	var err error
+	for err == nil {
+		_, err = bs.Send(timeoutCtx, NewMessageFromString("foo"))
+	}
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(f.block)
+	require.NoError(t, bs.Close(ctx))
+}
+
+func TestBufferedSender_Options(t *testing.T) {
+	_, err := NewBufferedSender(nil)
+	assert.Error(t, err)
+
+	f := new(fakeBatchSender)
+	for _, opt := range []BufferedSenderOption{
+		BufferedSenderWithLinger(-time.Second),
+		BufferedSenderWithQueueSize(0),
+		BufferedSenderWithSendTimeout(0),
+	} {
+		_, err := newBufferedSender(f.batchOptions, f.sendBatch, opt)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,265 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/Azure/go-amqp"
+	"github.com/devigned/tab"
+)
+
+type (
+	// PayloadStore holds message payloads too large to be sent through Service Bus, on behalf of the claim check
+	// configured with SenderWithClaimCheck and ReceiverWithClaimCheck. Implementations must be safe for concurrent use.
+	PayloadStore interface {
+		// Upload stores data and returns the reference it can be downloaded with.
+		Upload(ctx context.Context, data []byte) (string, error)
+		// Download returns the data stored under reference.
+		Download(ctx context.Context, reference string) ([]byte, error)
+		// Delete removes the data stored under reference.
+		Delete(ctx context.Context, reference string) error
+	}
+
+	// FileSystemPayloadStore is a PayloadStore keeping each payload in a file of a directory. It is meant for tests
+	// and for senders and receivers sharing a file system.
+	FileSystemPayloadStore struct {
+		dir string
+	}
+
+	claimCheck struct {
+		store     PayloadStore
+		threshold int
+	}
+)
+
+const (
+	// ClaimCheckProperty is the user property holding the PayloadStore reference of a message whose payload was
+	// replaced by a claim check
+	ClaimCheckProperty = "ClaimCheckReference"
+
+	// claimCheckDiscardTimeout bounds the deletion of the payload of a message which could not be sent
+	claimCheckDiscardTimeout = 30 * time.Second
+)
+
+// SenderWithClaimCheck configures the Sender to upload the payload of messages of more than threshold bytes to store,
+// and to send them with an empty payload and the reference of the upload in the ClaimCheckProperty user property. If
+// the message cannot be sent, the upload is deleted.
+//
+// Only payloads sent as a single data section are claim checked. Batches cannot claim check their messages, so adding
+// a message of more than threshold bytes to a batch of the Sender fails. The messages passed to Send are not
+// modified.
+func SenderWithClaimCheck(store PayloadStore, threshold int) SenderOption {
+	return func(s *Sender) error {
+		if store == nil {
+			return errors.New("payload store must not be nil")
+		}
+
+		if threshold < 0 {
+			return fmt.Errorf("claim check threshold must not be negative, but was %d", threshold)
+		}
+
+		s.claimCheck = &claimCheck{
+			store:     store,
+			threshold: threshold,
+		}
+		return nil
+	}
+}
+
+// ReceiverWithClaimCheck configures the Receiver to download the payload of messages carrying the ClaimCheckProperty
+// user property from store before they are handed to the Handler. The payload is deleted from store once the message
+// is completed, or once it has been handled in ReceiveAndDeleteMode.
+//
+// A message whose payload cannot be downloaded is abandoned, so that it is redelivered. In ReceiveAndDeleteMode, the
+// receiver stops as it would on an error from the Handler.
+func ReceiverWithClaimCheck(store PayloadStore) ReceiverOption {
+	return func(r *Receiver) error {
+		if store == nil {
+			return errors.New("payload store must not be nil")
+		}
+
+		r.payloadStore = store
+		return nil
+	}
+}
+
+// QueueWithClaimCheck configures the Sender and Receiver of the Queue with SenderWithClaimCheck and
+// ReceiverWithClaimCheck. The payloads of messages peeked and of deferred messages received from the Queue are
+// downloaded as well.
+func QueueWithClaimCheck(store PayloadStore, threshold int) QueueOption {
+	return func(q *Queue) error {
+		if err := SenderWithClaimCheck(store, threshold)(new(Sender)); err != nil {
+			return err
+		}
+
+		q.senderOptions = append(q.senderOptions, SenderWithClaimCheck(store, threshold))
+		q.receiverOptions = append(q.receiverOptions, ReceiverWithClaimCheck(store))
+		q.entity.payloadStore = store
+		return nil
+	}
+}
+
+// TopicWithClaimCheck configures the Sender of the Topic with SenderWithClaimCheck. Subscriptions of the topic are
+// configured to download the payloads with SubscriptionWithClaimCheck.
+func TopicWithClaimCheck(store PayloadStore, threshold int) TopicOption {
+	return func(t *Topic) error {
+		if err := SenderWithClaimCheck(store, threshold)(new(Sender)); err != nil {
+			return err
+		}
+
+		t.senderOptions = append(t.senderOptions, SenderWithClaimCheck(store, threshold))
+		return nil
+	}
+}
+
+// SubscriptionWithClaimCheck configures the Receiver of the Subscription with ReceiverWithClaimCheck. The payloads of
+// messages peeked and of deferred messages received from the Subscription are downloaded as well.
+//
+// Every subscription selecting a message receives the same reference, and deletes the payload once it completes its
+// copy of the message, so each message of a claim checked topic must be selected by a single subscription.
+func SubscriptionWithClaimCheck(store PayloadStore) SubscriptionOption {
+	return func(s *Subscription) error {
+		if err := ReceiverWithClaimCheck(store)(new(Receiver)); err != nil {
+			return err
+		}
+
+		s.receiverOptions = append(s.receiverOptions, ReceiverWithClaimCheck(store))
+		s.entity.payloadStore = store
+		return nil
+	}
+}
+
+// apply uploads the payload of the AMQP message if it is a single data section of more than threshold bytes, and
+// replaces it by the reference of the upload, which is returned. The reference is empty if the payload is not
+// claim checked.
+func (cc *claimCheck) apply(ctx context.Context, msg *amqp.Message) (string, error) {
+	if !cc.applies(msg) {
+		return "", nil
+	}
+
+	reference, err := cc.store.Upload(ctx, msg.Data[0])
+	if err != nil {
+		return "", err
+	}
+
+	msg.Data = [][]byte{{}}
+	if msg.ApplicationProperties == nil {
+		msg.ApplicationProperties = make(map[string]interface{})
+	}
+	msg.ApplicationProperties[ClaimCheckProperty] = reference
+	return reference, nil
+}
+
+// refuse returns an error if the payload of the AMQP message would be claim checked. It is applied to the messages
+// added to a batch, which cannot be claim checked.
+func (cc *claimCheck) refuse(msg *amqp.Message) error {
+	if !cc.applies(msg) {
+		return nil
+	}
+	return fmt.Errorf("message payload of %d bytes is over the claim check threshold of %d bytes, and batches cannot claim check messages", len(msg.Data[0]), cc.threshold)
+}
+
+func (cc *claimCheck) applies(msg *amqp.Message) bool {
+	return msg.Value == nil && len(msg.Data) == 1 && len(msg.Data[0]) > cc.threshold
+}
+
+// discardPayload deletes the payload uploaded for a message which could not be sent. The context of the send may be
+// done by then, so the deletion gets a context of its own.
+func (cc *claimCheck) discardPayload(ctx context.Context, reference string) {
+	deleteCtx, cancel := context.WithTimeout(context.Background(), claimCheckDiscardTimeout)
+	defer cancel()
+
+	if err := cc.store.Delete(deleteCtx, reference); err != nil {
+		tab.For(ctx).Error(err)
+	}
+}
+
+// downloadPayload replaces the Data of a message carrying the ClaimCheckProperty user property by the payload it
+// references in store, and removes the property. The message remembers the reference so that the payload can be
+// deleted once the message is completed.
+func (m *Message) downloadPayload(ctx context.Context, store PayloadStore) error {
+	reference, ok := m.UserProperties[ClaimCheckProperty].(string)
+	if !ok {
+		return nil
+	}
+
+	data, err := store.Download(ctx, reference)
+	if err != nil {
+		return err
+	}
+
+	m.Data = data
+	delete(m.UserProperties, ClaimCheckProperty)
+	m.payloadStore = store
+	m.payloadReference = reference
+	return nil
+}
+
+// deletePayload deletes the payload downloaded for the message, if any. The message is already settled, so a failure
+// is only traced.
+func (m *Message) deletePayload(ctx context.Context) {
+	if m.payloadStore == nil {
+		return
+	}
+
+	if err := m.payloadStore.Delete(ctx, m.payloadReference); err != nil {
+		tab.For(ctx).Error(err)
+		return
+	}
+	m.payloadStore = nil
+}
+
+// NewFileSystemPayloadStore creates a FileSystemPayloadStore keeping payloads in dir, which is created if needed.
+func NewFileSystemPayloadStore(dir string) (*FileSystemPayloadStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSystemPayloadStore{dir: dir}, nil
+}
+
+// Upload writes data to a new file named after a random UUID, which is the reference of the payload.
+func (fs *FileSystemPayloadStore) Upload(_ context.Context, data []byte) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	reference := id.String()
+	if err := ioutil.WriteFile(filepath.Join(fs.dir, reference), data, 0600); err != nil {
+		return "", err
+	}
+	return reference, nil
+}
+
+// Download reads the file of the payload.
+func (fs *FileSystemPayloadStore) Download(_ context.Context, reference string) ([]byte, error) {
+	path, err := fs.path(reference)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Delete removes the file of the payload.
+func (fs *FileSystemPayloadStore) Delete(_ context.Context, reference string) error {
+	path, err := fs.path(reference)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// path returns the file of the payload, refusing references which would point outside of the directory of the store.
+func (fs *FileSystemPayloadStore) path(reference string) (string, error) {
+	if reference == "" || reference != filepath.Base(reference) || strings.HasPrefix(reference, ".") {
+		return "", fmt.Errorf("invalid payload reference %q", reference)
+	}
+	return filepath.Join(fs.dir, reference), nil
+}
@@ -0,0 +1,165 @@
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPayloadStore(t *testing.T) (*FileSystemPayloadStore, string) {
+	dir, err := ioutil.TempDir("", "payloads")
+	require.NoError(t, err)
+
+	store, err := NewFileSystemPayloadStore(dir)
+	require.NoError(t, err)
+	return store, dir
+}
+
+func TestFileSystemPayloadStore(t *testing.T) {
+	ctx := context.Background()
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+
+	ref, err := store.Upload(ctx, []byte("payload"))
+	require.NoError(t, err)
+
+	data, err := store.Download(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("payload"), data)
+
+	require.NoError(t, store.Delete(ctx, ref))
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	for _, ref := range []string{"", "..", "../secret", "a/b"} {
+		_, err := store.Download(ctx, ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestSenderWithClaimCheck(t *testing.T) {
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+
+	assert.Error(t, SenderWithClaimCheck(nil, 0)(new(Sender)))
+	assert.Error(t, SenderWithClaimCheck(store, -1)(new(Sender)))
+	assert.Error(t, ReceiverWithClaimCheck(nil)(new(Receiver)))
+
+	ns := new(Namespace)
+	q, err := ns.NewQueue("queue", QueueWithClaimCheck(store, 1024))
+	require.NoError(t, err)
+	assert.Len(t, q.senderOptions, 1)
+	assert.Len(t, q.receiverOptions, 1)
+	assert.Equal(t, store, q.entity.payloadStore)
+
+	_, err = ns.NewTopic("topic", TopicWithClaimCheck(store, -1))
+	assert.Error(t, err)
+	topic, err := ns.NewTopic("topic", TopicWithClaimCheck(store, 1024))
+	require.NoError(t, err)
+	assert.Len(t, topic.senderOptions, 1)
+
+	_, err = topic.NewSubscription("sub", SubscriptionWithClaimCheck(nil))
+	assert.Error(t, err)
+	sub, err := topic.NewSubscription("sub", SubscriptionWithClaimCheck(store))
+	require.NoError(t, err)
+	assert.Len(t, sub.receiverOptions, 1)
+	assert.Equal(t, store, sub.entity.payloadStore)
+}
+
+func TestClaimCheck_DeletedWhenSendFails(t *testing.T) {
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+
+	// the Sender has no link, so sending fails once the payload is uploaded
+	s := &Sender{namespace: &Namespace{throttle: newThrottle()}}
+	require.NoError(t, SenderWithClaimCheck(store, 1024)(s))
+
+	m := NewMessage(bytes.Repeat([]byte("x"), 2048))
+	m.SessionID = ptrString("session")
+	assert.IsType(t, ErrConnectionClosed(""), s.Send(context.Background(), m))
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestClaimCheck_Batch(t *testing.T) {
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+
+	s := new(Sender)
+	require.NoError(t, SenderWithClaimCheck(store, 1024)(s))
+
+	mb := NewMessageBatch(StandardMaxMessageSizeInBytes, "batch", s.batchOptions(context.Background()))
+	added, err := mb.Add(NewMessage(bytes.Repeat([]byte("x"), 1024)))
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	_, err = mb.Add(NewMessage(bytes.Repeat([]byte("x"), 1025)))
+	assert.Error(t, err)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestClaimCheck_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+	payload := bytes.Repeat([]byte("x"), 2048)
+
+	s := new(Sender)
+	require.NoError(t, SenderWithClaimCheck(store, 1024)(s))
+
+	m := NewMessage(payload)
+	om := s.newOutgoingMessage(ctx, m)
+	amqpMsg, err := om.toMsg()
+	require.NoError(t, err)
+	assert.Empty(t, amqpMsg.Data[0])
+	assert.Equal(t, payload, m.Data, "the message sent is not modified")
+
+	received, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+	require.NoError(t, received.downloadPayload(ctx, store))
+	assert.Equal(t, payload, received.Data)
+	assert.NotContains(t, received.UserProperties, ClaimCheckProperty)
+
+	received.deletePayload(ctx)
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	small := s.newOutgoingMessage(ctx, NewMessageFromString("small"))
+	amqpMsg, err = small.toMsg()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("small"), amqpMsg.Data[0])
+	assert.Nil(t, amqpMsg.ApplicationProperties)
+}
+
+func TestClaimCheck_AfterCompression(t *testing.T) {
+	ctx := context.Background()
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+
+	s := new(Sender)
+	require.NoError(t, SenderWithCompression(CompressionGzip, 0)(s))
+	require.NoError(t, SenderWithClaimCheck(store, 1024)(s))
+
+	// compresses well below the claim check threshold
+	om := s.newOutgoingMessage(ctx, NewMessage(bytes.Repeat([]byte("x"), 4096)))
+	amqpMsg, err := om.toMsg()
+	require.NoError(t, err)
+	assert.NotContains(t, amqpMsg.ApplicationProperties, ClaimCheckProperty)
+	assert.Equal(t, string(CompressionGzip), amqpMsg.ApplicationProperties[CompressionProperty])
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
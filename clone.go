@@ -0,0 +1,205 @@
+package servicebus
+
+import (
+	"strings"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// CloneOption provides a way to customize the copy made by Message.Clone
+	CloneOption func(*cloneOptions)
+
+	cloneOptions struct {
+		keepMessageID      bool
+		deadLetterMetadata bool
+	}
+)
+
+const (
+	// annotations prefixed with this are owned by the broker
+	brokerAnnotationPrefix = "x-opt-"
+)
+
+// CloneWithMessageID keeps the ID of the original message on the copy, so that duplicate detection on the destination
+// entity treats the two as the same message. By default, the copy has no ID and is assigned a new one when sent.
+func CloneWithMessageID() CloneOption {
+	return func(o *cloneOptions) {
+		o.keepMessageID = true
+	}
+}
+
+// CloneWithDeadLetterMetadata keeps the DeadLetterReason and DeadLetterErrorDescription user properties written by
+// Service Bus when the message was dead-lettered, and copies the entity it was dead-lettered from into the
+// DeadLetterSource user property. By default, the dead-letter user properties are dropped from the copy.
+func CloneWithDeadLetterMetadata() CloneOption {
+	return func(o *cloneOptions) {
+		o.deadLetterMetadata = true
+	}
+}
+
+// Clone returns a new outgoing Message holding a deep copy of every user-settable field of m, for forwarding,
+// retrying or moving a received message to another entity.
+//
+// Everything assigned by the broker is left out of the copy: the lock token, delivery count, group sequence, delivery
+// annotations and every system property other than PartitionKey and ViaPartitionKey, along with the annotations
+// owned by the broker. The copy is not tied to the receiver of m, so it cannot be completed, abandoned or
+// dead-lettered.
+func (m *Message) Clone(opts ...CloneOption) *Message {
+	var o cloneOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	clone := &Message{
+		ContentType:    m.ContentType,
+		CorrelationID:  m.CorrelationID,
+		Data:           cloneBytes(m.Data),
+		Body:           m.Body.clone(),
+		Label:          m.Label,
+		ReplyTo:        m.ReplyTo,
+		ReplyToGroupID: m.ReplyToGroupID,
+		To:             m.To,
+	}
+
+	if o.keepMessageID {
+		clone.ID = m.ID
+	}
+
+	if m.SessionID != nil {
+		sessionID := *m.SessionID
+		clone.SessionID = &sessionID
+	}
+
+	if m.TTL != nil {
+		ttl := *m.TTL
+		clone.TTL = &ttl
+	}
+
+	if m.AMQP != nil {
+		clone.AMQP = m.AMQP.clone()
+		clone.AMQP.DeliveryAnnotations = nil
+		if !o.keepMessageID {
+			clone.AMQP.MessageID = nil
+		}
+	}
+
+	for key, val := range m.UserProperties {
+		if !o.deadLetterMetadata && (key == DeadLetterReasonProperty || key == DeadLetterErrorDescriptionProperty) {
+			continue
+		}
+		clone.Set(key, val)
+	}
+
+	if sp := m.SystemProperties; sp != nil {
+		if o.deadLetterMetadata && sp.DeadLetterSource != nil {
+			clone.Set(DeadLetterSourceProperty, *sp.DeadLetterSource)
+		}
+		clone.SystemProperties = sp.cloneUserSettable()
+	}
+
+	return clone
+}
+
+// cloneUserSettable copies the system properties a sender may set, or returns nil if there are none.
+func (sp *SystemProperties) cloneUserSettable() *SystemProperties {
+	clone := new(SystemProperties)
+	empty := true
+
+	if sp.PartitionKey != nil {
+		key := *sp.PartitionKey
+		clone.PartitionKey = &key
+		empty = false
+	}
+
+	if sp.ViaPartitionKey != nil {
+		key := *sp.ViaPartitionKey
+		clone.ViaPartitionKey = &key
+		empty = false
+	}
+
+	for key, val := range sp.Annotations {
+		if strings.HasPrefix(key, brokerAnnotationPrefix) {
+			continue
+		}
+
+		if clone.Annotations == nil {
+			clone.Annotations = make(map[string]interface{})
+		}
+		clone.Annotations[key] = val
+		empty = false
+	}
+
+	if empty {
+		return nil
+	}
+	return clone
+}
+
+func (b *MessageBody) clone() *MessageBody {
+	if b == nil {
+		return nil
+	}
+
+	clone := &MessageBody{
+		Type:     b.Type,
+		Value:    b.Value,
+		received: b.received,
+	}
+
+	for _, section := range b.Data {
+		clone.Data = append(clone.Data, cloneBytes(section))
+	}
+	return clone
+}
+
+func (a *AMQPAttributes) clone() *AMQPAttributes {
+	clone := *a
+	clone.UserID = cloneBytes(a.UserID)
+
+	if a.Priority != nil {
+		priority := *a.Priority
+		clone.Priority = &priority
+	}
+
+	if a.AbsoluteExpiryTime != nil {
+		t := *a.AbsoluteExpiryTime
+		clone.AbsoluteExpiryTime = &t
+	}
+
+	if a.CreationTime != nil {
+		t := *a.CreationTime
+		clone.CreationTime = &t
+	}
+
+	if id, ok := a.MessageID.([]byte); ok {
+		clone.MessageID = cloneBytes(id)
+	}
+
+	if id, ok := a.CorrelationID.([]byte); ok {
+		clone.CorrelationID = cloneBytes(id)
+	}
+
+	clone.DeliveryAnnotations = cloneAnnotations(a.DeliveryAnnotations)
+	clone.Footer = cloneAnnotations(a.Footer)
+	return &clone
+}
+
+func cloneAnnotations(a amqp.Annotations) amqp.Annotations {
+	if a == nil {
+		return nil
+	}
+
+	clone := make(amqp.Annotations, len(a))
+	for key, val := range a {
+		clone[key] = val
+	}
+	return clone
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
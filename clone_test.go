@@ -0,0 +1,92 @@
+package servicebus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newReceivedDeadLetter(t *testing.T) *Message {
+	now := time.Now().UTC()
+	amqpMsg := amqp.NewMessage([]byte("payload"))
+	amqpMsg.Properties = &amqp.MessageProperties{
+		MessageID:     "original-id",
+		CorrelationID: "correlation",
+		GroupID:       "session",
+		GroupSequence: 3,
+		Subject:       "label",
+	}
+	amqpMsg.Header = &amqp.MessageHeader{DeliveryCount: 4, Priority: 4}
+	amqpMsg.Annotations = amqp.Annotations{
+		"x-opt-sequence-number":   int64(42),
+		"x-opt-enqueued-time":     now,
+		"x-opt-partition-key":     "pk",
+		"x-opt-deadletter-source": "orders",
+		"custom":                  "annotation",
+	}
+	amqpMsg.DeliveryAnnotations = amqp.Annotations{
+		lockTokenName:  amqp.UUID{1},
+		"x-opt-custom": "delivery",
+	}
+	amqpMsg.ApplicationProperties = map[string]interface{}{
+		"tenant":                           "contoso",
+		DeadLetterReasonProperty:           "MaxDeliveryCountExceeded",
+		DeadLetterErrorDescriptionProperty: "too many deliveries",
+	}
+
+	m, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+	return m
+}
+
+func TestMessage_Clone(t *testing.T) {
+	m := newReceivedDeadLetter(t)
+	require.NotNil(t, m.LockToken)
+
+	clone := m.Clone()
+	assert.Empty(t, clone.ID)
+	assert.Nil(t, clone.LockToken)
+	assert.Nil(t, clone.GroupSequence)
+	assert.Zero(t, clone.DeliveryCount)
+	assert.Equal(t, "session", *clone.SessionID)
+	assert.Equal(t, "label", clone.Label)
+	assert.Equal(t, "correlation", clone.CorrelationID)
+	assert.Equal(t, map[string]interface{}{"tenant": "contoso"}, clone.UserProperties)
+	assert.Equal(t, &SystemProperties{
+		PartitionKey: m.SystemProperties.PartitionKey,
+		Annotations:  map[string]interface{}{"custom": "annotation"},
+	}, clone.SystemProperties)
+	assert.Nil(t, clone.AMQP.DeliveryAnnotations)
+
+	// the copy is deep
+	clone.Data[0] = 'P'
+	assert.Equal(t, []byte("payload"), m.Data)
+
+	sent, err := clone.toMsg()
+	require.NoError(t, err)
+	assert.Nil(t, sent.DeliveryAnnotations)
+	assert.NotContains(t, sent.Annotations, "x-opt-sequence-number")
+	assert.NotContains(t, sent.Annotations, "x-opt-enqueued-time")
+}
+
+func TestMessage_CloneWithOptions(t *testing.T) {
+	m := newReceivedDeadLetter(t)
+
+	clone := m.Clone(CloneWithMessageID(), CloneWithDeadLetterMetadata())
+	assert.Equal(t, "original-id", clone.ID)
+	assert.Equal(t, "MaxDeliveryCountExceeded", clone.UserProperties[DeadLetterReasonProperty])
+	assert.Equal(t, "too many deliveries", clone.UserProperties[DeadLetterErrorDescriptionProperty])
+	assert.Equal(t, "orders", clone.UserProperties[DeadLetterSourceProperty])
+
+	typed := NewMessageFromString("foo")
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	typed.AMQP = &AMQPAttributes{MessageID: amqp.UUID(id)}
+	typed.ID = id.String()
+	assert.Equal(t, amqp.UUID(id), typed.Clone(CloneWithMessageID()).AMQP.MessageID)
+	assert.Nil(t, typed.Clone().AMQP.MessageID)
+}
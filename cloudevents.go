@@ -0,0 +1,370 @@
+package servicebus
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devigned/tab"
+)
+
+type (
+	// CloudEvent is an event expressed in the CloudEvents 1.0 format. The required context attributes are ID, Source,
+	// SpecVersion and Type. Extensions hold any extension context attributes, keyed on their lowercase name.
+	CloudEvent struct {
+		ID              string
+		Source          string
+		SpecVersion     string
+		Type            string
+		DataContentType string
+		DataSchema      string
+		Subject         string
+		Time            *time.Time
+		Extensions      map[string]interface{}
+		Data            []byte
+	}
+
+	// CloudEventMode is the content mode of the CloudEvents AMQP protocol binding used to carry an event in a Message.
+	CloudEventMode int
+
+	// CloudEventHandlerFunc processes a CloudEvent, along with the Message which carried it.
+	CloudEventHandlerFunc func(ctx context.Context, event *CloudEvent, msg *Message) error
+
+	cloudEventHandler struct {
+		fn CloudEventHandlerFunc
+	}
+)
+
+const (
+	// CloudEventBinaryMode carries the event data as the message payload, with the DataContentType as the message
+	// ContentType and every other context attribute as an application property prefixed with "cloudEvents:".
+	CloudEventBinaryMode CloudEventMode = iota
+	// CloudEventStructuredMode carries the whole event as a JSON document in the message payload, with a ContentType
+	// of CloudEventsJSONContentType.
+	CloudEventStructuredMode
+)
+
+const (
+	// CloudEventsSpecVersion is the version of the CloudEvents specification implemented by CloudEvent
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsJSONContentType is the content type of messages carrying an event in structured mode
+	CloudEventsJSONContentType = "application/cloudevents+json"
+
+	// cloudEventsPropertyPrefix prefixes the application properties holding context attributes in binary mode
+	cloudEventsPropertyPrefix = "cloudEvents:"
+	// cloudEventsUnderscorePropertyPrefix is accepted on receive, as some producers follow the later revision of the AMQP
+	// binding which replaced the colon with an underscore
+	cloudEventsUnderscorePropertyPrefix = "cloudEvents_"
+
+	// Dead-letter reason used by the handler returned from NewCloudEventHandler
+	deadLetterReasonInvalidCloudEvent = "InvalidCloudEvent"
+)
+
+// Validate checks the event carries the required context attributes, a supported spec version and well-formed
+// extension names.
+func (e *CloudEvent) Validate() error {
+	switch {
+	case e.ID == "":
+		return errors.New("cloud event must have an id")
+	case e.Source == "":
+		return errors.New("cloud event must have a source")
+	case e.Type == "":
+		return errors.New("cloud event must have a type")
+	case e.SpecVersion != CloudEventsSpecVersion:
+		return fmt.Errorf("cloud event spec version %q is not supported; expected %q", e.SpecVersion, CloudEventsSpecVersion)
+	}
+
+	for name := range e.Extensions {
+		if !isCloudEventAttributeName(name) {
+			return fmt.Errorf("cloud event extension name %q must consist of lowercase letters and digits", name)
+		}
+		if isCloudEventContextAttribute(name) {
+			return fmt.Errorf("cloud event extension name %q collides with a context attribute", name)
+		}
+	}
+	return nil
+}
+
+// NewMessageFromCloudEvent builds a Message carrying event in the given content mode.
+func NewMessageFromCloudEvent(event *CloudEvent, mode CloudEventMode) (*Message, error) {
+	msg := new(Message)
+	if err := SendWithCloudEvent(event, mode)(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// SendWithCloudEvent configures the message to carry event in the given content mode, replacing its Data and
+// ContentType.
+func SendWithCloudEvent(event *CloudEvent, mode CloudEventMode) SendOption {
+	return func(m *Message) error {
+		if event == nil {
+			return errors.New("cloud event must not be nil")
+		}
+
+		if err := event.Validate(); err != nil {
+			return err
+		}
+
+		switch mode {
+		case CloudEventBinaryMode:
+			for name, val := range event.attributes() {
+				if name == "datacontenttype" {
+					continue
+				}
+				m.Set(cloudEventsPropertyPrefix+name, val)
+			}
+			m.ContentType = event.DataContentType
+			m.Data = event.Data
+		case CloudEventStructuredMode:
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			m.ContentType = CloudEventsJSONContentType
+			m.Data = data
+		default:
+			return fmt.Errorf("unknown cloud event mode %d", mode)
+		}
+
+		m.Body = nil
+		return nil
+	}
+}
+
+// CloudEvent parses the CloudEvent carried by the Message in either content mode. Structured mode is recognised from
+// the ContentType of the message; any other message is read in binary mode.
+func (m *Message) CloudEvent() (*CloudEvent, error) {
+	if strings.HasPrefix(normalizeContentType(m.ContentType), "application/cloudevents") {
+		if normalizeContentType(m.ContentType) != CloudEventsJSONContentType {
+			return nil, ErrUnknownContentType(m.ContentType)
+		}
+
+		event := new(CloudEvent)
+		if err := json.Unmarshal(m.Data, event); err != nil {
+			return nil, err
+		}
+		return event, event.Validate()
+	}
+
+	event := &CloudEvent{
+		DataContentType: m.ContentType,
+		Data:            m.Data,
+	}
+
+	for key, val := range m.UserProperties {
+		var name string
+		switch {
+		case strings.HasPrefix(key, cloudEventsPropertyPrefix):
+			name = strings.TrimPrefix(key, cloudEventsPropertyPrefix)
+		case strings.HasPrefix(key, cloudEventsUnderscorePropertyPrefix):
+			name = strings.TrimPrefix(key, cloudEventsUnderscorePropertyPrefix)
+		default:
+			continue
+		}
+
+		if err := event.setAttribute(name, val); err != nil {
+			return nil, err
+		}
+	}
+
+	if event.SpecVersion == "" {
+		return nil, errors.New("message does not carry a cloud event: no spec version application property")
+	}
+	return event, event.Validate()
+}
+
+// NewCloudEventHandler builds a Handler which parses the CloudEvent carried by each message and passes it to fn.
+// Messages which do not carry a valid CloudEvent are dead-lettered.
+func NewCloudEventHandler(fn CloudEventHandlerFunc) Handler {
+	return &cloudEventHandler{fn: fn}
+}
+
+func (h *cloudEventHandler) Handle(ctx context.Context, msg *Message) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.cloudEventHandler.Handle")
+	defer span.End()
+
+	event, err := msg.CloudEvent()
+	if err != nil {
+		return deadLetterWithReason(ctx, msg, ErrorDecodeError, deadLetterReasonInvalidCloudEvent, err)
+	}
+
+	if err := h.fn(ctx, event, msg); err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+// MarshalJSON encodes the event in the CloudEvents JSON format. Data is written as JSON when the DataContentType is a
+// JSON media type and the data is valid JSON, and as base64 otherwise.
+func (e *CloudEvent) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(e.Extensions)+10)
+	for name, val := range e.attributes() {
+		if t, ok := val.(time.Time); ok {
+			val = t.Format(time.RFC3339Nano)
+		}
+		doc[name] = val
+	}
+
+	if e.Data != nil {
+		if isJSONContentType(e.DataContentType) && json.Valid(e.Data) {
+			doc["data"] = json.RawMessage(e.Data)
+		} else {
+			doc["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes an event in the CloudEvents JSON format. JSON data is kept as it is in Data, unless it is a
+// string and the DataContentType is not a JSON media type, in which case Data holds the string.
+func (e *CloudEvent) UnmarshalJSON(data []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	*e = CloudEvent{}
+	for name, raw := range doc {
+		if name == "data" || name == "data_base64" {
+			continue
+		}
+
+		var val interface{}
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return err
+		}
+		if err := e.setAttribute(name, val); err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := doc["data_base64"]; ok {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return fmt.Errorf("cloud event data_base64 must be a string: %v", err)
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+		e.Data = decoded
+	} else if raw, ok := doc["data"]; ok {
+		var s string
+		if !isJSONContentType(e.DataContentType) && json.Unmarshal(raw, &s) == nil {
+			e.Data = []byte(s)
+		} else {
+			e.Data = []byte(raw)
+		}
+	}
+	return nil
+}
+
+// attributes returns the context attributes and extensions which are set on the event, keyed on their name.
+func (e *CloudEvent) attributes() map[string]interface{} {
+	attrs := make(map[string]interface{}, len(e.Extensions)+8)
+	for name, val := range e.Extensions {
+		attrs[name] = val
+	}
+
+	set := func(name, val string) {
+		if val != "" {
+			attrs[name] = val
+		}
+	}
+	set("id", e.ID)
+	set("source", e.Source)
+	set("specversion", e.SpecVersion)
+	set("type", e.Type)
+	set("datacontenttype", e.DataContentType)
+	set("dataschema", e.DataSchema)
+	set("subject", e.Subject)
+	if e.Time != nil {
+		attrs["time"] = *e.Time
+	}
+	return attrs
+}
+
+// setAttribute sets the context attribute or extension called name from a value read from an application property or
+// a JSON document.
+func (e *CloudEvent) setAttribute(name string, val interface{}) error {
+	if !isCloudEventContextAttribute(name) {
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]interface{})
+		}
+		e.Extensions[name] = val
+		return nil
+	}
+
+	if name == "time" {
+		switch t := val.(type) {
+		case time.Time:
+			e.Time = &t
+		case string:
+			parsed, err := time.Parse(time.RFC3339Nano, t)
+			if err != nil {
+				return fmt.Errorf("cloud event time %q is not an RFC 3339 timestamp", t)
+			}
+			e.Time = &parsed
+		default:
+			return fmt.Errorf("cloud event time must be a timestamp, but was %T", val)
+		}
+		return nil
+	}
+
+	s, ok := val.(string)
+	if !ok {
+		return fmt.Errorf("cloud event attribute %q must be a string, but was %T", name, val)
+	}
+
+	switch name {
+	case "id":
+		e.ID = s
+	case "source":
+		e.Source = s
+	case "specversion":
+		e.SpecVersion = s
+	case "type":
+		e.Type = s
+	case "datacontenttype":
+		e.DataContentType = s
+	case "dataschema":
+		e.DataSchema = s
+	case "subject":
+		e.Subject = s
+	}
+	return nil
+}
+
+func isCloudEventContextAttribute(name string) bool {
+	switch name {
+	case "id", "source", "specversion", "type", "datacontenttype", "dataschema", "subject", "time":
+		return true
+	}
+	return false
+}
+
+func isCloudEventAttributeName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// isJSONContentType reports whether contentType is a JSON media type. An event without a content type is JSON.
+func isJSONContentType(contentType string) bool {
+	ct := normalizeContentType(contentType)
+	return ct == "" || ct == JSONContentType || strings.HasSuffix(ct, "+json")
+}
@@ -0,0 +1,108 @@
+package servicebus
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCloudEvent() *CloudEvent {
+	at := time.Date(2020, 4, 1, 12, 30, 0, 0, time.UTC)
+	return &CloudEvent{
+		ID:              "A234-1234-1234",
+		Source:          "/mycontext",
+		SpecVersion:     CloudEventsSpecVersion,
+		Type:            "com.example.someevent",
+		DataContentType: JSONContentType,
+		Subject:         "larger-context",
+		Time:            &at,
+		Extensions:      map[string]interface{}{"comexampleextension1": "value"},
+		Data:            []byte(`{"appinfoA":"abc"}`),
+	}
+}
+
+func TestCloudEvent_BinaryMode(t *testing.T) {
+	event := newTestCloudEvent()
+
+	msg, err := NewMessageFromCloudEvent(event, CloudEventBinaryMode)
+	require.NoError(t, err)
+	assert.Equal(t, JSONContentType, msg.ContentType)
+	assert.Equal(t, event.Data, msg.Data)
+	assert.Equal(t, "A234-1234-1234", msg.UserProperties["cloudEvents:id"])
+	assert.Equal(t, *event.Time, msg.UserProperties["cloudEvents:time"])
+	assert.Equal(t, "value", msg.UserProperties["cloudEvents:comexampleextension1"])
+	assert.NotContains(t, msg.UserProperties, "cloudEvents:datacontenttype")
+
+	parsed, err := msg.CloudEvent()
+	require.NoError(t, err)
+	assert.Equal(t, event, parsed)
+
+	msg = NewMessageFromString("not an event")
+	_, err = msg.CloudEvent()
+	assert.Error(t, err)
+}
+
+func TestCloudEvent_StructuredMode(t *testing.T) {
+	event := newTestCloudEvent()
+
+	msg, err := NewMessageFromCloudEvent(event, CloudEventStructuredMode)
+	require.NoError(t, err)
+	assert.Equal(t, CloudEventsJSONContentType, msg.ContentType)
+	assert.Empty(t, msg.UserProperties)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(msg.Data, &doc))
+	assert.Equal(t, map[string]interface{}{"appinfoA": "abc"}, doc["data"])
+	assert.Equal(t, "2020-04-01T12:30:00Z", doc["time"])
+	assert.Equal(t, "value", doc["comexampleextension1"])
+
+	parsed, err := msg.CloudEvent()
+	require.NoError(t, err)
+	assert.Equal(t, event, parsed)
+
+	event.DataContentType = "application/octet-stream"
+	event.Data = []byte{0, 1, 2}
+	msg, err = NewMessageFromCloudEvent(event, CloudEventStructuredMode)
+	require.NoError(t, err)
+	assert.Contains(t, string(msg.Data), `"data_base64":"AAEC"`)
+
+	parsed, err = msg.CloudEvent()
+	require.NoError(t, err)
+	assert.Equal(t, event.Data, parsed.Data)
+}
+
+func TestCloudEvent_Validate(t *testing.T) {
+	event := newTestCloudEvent()
+	event.SpecVersion = "0.3"
+	_, err := NewMessageFromCloudEvent(event, CloudEventBinaryMode)
+	assert.Error(t, err)
+
+	event = newTestCloudEvent()
+	event.Extensions["Bad-Name"] = 1
+	_, err = NewMessageFromCloudEvent(event, CloudEventStructuredMode)
+	assert.Error(t, err)
+
+	event = newTestCloudEvent()
+	event.Source = ""
+	assert.Error(t, SendWithCloudEvent(event, CloudEventBinaryMode)(new(Message)))
+}
+
+func TestCloudEventHandler(t *testing.T) {
+	event := newTestCloudEvent()
+	msg, err := NewMessageFromCloudEvent(event, CloudEventBinaryMode)
+	require.NoError(t, err)
+
+	var received *CloudEvent
+	handler := NewCloudEventHandler(func(_ context.Context, e *CloudEvent, m *Message) error {
+		received = e
+		assert.Equal(t, msg, m)
+		return nil
+	})
+
+	require.NoError(t, handler.Handle(context.Background(), msg))
+	assert.Equal(t, event, received)
+}
@@ -0,0 +1,312 @@
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/devigned/tab"
+	"github.com/golang/protobuf/proto"
+)
+
+type (
+	// Codec marshals values into message payloads and back. The content type of a Codec is stamped on the messages it
+	// builds, and is used to find the Codec again when a message is decoded.
+	Codec interface {
+		ContentType() string
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(data []byte, v interface{}) error
+	}
+
+	// CodecRegistry is a set of Codecs keyed on the content type they handle. It is safe for concurrent use.
+	CodecRegistry struct {
+		codecs   map[string]Codec
+		codecsMu sync.RWMutex
+	}
+
+	// TypedHandler is a Handler which dispatches messages on their Label to callbacks registered for a Go type. The
+	// payload of each message is decoded into a new value of that type with the Codec matching the message's
+	// ContentType before the callback is invoked. Messages which have no matching callback, no matching Codec or which
+	// fail to decode are dead-lettered with a reason describing the failure.
+	TypedHandler struct {
+		registry *CodecRegistry
+		routes   map[string]typedRoute
+		routesMu sync.RWMutex
+		fallback Handler
+	}
+
+	// TypedHandlerOption provides a way to customize a TypedHandler
+	TypedHandlerOption func(*TypedHandler) error
+
+	typedRoute struct {
+		valueType reflect.Type
+		callback  reflect.Value
+	}
+
+	jsonCodec     struct{}
+	gobCodec      struct{}
+	protobufCodec struct{}
+)
+
+const (
+	// JSONContentType is the content type of messages encoded with JSONCodec
+	JSONContentType = "application/json"
+	// GobContentType is the content type of messages encoded with GobCodec
+	GobContentType = "application/x-gob"
+	// ProtobufContentType is the content type of messages encoded with ProtobufCodec
+	ProtobufContentType = "application/x-protobuf"
+
+	// Dead-letter reasons used by TypedHandler
+	deadLetterReasonNoHandler          = "NoHandlerForLabel"
+	deadLetterReasonUnknownContentType = "UnknownContentType"
+	deadLetterReasonDecodeFailure      = "DecodeFailure"
+)
+
+var (
+	// JSONCodec encodes values with encoding/json
+	JSONCodec Codec = jsonCodec{}
+	// GobCodec encodes values with encoding/gob
+	GobCodec Codec = gobCodec{}
+	// ProtobufCodec encodes values implementing proto.Message
+	ProtobufCodec Codec = protobufCodec{}
+
+	// DefaultCodecRegistry is the registry used by Message.Decode. It ships with JSONCodec, GobCodec and ProtobufCodec.
+	DefaultCodecRegistry = NewCodecRegistry(JSONCodec, GobCodec, ProtobufCodec)
+
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfMessage = reflect.TypeOf((*Message)(nil))
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// NewCodecRegistry creates a CodecRegistry holding the given Codecs.
+func NewCodecRegistry(codecs ...Codec) *CodecRegistry {
+	r := &CodecRegistry{
+		codecs: make(map[string]Codec, len(codecs)),
+	}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// Register adds a Codec to the registry, replacing any Codec already registered for the same content type.
+func (r *CodecRegistry) Register(c Codec) {
+	r.codecsMu.Lock()
+	defer r.codecsMu.Unlock()
+
+	r.codecs[normalizeContentType(c.ContentType())] = c
+}
+
+// Lookup finds the Codec registered for a content type. Media type parameters, such as a charset, are ignored.
+func (r *CodecRegistry) Lookup(contentType string) (Codec, bool) {
+	r.codecsMu.RLock()
+	defer r.codecsMu.RUnlock()
+
+	c, ok := r.codecs[normalizeContentType(contentType)]
+	return c, ok
+}
+
+// Decode unmarshals the payload of the message into v with the Codec registered for the message's ContentType.
+func (r *CodecRegistry) Decode(m *Message, v interface{}) error {
+	c, ok := r.Lookup(m.ContentType)
+	if !ok {
+		return ErrUnknownContentType(m.ContentType)
+	}
+
+	if err := c.Unmarshal(m.Data, v); err != nil {
+		return ErrDecodeFailure{ContentType: m.ContentType, Err: err}
+	}
+	return nil
+}
+
+func normalizeContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// NewMessageFromValue builds a Message whose payload is v marshaled with codec, and whose ContentType is the content
+// type of the codec.
+func NewMessageFromValue(v interface{}, codec Codec) (*Message, error) {
+	if codec == nil {
+		return nil, errors.New("codec must not be nil")
+	}
+
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := NewMessage(data)
+	msg.ContentType = codec.ContentType()
+	return msg, nil
+}
+
+// Decode unmarshals the payload of the message into v with the Codec registered in DefaultCodecRegistry for the
+// message's ContentType.
+func (m *Message) Decode(v interface{}) error {
+	return DefaultCodecRegistry.Decode(m, v)
+}
+
+func (jsonCodec) ContentType() string {
+	return JSONContentType
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (gobCodec) ContentType() string {
+	return GobContentType
+}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (protobufCodec) ContentType() string {
+	return ProtobufContentType
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	pb, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T does not implement proto.Message", v)
+	}
+	return proto.Marshal(pb)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	pb, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T does not implement proto.Message", v)
+	}
+	return proto.Unmarshal(data, pb)
+}
+
+// NewTypedHandler creates a TypedHandler which decodes messages with the Codecs of DefaultCodecRegistry.
+func NewTypedHandler(opts ...TypedHandlerOption) (*TypedHandler, error) {
+	th := &TypedHandler{
+		registry: DefaultCodecRegistry,
+		routes:   make(map[string]typedRoute),
+	}
+
+	for _, opt := range opts {
+		if err := opt(th); err != nil {
+			return nil, err
+		}
+	}
+	return th, nil
+}
+
+// TypedHandlerWithRegistry configures the TypedHandler to decode messages with the Codecs of registry.
+func TypedHandlerWithRegistry(registry *CodecRegistry) TypedHandlerOption {
+	return func(th *TypedHandler) error {
+		if registry == nil {
+			return errors.New("registry must not be nil")
+		}
+		th.registry = registry
+		return nil
+	}
+}
+
+// TypedHandlerWithFallback configures the TypedHandler to pass messages whose Label has no registered callback to
+// handler rather than dead-lettering them.
+func TypedHandlerWithFallback(handler Handler) TypedHandlerOption {
+	return func(th *TypedHandler) error {
+		th.fallback = handler
+		return nil
+	}
+}
+
+// Register associates a callback with messages carrying label. The callback must have the signature
+//
+//	func(ctx context.Context, msg *Message, v *T) error
+//
+// where T is the type the payload is decoded into. A new value of T is allocated for each message.
+func (th *TypedHandler) Register(label string, callback interface{}) error {
+	if callback == nil {
+		return fmt.Errorf("callback for label %q must not be nil", label)
+	}
+
+	fn := reflect.ValueOf(callback)
+	ft := fn.Type()
+	if ft.Kind() != reflect.Func ||
+		ft.NumIn() != 3 || ft.In(0) != typeOfContext || ft.In(1) != typeOfMessage || ft.In(2).Kind() != reflect.Ptr ||
+		ft.NumOut() != 1 || ft.Out(0) != typeOfError {
+		return fmt.Errorf("callback for label %q must be of type func(context.Context, *Message, *T) error, but was %s", label, ft)
+	}
+
+	th.routesMu.Lock()
+	defer th.routesMu.Unlock()
+
+	th.routes[label] = typedRoute{
+		valueType: ft.In(2).Elem(),
+		callback:  fn,
+	}
+	return nil
+}
+
+// Handle decodes the message and passes it to the callback registered for its Label.
+func (th *TypedHandler) Handle(ctx context.Context, msg *Message) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.TypedHandler.Handle")
+	defer span.End()
+
+	th.routesMu.RLock()
+	route, ok := th.routes[msg.Label]
+	th.routesMu.RUnlock()
+
+	if !ok {
+		if th.fallback != nil {
+			return th.fallback.Handle(ctx, msg)
+		}
+		err := fmt.Errorf("no handler is registered for label %q", msg.Label)
+		return deadLetterWithReason(ctx, msg, ErrorNotImplemented, deadLetterReasonNoHandler, err)
+	}
+
+	v := reflect.New(route.valueType)
+	if err := th.registry.Decode(msg, v.Interface()); err != nil {
+		reason := deadLetterReasonDecodeFailure
+		if _, ok := err.(ErrUnknownContentType); ok {
+			reason = deadLetterReasonUnknownContentType
+		}
+		return deadLetterWithReason(ctx, msg, ErrorDecodeError, reason, err)
+	}
+
+	out := route.callback.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg), v})
+	if err, _ := out[0].Interface().(error); err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+	return nil
+}
+
+// deadLetterWithReason dead-letters a message which can never be handled, recording reason as its dead-letter reason.
+// The message is settled, so the receive loop carries on unless the disposition itself fails.
+func deadLetterWithReason(ctx context.Context, msg *Message, condition MessageErrorCondition, reason string, err error) error {
+	tab.For(ctx).Error(err)
+	return msg.DeadLetterWithInfo(ctx, err, condition, map[string]string{
+		DeadLetterReasonProperty:           reason,
+		DeadLetterErrorDescriptionProperty: err.Error(),
+	})
+}
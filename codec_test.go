@@ -0,0 +1,93 @@
+package servicebus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type codecTestPayload struct {
+	Name  string
+	Count int
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			msg, err := NewMessageFromValue(codecTestPayload{Name: "foo", Count: 3}, codec)
+			require.NoError(t, err)
+			assert.Equal(t, codec.ContentType(), msg.ContentType)
+
+			var got codecTestPayload
+			require.NoError(t, msg.Decode(&got))
+			assert.Equal(t, codecTestPayload{Name: "foo", Count: 3}, got)
+		})
+	}
+
+	t.Run(ProtobufContentType, func(t *testing.T) {
+		msg, err := NewMessageFromValue(&wrappers.StringValue{Value: "foo"}, ProtobufCodec)
+		require.NoError(t, err)
+
+		var got wrappers.StringValue
+		require.NoError(t, msg.Decode(&got))
+		assert.Equal(t, "foo", got.Value)
+
+		_, err = NewMessageFromValue(codecTestPayload{}, ProtobufCodec)
+		assert.Error(t, err)
+	})
+}
+
+func TestCodecRegistry_Lookup(t *testing.T) {
+	r := NewCodecRegistry(JSONCodec)
+
+	c, ok := r.Lookup("Application/JSON; charset=utf-8")
+	assert.True(t, ok)
+	assert.Equal(t, JSONCodec, c)
+
+	_, ok = r.Lookup(GobContentType)
+	assert.False(t, ok)
+}
+
+func TestMessage_DecodeErrors(t *testing.T) {
+	msg := NewMessageFromString("{}")
+	msg.ContentType = "text/plain"
+	var v codecTestPayload
+	assert.Equal(t, ErrUnknownContentType("text/plain"), msg.Decode(&v))
+
+	msg.ContentType = JSONContentType
+	msg.Data = []byte("not json")
+	err := msg.Decode(&v)
+	assert.IsType(t, ErrDecodeFailure{}, err)
+}
+
+func TestTypedHandler(t *testing.T) {
+	var fallbackCalled bool
+	th, err := NewTypedHandler(TypedHandlerWithFallback(HandlerFunc(func(context.Context, *Message) error {
+		fallbackCalled = true
+		return nil
+	})))
+	require.NoError(t, err)
+
+	assert.Error(t, th.Register("bad", func(v codecTestPayload) error { return nil }))
+	assert.Error(t, th.Register("nil", nil))
+
+	var got *codecTestPayload
+	require.NoError(t, th.Register("payload", func(ctx context.Context, msg *Message, v *codecTestPayload) error {
+		got = v
+		return nil
+	}))
+
+	msg, err := NewMessageFromValue(codecTestPayload{Name: "bar"}, JSONCodec)
+	require.NoError(t, err)
+	msg.Label = "payload"
+	require.NoError(t, th.Handle(context.Background(), msg))
+	require.NotNil(t, got)
+	assert.Equal(t, "bar", got.Name)
+
+	msg.Label = "other"
+	require.NoError(t, th.Handle(context.Background(), msg))
+	assert.True(t, fallbackCalled)
+}
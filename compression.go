@@ -0,0 +1,176 @@
+package servicebus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Azure/go-amqp"
+	"github.com/klauspost/compress/zstd"
+)
+
+type (
+	// CompressionAlgorithm identifies the algorithm used to compress message payloads. Its value is written to the
+	// CompressionProperty user property of compressed messages.
+	CompressionAlgorithm string
+
+	// Compression configures the compression of message payloads. Payloads of at least Threshold bytes are compressed
+	// with Algorithm; smaller payloads are sent as they are.
+	Compression struct {
+		Algorithm CompressionAlgorithm
+		Threshold int
+	}
+)
+
+const (
+	// CompressionGzip compresses payloads with gzip
+	CompressionGzip CompressionAlgorithm = "gzip"
+	// CompressionZstd compresses payloads with Zstandard
+	CompressionZstd CompressionAlgorithm = "zstd"
+
+	// CompressionProperty is the user property naming the algorithm the payload of a message was compressed with
+	CompressionProperty = "ServiceBusGoCompression"
+
+	// Dead-letter reason of messages whose payload cannot be decompressed
+	deadLetterReasonDecompressionFailed = "DecompressionFailed"
+)
+
+var (
+	zstdEncoder     *zstd.Encoder
+	zstdDecoder     *zstd.Decoder
+	zstdErr         error
+	zstdInitialized sync.Once
+)
+
+// SenderWithCompression configures the Sender to compress the payload of messages of at least threshold bytes with
+// algorithm, and to mark them with the CompressionProperty user property. Receivers decompress marked messages
+// before they are handed over, so compression is transparent to both ends.
+//
+// Only payloads sent as a single data section are compressed. The messages passed to Send are not modified.
+func SenderWithCompression(algorithm CompressionAlgorithm, threshold int) SenderOption {
+	return func(s *Sender) error {
+		c := &Compression{
+			Algorithm: algorithm,
+			Threshold: threshold,
+		}
+
+		if err := c.validate(); err != nil {
+			return err
+		}
+		s.compression = c
+		return nil
+	}
+}
+
+func (c *Compression) validate() error {
+	switch c.Algorithm {
+	case CompressionGzip, CompressionZstd:
+	default:
+		return fmt.Errorf("unknown compression algorithm %q", c.Algorithm)
+	}
+
+	if c.Threshold < 0 {
+		return fmt.Errorf("compression threshold must not be negative, but was %d", c.Threshold)
+	}
+	return nil
+}
+
+// apply compresses the payload of the AMQP message in place if it is a single data section of at least Threshold
+// bytes. A nil Compression does nothing.
+func (c *Compression) apply(msg *amqp.Message) error {
+	if c == nil || msg.Value != nil || len(msg.Data) != 1 || len(msg.Data[0]) < c.Threshold {
+		return nil
+	}
+
+	compressed, err := compress(c.Algorithm, msg.Data[0])
+	if err != nil {
+		return err
+	}
+
+	msg.Data = [][]byte{compressed}
+	if msg.ApplicationProperties == nil {
+		msg.ApplicationProperties = make(map[string]interface{})
+	}
+	msg.ApplicationProperties[CompressionProperty] = string(c.Algorithm)
+	return nil
+}
+
+// decompressData replaces the Data of a received message marked with the CompressionProperty user property by its
+// decompressed form, and removes the property. A message which cannot be decompressed is left as it is, property
+// included, and ErrDecompressionFailed is returned. Messages whose payload is still claim checked or encrypted are left
+// as they are, since their Data is not the compressed payload.
+func (m *Message) decompressData() error {
+	algorithm, ok := m.UserProperties[CompressionProperty].(string)
+	if !ok || m.isEncrypted() {
+		return nil
+	}
+
+	if _, ok := m.UserProperties[ClaimCheckProperty]; ok {
+		return nil
+	}
+
+	data, err := decompress(CompressionAlgorithm(algorithm), m.Data)
+	if err != nil {
+		return ErrDecompressionFailed{Algorithm: CompressionAlgorithm(algorithm), Err: err}
+	}
+
+	m.Data = data
+	delete(m.UserProperties, CompressionProperty)
+	return nil
+}
+
+func compress(algorithm CompressionAlgorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", algorithm)
+	}
+}
+
+func decompress(algorithm CompressionAlgorithm, data []byte) ([]byte, error) {
+	switch algorithm {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", algorithm)
+	}
+}
+
+// initZstd builds the shared zstd encoder and decoder, which are safe for concurrent use through EncodeAll and
+// DecodeAll, on first use.
+func initZstd() error {
+	zstdInitialized.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
@@ -0,0 +1,93 @@
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/go-amqp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderWithCompression(t *testing.T) {
+	s := new(Sender)
+	require.NoError(t, SenderWithCompression(CompressionZstd, 1024)(s))
+	assert.Equal(t, &Compression{Algorithm: CompressionZstd, Threshold: 1024}, s.compression)
+
+	assert.Error(t, SenderWithCompression("lz4", 0)(s))
+	assert.Error(t, SenderWithCompression(CompressionGzip, -1)(s))
+}
+
+func TestCompression_RoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"field":"value"},`), 1000)
+
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			m := NewMessage(payload)
+			m.Set("key", "value")
+			c := &Compression{Algorithm: algorithm, Threshold: 1024}
+			om := &outgoingMessage{Message: m, transforms: []func(*amqp.Message) error{c.apply}}
+
+			amqpMsg, err := om.toMsg()
+			require.NoError(t, err)
+			assert.Less(t, len(amqpMsg.Data[0]), len(payload))
+			assert.Equal(t, string(algorithm), amqpMsg.ApplicationProperties[CompressionProperty])
+			assert.Equal(t, payload, m.Data, "the message sent is not modified")
+			assert.NotContains(t, m.UserProperties, CompressionProperty)
+
+			received, err := messageFromAMQPMessage(amqpMsg, nil)
+			require.NoError(t, err)
+			require.NoError(t, new(Receiver).restorePayload(context.Background(), received))
+			assert.Equal(t, payload, received.Data)
+			assert.Equal(t, map[string]interface{}{"key": "value"}, received.UserProperties)
+		})
+	}
+}
+
+func TestCompression_PassThrough(t *testing.T) {
+	c := &Compression{Algorithm: CompressionGzip, Threshold: 1024}
+
+	small := &outgoingMessage{Message: NewMessageFromString("small"), transforms: []func(*amqp.Message) error{c.apply}}
+	amqpMsg, err := small.toMsg()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("small"), amqpMsg.Data[0])
+	assert.Nil(t, amqpMsg.ApplicationProperties)
+}
+
+func TestCompression_DecompressionFailure(t *testing.T) {
+	// a message claiming to be compressed which is not is left as it is
+	bogus := NewMessageFromString("not gzip")
+	bogus.Set(CompressionProperty, string(CompressionGzip))
+	amqpMsg, err := bogus.toMsg()
+	require.NoError(t, err)
+
+	received, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+	err = new(Receiver).restorePayload(context.Background(), received)
+	var decompressionErr ErrDecompressionFailed
+	require.True(t, errors.As(err, &decompressionErr))
+	assert.Equal(t, CompressionGzip, decompressionErr.Algorithm)
+	assert.Equal(t, []byte("not gzip"), received.Data)
+	assert.Equal(t, string(CompressionGzip), received.UserProperties[CompressionProperty])
+}
+
+func TestMessageBatch_AddCompressed(t *testing.T) {
+	payload := bytes.Repeat([]byte("compressible "), 1000)
+
+	plain := NewMessageBatch(StandardMaxMessageSizeInBytes, "plain", nil)
+	ok, err := plain.Add(NewMessage(payload))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	compressed := NewMessageBatch(StandardMaxMessageSizeInBytes, "compressed", &BatchOptions{
+		Compression: &Compression{Algorithm: CompressionZstd},
+	})
+	ok, err = compressed.Add(NewMessage(payload))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	assert.Less(t, compressed.Size(), plain.Size()/10)
+}
@@ -0,0 +1,270 @@
+package servicebus
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// KeyProvider wraps and unwraps the data keys messages are encrypted with, typically with a key encryption key
+	// held in a key management service. Implementations must be safe for concurrent use.
+	KeyProvider interface {
+		// WrapKey encrypts dataKey, returning the ID of the key encryption key used along with the wrapped key.
+		WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrappedKey []byte, err error)
+		// UnwrapKey decrypts a data key wrapped with the key encryption key identified by keyID.
+		UnwrapKey(ctx context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+	}
+
+	// aesKeyProvider wraps data keys with AES-GCM under a single local key encryption key
+	aesKeyProvider struct {
+		keyID string
+		aead  cipher.AEAD
+	}
+)
+
+const (
+	// EncryptionAlgorithmAES256GCM is the algorithm message payloads are encrypted with
+	EncryptionAlgorithmAES256GCM = "AES256-GCM"
+
+	// EncryptionAlgorithmProperty is the user property naming the algorithm the payload of a message is encrypted with
+	EncryptionAlgorithmProperty = "EncryptionAlgorithm"
+	// EncryptionKeyIDProperty is the user property holding the ID of the key encryption key the data key of a message
+	// is wrapped with
+	EncryptionKeyIDProperty = "EncryptionKeyID"
+	// EncryptionWrappedKeyProperty is the user property holding the wrapped data key of a message
+	EncryptionWrappedKeyProperty = "EncryptionWrappedKey"
+
+	dataKeySize = 32
+
+	// Dead-letter reason of messages which cannot be decrypted
+	deadLetterReasonDecryptionFailed = "DecryptionFailed"
+)
+
+// SenderWithEncryption configures the Sender to encrypt the payload of messages with AES-GCM under a new data key for
+// each message. The data key is wrapped by keyProvider and sent in the EncryptionWrappedKeyProperty user property,
+// along with the EncryptionKeyIDProperty and EncryptionAlgorithmProperty user properties.
+//
+// Payloads are encrypted after compression and before the claim check, if those are configured, and so are the
+// messages added to the batches of the Sender. Only payloads sent as a single data section can be encrypted: sending
+// or batching a message with an amqp-value body or several data sections fails. The messages passed to Send are not
+// modified.
+func SenderWithEncryption(keyProvider KeyProvider) SenderOption {
+	return func(s *Sender) error {
+		if keyProvider == nil {
+			return errors.New("key provider must not be nil")
+		}
+
+		s.keyProvider = keyProvider
+		return nil
+	}
+}
+
+// ReceiverWithDecryption configures the Receiver to decrypt the payload of messages encrypted by a Sender configured
+// with SenderWithEncryption before they are handed to the Handler. Messages which cannot be decrypted are
+// dead-lettered with a DeadLetterReason of "DecryptionFailed". In ReceiveAndDeleteMode, the receiver stops as it
+// would on an error from the Handler.
+func ReceiverWithDecryption(keyProvider KeyProvider) ReceiverOption {
+	return func(r *Receiver) error {
+		if keyProvider == nil {
+			return errors.New("key provider must not be nil")
+		}
+
+		r.keyProvider = keyProvider
+		return nil
+	}
+}
+
+// QueueWithEncryption configures the Sender and Receiver of the Queue with SenderWithEncryption and
+// ReceiverWithDecryption. Messages peeked and deferred messages received from the Queue are decrypted as well.
+func QueueWithEncryption(keyProvider KeyProvider) QueueOption {
+	return func(q *Queue) error {
+		if keyProvider == nil {
+			return errors.New("key provider must not be nil")
+		}
+
+		q.senderOptions = append(q.senderOptions, SenderWithEncryption(keyProvider))
+		q.receiverOptions = append(q.receiverOptions, ReceiverWithDecryption(keyProvider))
+		q.entity.keyProvider = keyProvider
+		return nil
+	}
+}
+
+// TopicWithEncryption configures the Sender of the Topic with SenderWithEncryption. Subscriptions of the topic are
+// configured to decrypt the payloads with SubscriptionWithDecryption.
+func TopicWithEncryption(keyProvider KeyProvider) TopicOption {
+	return func(t *Topic) error {
+		if keyProvider == nil {
+			return errors.New("key provider must not be nil")
+		}
+
+		t.senderOptions = append(t.senderOptions, SenderWithEncryption(keyProvider))
+		return nil
+	}
+}
+
+// SubscriptionWithDecryption configures the Receiver of the Subscription with ReceiverWithDecryption. Messages peeked
+// and deferred messages received from the Subscription are decrypted as well.
+func SubscriptionWithDecryption(keyProvider KeyProvider) SubscriptionOption {
+	return func(s *Subscription) error {
+		if keyProvider == nil {
+			return errors.New("key provider must not be nil")
+		}
+
+		s.receiverOptions = append(s.receiverOptions, ReceiverWithDecryption(keyProvider))
+		s.entity.keyProvider = keyProvider
+		return nil
+	}
+}
+
+// NewAESKeyProvider creates a KeyProvider wrapping data keys with AES-GCM under key, a local key encryption key of 16,
+// 24 or 32 bytes identified by keyID. It is meant for tests and for applications managing their own keys.
+func NewAESKeyProvider(keyID string, key []byte) (KeyProvider, error) {
+	if keyID == "" {
+		return nil, errors.New("key ID must not be empty")
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aesKeyProvider{
+		keyID: keyID,
+		aead:  aead,
+	}, nil
+}
+
+func (p *aesKeyProvider) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := seal(p.aead, dataKey, []byte(p.keyID))
+	if err != nil {
+		return "", nil, err
+	}
+	return p.keyID, wrapped, nil
+}
+
+func (p *aesKeyProvider) UnwrapKey(_ context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	if keyID != p.keyID {
+		return nil, fmt.Errorf("unknown key encryption key %q", keyID)
+	}
+	return open(p.aead, wrappedKey, []byte(keyID))
+}
+
+// encryptPayload encrypts the payload of the AMQP message in place under a new data key wrapped by keyProvider. The
+// payload must be a single data section, so that no part of it is sent in plaintext.
+func encryptPayload(ctx context.Context, keyProvider KeyProvider, msg *amqp.Message) error {
+	if msg.Value != nil || len(msg.Data) != 1 {
+		return errors.New("only payloads of a single data section can be encrypted")
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := seal(aead, msg.Data[0], nil)
+	if err != nil {
+		return err
+	}
+
+	keyID, wrappedKey, err := keyProvider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return err
+	}
+
+	msg.Data = [][]byte{ciphertext}
+	if msg.ApplicationProperties == nil {
+		msg.ApplicationProperties = make(map[string]interface{})
+	}
+	msg.ApplicationProperties[EncryptionAlgorithmProperty] = EncryptionAlgorithmAES256GCM
+	msg.ApplicationProperties[EncryptionKeyIDProperty] = keyID
+	msg.ApplicationProperties[EncryptionWrappedKeyProperty] = wrappedKey
+	return nil
+}
+
+// isEncrypted reports whether the payload of a received message is encrypted.
+func (m *Message) isEncrypted() bool {
+	_, ok := m.UserProperties[EncryptionAlgorithmProperty]
+	return ok
+}
+
+// decryptPayload replaces the Data of an encrypted message by its plaintext, and removes the encryption user
+// properties. Failures are reported as ErrDecryptionFailed.
+func (m *Message) decryptPayload(ctx context.Context, keyProvider KeyProvider) error {
+	if !m.isEncrypted() {
+		return nil
+	}
+
+	keyID, _ := m.UserProperties[EncryptionKeyIDProperty].(string)
+	fail := func(err error) error {
+		return ErrDecryptionFailed{KeyID: keyID, Err: err}
+	}
+
+	if algorithm := m.UserProperties[EncryptionAlgorithmProperty]; algorithm != EncryptionAlgorithmAES256GCM {
+		return fail(fmt.Errorf("unsupported encryption algorithm %v", algorithm))
+	}
+
+	wrappedKey, ok := m.UserProperties[EncryptionWrappedKeyProperty].([]byte)
+	if !ok {
+		return fail(errors.New("message has no wrapped data key"))
+	}
+
+	dataKey, err := keyProvider.UnwrapKey(ctx, keyID, wrappedKey)
+	if err != nil {
+		return fail(err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return fail(err)
+	}
+
+	plaintext, err := open(aead, m.Data, nil)
+	if err != nil {
+		return fail(err)
+	}
+
+	m.Data = plaintext
+	delete(m.UserProperties, EncryptionAlgorithmProperty)
+	delete(m.UserProperties, EncryptionKeyIDProperty)
+	delete(m.UserProperties, EncryptionWrappedKeyProperty)
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext under a random nonce, which prefixes the returned ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a ciphertext produced by seal.
+func open(aead cipher.AEAD, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, additionalData)
+}
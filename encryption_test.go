@@ -0,0 +1,207 @@
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyProvider(t *testing.T, keyID string) KeyProvider {
+	kp, err := NewAESKeyProvider(keyID, bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	return kp
+}
+
+func TestEncryption_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	kp := newTestKeyProvider(t, "kek-1")
+	payload := []byte("sensitive payload")
+
+	s := new(Sender)
+	require.NoError(t, SenderWithEncryption(kp)(s))
+
+	m := NewMessage(payload)
+	amqpMsg, err := s.newOutgoingMessage(ctx, m).toMsg()
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(amqpMsg.Data[0], payload))
+	assert.Equal(t, EncryptionAlgorithmAES256GCM, amqpMsg.ApplicationProperties[EncryptionAlgorithmProperty])
+	assert.Equal(t, "kek-1", amqpMsg.ApplicationProperties[EncryptionKeyIDProperty])
+	assert.Equal(t, payload, m.Data, "the message sent is not modified")
+
+	received, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+
+	r := new(Receiver)
+	require.NoError(t, ReceiverWithDecryption(kp)(r))
+	require.NoError(t, r.restorePayload(ctx, received))
+	assert.Equal(t, payload, received.Data)
+	assert.Empty(t, received.UserProperties)
+}
+
+func TestEncryption_WithCompressionAndClaimCheck(t *testing.T) {
+	ctx := context.Background()
+	kp := newTestKeyProvider(t, "kek-1")
+	store, dir := newTestPayloadStore(t)
+	defer os.RemoveAll(dir)
+	payload := bytes.Repeat([]byte("compressible "), 1000)
+
+	s := new(Sender)
+	require.NoError(t, SenderWithCompression(CompressionZstd, 0)(s))
+	require.NoError(t, SenderWithEncryption(kp)(s))
+	require.NoError(t, SenderWithClaimCheck(store, 0)(s))
+
+	amqpMsg, err := s.newOutgoingMessage(ctx, NewMessage(payload)).toMsg()
+	require.NoError(t, err)
+	assert.Contains(t, amqpMsg.ApplicationProperties, ClaimCheckProperty)
+
+	received, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+
+	r := new(Receiver)
+	require.NoError(t, ReceiverWithClaimCheck(store)(r))
+	require.NoError(t, ReceiverWithDecryption(kp)(r))
+	require.NoError(t, r.restorePayload(ctx, received))
+	assert.Equal(t, payload, received.Data)
+	assert.Empty(t, received.UserProperties)
+}
+
+func TestEncryption_DecryptionFailure(t *testing.T) {
+	ctx := context.Background()
+
+	s := new(Sender)
+	require.NoError(t, SenderWithEncryption(newTestKeyProvider(t, "kek-1"))(s))
+	amqpMsg, err := s.newOutgoingMessage(ctx, NewMessageFromString("payload")).toMsg()
+	require.NoError(t, err)
+
+	r := new(Receiver)
+	require.NoError(t, ReceiverWithDecryption(newTestKeyProvider(t, "kek-2"))(r))
+
+	received, err := messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+	err = r.restorePayload(ctx, received)
+	var decryptionErr ErrDecryptionFailed
+	require.True(t, errors.As(err, &decryptionErr))
+	assert.Equal(t, "kek-1", decryptionErr.KeyID)
+
+	// tampered ciphertext
+	require.NoError(t, ReceiverWithDecryption(newTestKeyProvider(t, "kek-1"))(r))
+	amqpMsg.Data[0][len(amqpMsg.Data[0])-1] ^= 0xff
+	received, err = messageFromAMQPMessage(amqpMsg, nil)
+	require.NoError(t, err)
+	assert.IsType(t, ErrDecryptionFailed{}, r.restorePayload(ctx, received))
+}
+
+func TestEncryption_Batch(t *testing.T) {
+	ctx := context.Background()
+	kp := newTestKeyProvider(t, "kek-1")
+	payload := []byte("sensitive payload")
+
+	s := new(Sender)
+	require.NoError(t, SenderWithEncryption(kp)(s))
+
+	mb, err := s.NewBatch(ctx)
+	require.NoError(t, err)
+	added, err := mb.Add(NewMessage(payload))
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	var amqpMsg amqp.Message
+	require.NoError(t, amqpMsg.UnmarshalBinary(mb.marshaledMessages[0]))
+	assert.False(t, bytes.Contains(amqpMsg.Data[0], payload))
+
+	received, err := messageFromAMQPMessage(&amqpMsg, nil)
+	require.NoError(t, err)
+	require.NoError(t, received.decryptPayload(ctx, kp))
+	assert.Equal(t, payload, received.Data)
+}
+
+func TestEncryption_UnsupportedBody(t *testing.T) {
+	ctx := context.Background()
+
+	s := new(Sender)
+	require.NoError(t, SenderWithEncryption(newTestKeyProvider(t, "kek-1"))(s))
+
+	m := NewMessageFromString("")
+	m.Body = &MessageBody{Type: BodyTypeValue, Value: "plaintext"}
+	_, err := s.newOutgoingMessage(ctx, m).toMsg()
+	assert.Error(t, err)
+
+	mb, err := s.NewBatch(ctx)
+	require.NoError(t, err)
+	_, err = mb.Add(m)
+	assert.Error(t, err)
+}
+
+func TestEncryption_PeekedAndDeferred(t *testing.T) {
+	ctx := context.Background()
+	kp := newTestKeyProvider(t, "kek-1")
+
+	q, err := new(Namespace).NewQueue("queue", QueueWithEncryption(kp))
+	require.NoError(t, err)
+	assert.Len(t, q.senderOptions, 1)
+	assert.Len(t, q.receiverOptions, 1)
+
+	s := new(Sender)
+	require.NoError(t, SenderWithEncryption(kp)(s))
+	encrypted := func(seq int64) *Message {
+		amqpMsg, err := s.newOutgoingMessage(ctx, NewMessageFromString("payload")).toMsg()
+		require.NoError(t, err)
+		m, err := messageFromAMQPMessage(amqpMsg, nil)
+		require.NoError(t, err)
+		m.SystemProperties = &SystemProperties{SequenceNumber: &seq}
+		return m
+	}
+
+	pi, err := newPeekIterator(ctx, nil)
+	require.NoError(t, err)
+	pi.fetchPage = func(context.Context, int64, int32) ([]*Message, error) {
+		return []*Message{encrypted(1)}, nil
+	}
+	pi.restore = q.entity.restorePayload
+	peeked, err := pi.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(peeked.Data))
+
+	var handled []string
+	handler := HandlerFunc(func(_ context.Context, m *Message) error {
+		handled = append(handled, string(m.Data))
+		return nil
+	})
+	require.NoError(t, q.entity.handleDeferred(ctx, handler, ReceiveAndDeleteMode, []*Message{encrypted(2)}))
+	assert.Equal(t, []string{"payload"}, handled)
+
+	// a message which cannot be decrypted is not handed to the handler
+	undecryptable := encrypted(3)
+	undecryptable.UserProperties[EncryptionKeyIDProperty] = "kek-2"
+	assert.IsType(t, ErrDecryptionFailed{}, q.entity.handleDeferred(ctx, handler, ReceiveAndDeleteMode, []*Message{undecryptable}))
+	assert.Len(t, handled, 1)
+}
+
+func TestNewAESKeyProvider(t *testing.T) {
+	_, err := NewAESKeyProvider("", make([]byte, 32))
+	assert.Error(t, err)
+	_, err = NewAESKeyProvider("kek", make([]byte, 7))
+	assert.Error(t, err)
+	assert.Error(t, SenderWithEncryption(nil)(new(Sender)))
+	assert.Error(t, ReceiverWithDecryption(nil)(new(Receiver)))
+
+	ns := new(Namespace)
+	_, err = ns.NewQueue("queue", QueueWithEncryption(nil))
+	assert.Error(t, err)
+
+	kp := newTestKeyProvider(t, "kek-1")
+	topic, err := ns.NewTopic("topic", TopicWithEncryption(kp))
+	require.NoError(t, err)
+	assert.Len(t, topic.senderOptions, 1)
+
+	sub, err := topic.NewSubscription("sub", SubscriptionWithDecryption(kp))
+	require.NoError(t, err)
+	assert.Len(t, sub.receiverOptions, 1)
+	assert.Equal(t, kp, sub.entity.keyProvider)
+}
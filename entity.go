@@ -15,6 +15,9 @@ type (
 		namespace      *Namespace
 		rpcClient      *rpcClient
 		rpcClientMu    sync.RWMutex
+		// payloadStore and keyProvider restore the payload of peeked and deferred messages, as Receivers do
+		payloadStore PayloadStore
+		keyProvider  KeyProvider
 	}
 
 	sendingEntity struct {
@@ -87,15 +90,22 @@ func (e *entity) getEntity() *entity {
 //
 // The MessageIterator that is returned has the following properties:
 // - Messages are fetches from the server in pages. Page size is configurable with PeekOptions.
+// - While a page is being consumed, the next one is fetched in the background, until ctx is cancelled.
 // - The MessageIterator will always return "false" for Done().
 // - When Next() is called, it will return either: a slice of messages and no error, nil with an error related to being
 // unable to complete the operation, or an empty slice of messages and an instance of "ErrNoMessages" signifying that
 // there are currently no messages in the queue with a sequence ID larger than previously viewed ones.
 func (re *receivingEntity) Peek(ctx context.Context, options ...PeekOption) (MessageIterator, error) {
-	_, span := re.entity.startSpanFromContext(ctx, "sb.entity.Peek")
+	ctx, span := re.entity.startSpanFromContext(ctx, "sb.entity.Peek")
 	defer span.End()
 
-	return newPeekIterator(re.entity, options...)
+	it, err := newPeekIterator(ctx, re.entity.GetRPCClient, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	it.restore = re.entity.restorePayload
+	return it, nil
 }
 
 // PeekOne fetches a single Message from the Service Bus broker without acquiring a lock or committing to a disposition.
@@ -109,10 +119,14 @@ func (re *receivingEntity) PeekOne(ctx context.Context, options ...PeekOption) (
 	//   be unread.
 	options = append(options, PeekWithPageSize(1))
 
-	it, err := newPeekIterator(re.entity, options...)
+	it, err := newPeekIterator(ctx, re.entity.GetRPCClient, options...)
 	if err != nil {
 		return nil, err
 	}
+
+	// a single message is wanted, so there is no next page worth fetching in the background.
+	it.readAhead = false
+	it.restore = re.entity.restorePayload
 	return it.Next(ctx)
 }
 
@@ -177,13 +191,7 @@ func (re *receivingEntity) ReceiveDeferredWithMode(ctx context.Context, handler
 		return err
 	}
 
-	for _, msg := range messages {
-		if err := handler.Handle(ctx, msg); err != nil {
-			tab.For(ctx).Error(err)
-			return err
-		}
-	}
-	return nil
+	return re.entity.handleDeferred(ctx, handler, mode, messages)
 }
 
 // RenewLocks renews the locks on messages provided
@@ -236,6 +244,41 @@ func (se *sendingEntity) CancelScheduled(ctx context.Context, seq ...int64) erro
 	return client.CancelScheduled(ctx, seq...)
 }
 
+// restorePayload restores the payload of a message received over the management link, as a Receiver of the entity
+// would restore it.
+func (e *entity) restorePayload(ctx context.Context, m *Message) error {
+	return restorePayload(ctx, m, e.payloadStore, e.keyProvider)
+}
+
+// handleDeferred restores the payload of deferred messages and hands them to handler. A message whose payload cannot
+// be restored is settled as a Receiver would settle it in PeekLockMode; in ReceiveAndDeleteMode, the error is returned.
+func (e *entity) handleDeferred(ctx context.Context, handler Handler, mode ReceiveMode, messages []*Message) error {
+	for _, msg := range messages {
+		if err := e.restorePayload(ctx, msg); err != nil {
+			tab.For(ctx).Error(err)
+			if mode == ReceiveAndDeleteMode {
+				return err
+			}
+
+			if err := settleUnrestorable(ctx, msg, err); err != nil {
+				tab.For(ctx).Error(err)
+				return err
+			}
+			continue
+		}
+
+		if err := handler.Handle(ctx, msg); err != nil {
+			tab.For(ctx).Error(err)
+			return err
+		}
+
+		if mode == ReceiveAndDeleteMode {
+			msg.deletePayload(ctx)
+		}
+	}
+	return nil
+}
+
 func (e *entity) ensureRPCClient(ctx context.Context) error {
 	ctx, span := e.startSpanFromContext(ctx, "sb.entity.ensureRPCClient")
 	defer span.End()
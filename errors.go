@@ -55,6 +55,46 @@ type (
 
 	// ErrConnectionClosed indicates that the connection has been closed.
 	ErrConnectionClosed string
+
+	// ErrUnknownContentType is returned when a message is decoded, but no Codec is registered for its content type.
+	ErrUnknownContentType string
+
+	// ErrDecodeFailure is returned when the Codec registered for the content type of a message fails to decode it.
+	ErrDecodeFailure struct {
+		ContentType string
+		Err         error
+	}
+
+	// ErrUnsupportedPropertyType is returned when a message is sent with a user property or an annotation of a type
+	// Service Bus does not support. See SendWithPropertyConverters to coerce common Go types into supported ones.
+	ErrUnsupportedPropertyType struct {
+		Key  string
+		Type reflect.Type
+		// Annotation is true when the value is an annotation of SystemProperties rather than a user property
+		Annotation bool
+	}
+
+	// ErrDecryptionFailed is returned when the payload of a message encrypted by a Sender configured with
+	// SenderWithEncryption cannot be decrypted.
+	ErrDecryptionFailed struct {
+		KeyID string
+		Err   error
+	}
+
+	// ErrDecompressionFailed is returned when the payload of a message compressed by a Sender configured with
+	// SenderWithCompression cannot be decompressed.
+	ErrDecompressionFailed struct {
+		Algorithm CompressionAlgorithm
+		Err       error
+	}
+
+	// ErrSQLSyntax is returned when a SQL filter or action expression cannot be parsed.
+	ErrSQLSyntax struct {
+		Expression string
+		// Position is the byte offset in Expression the error was found at
+		Position int
+		Reason   string
+	}
 )
 
 func (e ErrMissingField) Error() string {
@@ -104,3 +144,46 @@ func IsErrNotFound(err error) bool {
 func (e ErrConnectionClosed) Error() string {
 	return fmt.Sprintf("the connection has been closed: %s", string(e))
 }
+
+func (e ErrUnknownContentType) Error() string {
+	return fmt.Sprintf("no codec is registered for content type %q", string(e))
+}
+
+func (e ErrDecodeFailure) Error() string {
+	return fmt.Sprintf("failed to decode message with content type %q: %v", e.ContentType, e.Err)
+}
+
+// Unwrap returns the error of the Codec.
+func (e ErrDecodeFailure) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrUnsupportedPropertyType) Error() string {
+	kind := "user property"
+	if e.Annotation {
+		kind = "annotation"
+	}
+	return fmt.Sprintf("%s %q has type %v, which is not supported by Service Bus", kind, e.Key, e.Type)
+}
+
+func (e ErrDecryptionFailed) Error() string {
+	return fmt.Sprintf("failed to decrypt message with key %q: %v", e.KeyID, e.Err)
+}
+
+// Unwrap returns the error which caused the decryption to fail.
+func (e ErrDecryptionFailed) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrDecompressionFailed) Error() string {
+	return fmt.Sprintf("failed to decompress message with %s: %v", e.Algorithm, e.Err)
+}
+
+// Unwrap returns the error which caused the decompression to fail.
+func (e ErrDecompressionFailed) Unwrap() error {
+	return e.Err
+}
+
+func (e ErrSQLSyntax) Error() string {
+	return fmt.Sprintf("syntax error at position %d of SQL expression %q: %s", e.Position, e.Expression, e.Reason)
+}
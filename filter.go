@@ -1,5 +1,10 @@
 package servicebus
 
+import (
+	"reflect"
+	"time"
+)
+
 type (
 	// TrueFilter represents a always true sql expression which will accept all messages
 	TrueFilter struct{}
@@ -23,15 +28,15 @@ type (
 	// multiple match properties, the filter combines them as a logical AND condition, meaning for the filter to match,
 	// all conditions must match.
 	CorrelationFilter struct {
-		CorrelationID    *string                `xml:"CorrelationId,omitempty"`
-		MessageID        *string                `xml:"MessageId,omitempty"`
-		To               *string                `xml:"To,omitempty"`
-		ReplyTo          *string                `xml:"ReplyTo,omitempty"`
-		Label            *string                `xml:"Label,omitempty"`
-		SessionID        *string                `xml:"SessionId,omitempty"`
-		ReplyToSessionID *string                `xml:"ReplyToSessionId,omitempty"`
-		ContentType      *string                `xml:"ContentType,omitempty"`
-		Properties       map[string]interface{} `xml:"Properties,omitempty"`
+		CorrelationID    *string               `xml:"CorrelationId,omitempty"`
+		MessageID        *string               `xml:"MessageId,omitempty"`
+		To               *string               `xml:"To,omitempty"`
+		ReplyTo          *string               `xml:"ReplyTo,omitempty"`
+		Label            *string               `xml:"Label,omitempty"`
+		SessionID        *string               `xml:"SessionId,omitempty"`
+		ReplyToSessionID *string               `xml:"ReplyToSessionId,omitempty"`
+		ContentType      *string               `xml:"ContentType,omitempty"`
+		Properties       CorrelationProperties `xml:"Properties,omitempty"`
 	}
 )
 
@@ -66,3 +71,67 @@ func (cf CorrelationFilter) ToFilterDescription() FilterDescription {
 		CorrelationFilter: cf,
 	}
 }
+
+// Match reports whether msg matches every condition of the filter, as Service Bus evaluates it: strings are compared
+// case-sensitively, and numeric user properties are compared by value, whatever their type. A ReplyToSessionID is
+// matched against the ReplyToGroupID of the message.
+func (cf CorrelationFilter) Match(msg *Message) bool {
+	conditions := []struct {
+		expected *string
+		actual   string
+	}{
+		{cf.CorrelationID, msg.CorrelationID},
+		{cf.MessageID, msg.ID},
+		{cf.To, msg.To},
+		{cf.ReplyTo, msg.ReplyTo},
+		{cf.Label, msg.Label},
+		{cf.ReplyToSessionID, msg.ReplyToGroupID},
+		{cf.ContentType, msg.ContentType},
+	}
+
+	for _, condition := range conditions {
+		if condition.expected != nil && *condition.expected != condition.actual {
+			return false
+		}
+	}
+
+	if cf.SessionID != nil && (msg.SessionID == nil || *cf.SessionID != *msg.SessionID) {
+		return false
+	}
+
+	for key, expected := range cf.Properties {
+		actual, ok := msg.UserProperties[key]
+		if !ok || !propertyValuesEqual(expected, actual) {
+			return false
+		}
+	}
+	return true
+}
+
+// propertyValuesEqual reports whether two property values are equal, comparing numbers by value whatever their type.
+func propertyValuesEqual(a, b interface{}) bool {
+	if fa, ok := propertyNumber(a); ok {
+		fb, ok := propertyNumber(b)
+		return ok && fa == fb
+	}
+
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// propertyNumber returns the value of a numeric property as a float64.
+func propertyNumber(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
@@ -0,0 +1,173 @@
+package servicebus
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// CorrelationProperties are the user properties a CorrelationFilter matches, by name. Values may be strings,
+	// integers, floats, booleans or times, which are sent to Service Bus as the XML Schema types string, int, long,
+	// double, boolean and dateTime. Properties read back from Service Bus hold int32 for int, int64 for long, float64
+	// for double, and time.Time for dateTime.
+	CorrelationProperties map[string]interface{}
+
+	// correlationProperty is the XML form of a CorrelationProperties entry, a KeyValueOfstringanyType
+	correlationProperty struct {
+		Key   string                   `xml:"Key"`
+		Value correlationPropertyValue `xml:"Value"`
+	}
+
+	correlationPropertyValue struct {
+		Type string `xml:"http://www.w3.org/2001/XMLSchema-instance type,attr"`
+		Text string `xml:",chardata"`
+	}
+)
+
+const (
+	serializationArraysSchema = "http://schemas.microsoft.com/2003/10/Serialization/Arrays"
+	xmlSchema                 = "http://www.w3.org/2001/XMLSchema"
+	// prefix declared for xmlSchema on each property value
+	xmlSchemaPrefix = "d3p1"
+	// layout of XML Schema dateTime values without an offset
+	xmlSchemaLocalDateTime = "2006-01-02T15:04:05.999999999"
+)
+
+// MarshalXML writes the properties as KeyValueOfstringanyType elements, sorted by key, each value typed with an
+// xsi:type attribute.
+func (cp CorrelationProperties) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(cp))
+	for key := range cp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		xsdType, text, err := formatCorrelationProperty(key, cp[key])
+		if err != nil {
+			return err
+		}
+
+		kv := xml.StartElement{Name: xml.Name{Space: serializationArraysSchema, Local: "KeyValueOfstringanyType"}}
+		value := xml.StartElement{
+			Name: xml.Name{Local: "Value"},
+			Attr: []xml.Attr{
+				// the i prefix is declared on the RuleDescription, as the type of the filter is written with it
+				{Name: xml.Name{Local: "i:type"}, Value: xmlSchemaPrefix + ":" + xsdType},
+				{Name: xml.Name{Local: "xmlns:" + xmlSchemaPrefix}, Value: xmlSchema},
+			},
+		}
+
+		tokens := []xml.Token{
+			kv,
+			xml.StartElement{Name: xml.Name{Local: "Key"}}, xml.CharData(key), xml.EndElement{Name: xml.Name{Local: "Key"}},
+			value, xml.CharData(text), value.End(),
+			kv.End(),
+		}
+		for _, token := range tokens {
+			if err := e.EncodeToken(token); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML reads properties written as KeyValueOfstringanyType elements, converting each value according to its
+// xsi:type. Values of other types are kept as strings.
+func (cp *CorrelationProperties) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	props := make(CorrelationProperties)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "KeyValueOfstringanyType" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			var kv correlationProperty
+			if err := d.DecodeElement(&kv, &t); err != nil {
+				return err
+			}
+
+			value, err := parseCorrelationProperty(kv.Value)
+			if err != nil {
+				return fmt.Errorf("correlation filter property %q: %v", kv.Key, err)
+			}
+			props[kv.Key] = value
+		case xml.EndElement:
+			*cp = props
+			return nil
+		}
+	}
+}
+
+// formatCorrelationProperty returns the XML Schema type and the text of a property value.
+func formatCorrelationProperty(key string, value interface{}) (string, string, error) {
+	switch v := value.(type) {
+	case string:
+		return "string", v, nil
+	case bool:
+		return "boolean", strconv.FormatBool(v), nil
+	case int8, int16, int32, uint8, uint16:
+		return "int", strconv.FormatInt(sqlValue(v).(int64), 10), nil
+	case int, int64, uint32:
+		return "long", strconv.FormatInt(sqlValue(v).(int64), 10), nil
+	case uint, uint64:
+		if u := reflect.ValueOf(v).Uint(); u <= math.MaxInt64 {
+			return "long", strconv.FormatUint(u, 10), nil
+		}
+	case float32, float64:
+		return "double", strconv.FormatFloat(reflect.ValueOf(v).Float(), 'G', -1, 64), nil
+	case time.Time:
+		return "dateTime", v.UTC().Format(time.RFC3339Nano), nil
+	}
+	return "", "", ErrUnsupportedPropertyType{Key: key, Type: reflect.TypeOf(value)}
+}
+
+// parseCorrelationProperty converts the text of a property value according to its XML Schema type.
+func parseCorrelationProperty(value correlationPropertyValue) (interface{}, error) {
+	xsdType := value.Type
+	if i := strings.LastIndexByte(xsdType, ':'); i >= 0 {
+		xsdType = xsdType[i+1:]
+	}
+
+	text := strings.TrimSpace(value.Text)
+	switch xsdType {
+	case "int":
+		i, err := strconv.ParseInt(text, 10, 32)
+		return int32(i), err
+	case "long":
+		return strconv.ParseInt(text, 10, 64)
+	case "double", "float":
+		return strconv.ParseFloat(text, 64)
+	case "boolean":
+		return strconv.ParseBool(text)
+	case "dateTime":
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			// times without an offset are in UTC
+			t, err = time.Parse(xmlSchemaLocalDateTime, text)
+		}
+		return t, err
+	default:
+		return value.Text, nil
+	}
+}
@@ -0,0 +1,125 @@
+package servicebus
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func goldenCorrelationFilter() CorrelationFilter {
+	return CorrelationFilter{
+		CorrelationID: to.StringPtr("order-1"),
+		Label:         to.StringPtr("order.created"),
+		Properties: CorrelationProperties{
+			"region":   "eu & uk",
+			"count":    int32(3),
+			"total":    int64(9000000000),
+			"price":    2.5,
+			"urgent":   true,
+			"deadline": time.Date(2020, 1, 2, 3, 4, 5, 500000000, time.UTC),
+		},
+	}
+}
+
+func readGolden(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return b
+}
+
+func TestCorrelationProperties_MarshalGolden(t *testing.T) {
+	b, err := marshalRuleEntry(&RuleDescription{
+		BaseEntityDescription: BaseEntityDescription{
+			ServiceBusSchema:       to.StringPtr(serviceBusSchema),
+			InstanceMetadataSchema: to.StringPtr(schemaInstance),
+		},
+		Filter: goldenCorrelationFilter().ToFilterDescription(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, strings.TrimSpace(string(readGolden(t, "correlation_rule_put.xml"))), string(b))
+}
+
+func TestCorrelationProperties_UnmarshalGolden(t *testing.T) {
+	var feed ruleFeed
+	require.NoError(t, xml.Unmarshal(readGolden(t, "correlation_rule_feed.xml"), &feed))
+	require.Len(t, feed.Entries, 1)
+
+	rule := ruleEntryToEntity(&feed.Entries[0])
+	assert.Equal(t, "eu-orders", rule.Name)
+	assert.Equal(t, "CorrelationFilter", rule.Filter.Type)
+	assert.Equal(t, goldenCorrelationFilter(), rule.Filter.CorrelationFilter)
+}
+
+func TestCorrelationProperties_RoundTrip(t *testing.T) {
+	b, err := marshalRuleEntry(&RuleDescription{
+		BaseEntityDescription: BaseEntityDescription{
+			ServiceBusSchema:       to.StringPtr(serviceBusSchema),
+			InstanceMetadataSchema: to.StringPtr(schemaInstance),
+		},
+		Filter: goldenCorrelationFilter().ToFilterDescription(),
+	})
+	require.NoError(t, err)
+
+	var entry ruleEntry
+	require.NoError(t, xml.Unmarshal(b, &entry))
+	assert.Equal(t, "CorrelationFilter", entry.Content.RuleDescription.Filter.Type)
+	assert.Equal(t, goldenCorrelationFilter(), entry.Content.RuleDescription.Filter.CorrelationFilter)
+}
+
+func TestCorrelationProperties_MarshalTypes(t *testing.T) {
+	cases := []struct {
+		value   interface{}
+		xsdType string
+		text    string
+	}{
+		{value: int8(-1), xsdType: "int", text: "-1"},
+		{value: uint16(7), xsdType: "int", text: "7"},
+		{value: 42, xsdType: "long", text: "42"},
+		{value: uint32(7), xsdType: "long", text: "7"},
+		{value: uint64(7), xsdType: "long", text: "7"},
+		{value: float32(0.5), xsdType: "double", text: "0.5"},
+		{value: false, xsdType: "boolean", text: "false"},
+		{value: time.Date(2020, 1, 2, 4, 4, 5, 0, time.FixedZone("CET", 3600)), xsdType: "dateTime", text: "2020-01-02T03:04:05Z"},
+	}
+
+	for _, c := range cases {
+		xsdType, text, err := formatCorrelationProperty("key", c.value)
+		require.NoError(t, err)
+		assert.Equal(t, c.xsdType, xsdType, "%#v", c.value)
+		assert.Equal(t, c.text, text, "%#v", c.value)
+	}
+
+	for _, value := range []interface{}{uint64(1) << 63, []byte("a"), nil} {
+		_, _, err := formatCorrelationProperty("key", value)
+		assert.IsType(t, ErrUnsupportedPropertyType{}, err, "%#v", value)
+	}
+
+	_, err := xml.Marshal(CorrelationFilter{Properties: CorrelationProperties{"key": struct{}{}}})
+	assert.Error(t, err)
+}
+
+func TestCorrelationProperties_UnmarshalLenient(t *testing.T) {
+	var props CorrelationProperties
+	err := xml.Unmarshal([]byte(`<Properties xmlns:i="http://www.w3.org/2001/XMLSchema-instance">
+		<KeyValueOfstringanyType><Key>local</Key><Value i:type="x:dateTime">2020-01-02T03:04:05</Value></KeyValueOfstringanyType>
+		<KeyValueOfstringanyType><Key>id</Key><Value i:type="x:guid">a-b</Value></KeyValueOfstringanyType>
+		<Unknown/>
+	</Properties>`), &props)
+	require.NoError(t, err)
+	assert.Equal(t, CorrelationProperties{
+		"local": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"id":    "a-b",
+	}, props)
+
+	err = xml.Unmarshal([]byte(`<Properties xmlns:i="http://www.w3.org/2001/XMLSchema-instance">
+		<KeyValueOfstringanyType><Key>n</Key><Value i:type="x:int">many</Value></KeyValueOfstringanyType>
+	</Properties>`), &props)
+	assert.Error(t, err)
+}
@@ -14,7 +14,10 @@ require (
 	github.com/Azure/go-autorest/autorest/validation v0.3.1 // indirect
 	github.com/devigned/tab v0.1.1
 	github.com/gin-gonic/gin v1.7.3 // indirect
+	github.com/golang/protobuf v1.3.5
 	github.com/joho/godotenv v1.3.0
+	github.com/klauspost/compress v1.10.3
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/mitchellh/mapstructure v1.3.3
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/crypto v0.0.0-20211115234514-b4de73f9ece8 // indirect
@@ -0,0 +1,340 @@
+package servicebus
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devigned/tab"
+)
+
+type (
+	// ProcessedStore records the keys of the messages which were processed, so that a Receiver configured with
+	// ReceiverWithIdempotency skips the messages delivered again. Implementations must be safe for concurrent use.
+	ProcessedStore interface {
+		// IsProcessed reports whether the message with key was processed.
+		IsProcessed(ctx context.Context, key string) (bool, error)
+		// MarkProcessed records that the message with key was processed.
+		MarkProcessed(ctx context.Context, key string) error
+	}
+
+	// MemoryProcessedStore is a ProcessedStore keeping the most recently processed keys in memory, up to a capacity,
+	// for a time to live. It only detects the duplicates delivered to the same process.
+	MemoryProcessedStore struct {
+		capacity int
+		ttl      time.Duration
+		entries  map[string]*list.Element
+		// order holds the entries from the most to the least recently used
+		order *list.List
+		mu    sync.Mutex
+
+		// replaceable for testing
+		now func() time.Time
+	}
+
+	processedEntry struct {
+		key         string
+		processedAt time.Time
+	}
+
+	// SQLProcessedStore is a ProcessedStore keeping the processed keys in a database/sql table, which detects the
+	// duplicates delivered to any process sharing the database. The table has the following columns, and can be
+	// created with CreateTable:
+	//
+	//	message_key  VARCHAR(256) PRIMARY KEY
+	//	processed_at BIGINT, in Unix milliseconds
+	SQLProcessedStore struct {
+		db          *sql.DB
+		table       string
+		ttl         time.Duration
+		placeholder func(n int) string
+
+		// replaceable for testing
+		now func() time.Time
+	}
+
+	// SQLProcessedStoreOption provides a way to customize a SQLProcessedStore
+	SQLProcessedStoreOption func(*SQLProcessedStore) error
+)
+
+const (
+	// DefaultProcessedTable is the name of the table of a SQLProcessedStore by default
+	DefaultProcessedTable = "servicebus_processed"
+)
+
+// ReceiverWithIdempotency configures the Receiver to skip the messages store reports as processed. Messages are
+// identified by their ID, unless ReceiverWithIdempotencyKey is used. A message is recorded as processed once it is
+// completed, so that messages which are abandoned, deferred or dead-lettered reach the Handler again when they are
+// redelivered. In ReceiveAndDeleteMode, it is recorded once the Handler returns it without an error.
+//
+// Duplicates are completed without being handed to the Handler. A message which cannot be checked against store is
+// abandoned, so that it is redelivered; in ReceiveAndDeleteMode, the receiver stops as it would on an error from the
+// Handler.
+func ReceiverWithIdempotency(store ProcessedStore) ReceiverOption {
+	return func(r *Receiver) error {
+		if store == nil {
+			return errors.New("processed store must not be nil")
+		}
+
+		r.processedStore = store
+		return nil
+	}
+}
+
+// ReceiverWithIdempotencyKey configures the key messages are identified by with ReceiverWithIdempotency, such as a
+// business identifier held in a user property. Messages whose key is empty are always handed to the Handler.
+func ReceiverWithIdempotencyKey(key func(*Message) string) ReceiverOption {
+	return func(r *Receiver) error {
+		if key == nil {
+			return errors.New("idempotency key must not be nil")
+		}
+
+		r.idempotencyKey = key
+		return nil
+	}
+}
+
+// idempotencyKeyOf returns the key the message is identified by with ReceiverWithIdempotency.
+func (r *Receiver) idempotencyKeyOf(m *Message) string {
+	if r.idempotencyKey != nil {
+		return r.idempotencyKey(m)
+	}
+	return m.ID
+}
+
+// isDuplicate reports whether the message was already processed, according to the ProcessedStore of the Receiver.
+func (r *Receiver) isDuplicate(ctx context.Context, m *Message) (bool, error) {
+	if r.processedStore == nil {
+		return false, nil
+	}
+
+	key := r.idempotencyKeyOf(m)
+	if key == "" {
+		return false, nil
+	}
+	return r.processedStore.IsProcessed(ctx, key)
+}
+
+// trackProcessed arranges for the message to be recorded as processed in the ProcessedStore of the Receiver by
+// markProcessed.
+func (r *Receiver) trackProcessed(m *Message) {
+	if r.processedStore == nil {
+		return
+	}
+
+	key := r.idempotencyKeyOf(m)
+	if key == "" {
+		return
+	}
+
+	m.processedStore = r.processedStore
+	m.processedKey = key
+}
+
+// markProcessed records the message as processed in the ProcessedStore of the Receiver it was received by, if any.
+// Failures are only traced, as the message is already settled; it is handled again if it is redelivered.
+func (m *Message) markProcessed(ctx context.Context) {
+	if m.processedStore == nil {
+		return
+	}
+
+	if err := m.processedStore.MarkProcessed(ctx, m.processedKey); err != nil {
+		tab.For(ctx).Error(err)
+		return
+	}
+	m.processedStore = nil
+}
+
+// NewMemoryProcessedStore creates a MemoryProcessedStore keeping up to capacity keys, each for ttl, or until it is
+// the least recently used key once the store is full. A ttl of zero keeps keys until they are evicted.
+func NewMemoryProcessedStore(capacity int, ttl time.Duration) (*MemoryProcessedStore, error) {
+	if capacity < 1 {
+		return nil, fmt.Errorf("capacity must be at least 1, but was %d", capacity)
+	}
+
+	if ttl < 0 {
+		return nil, fmt.Errorf("ttl must not be negative, but was %v", ttl)
+	}
+
+	return &MemoryProcessedStore{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}, nil
+}
+
+// IsProcessed reports whether key was marked as processed within the time to live and was not evicted since.
+func (s *MemoryProcessedStore) IsProcessed(_ context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+
+	if s.expired(el.Value.(*processedEntry)) {
+		s.remove(el)
+		return false, nil
+	}
+
+	s.order.MoveToFront(el)
+	return true, nil
+}
+
+// MarkProcessed records key as processed, evicting the least recently used key if the store is full.
+func (s *MemoryProcessedStore) MarkProcessed(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*processedEntry).processedAt = now
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&processedEntry{key: key, processedAt: now})
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of keys in the store, including those which expired but were not removed yet.
+func (s *MemoryProcessedStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
+
+func (s *MemoryProcessedStore) expired(entry *processedEntry) bool {
+	return s.ttl > 0 && s.now().Sub(entry.processedAt) >= s.ttl
+}
+
+func (s *MemoryProcessedStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*processedEntry).key)
+}
+
+// SQLProcessedStoreWithTable configures the table the store keeps processed keys in. The default is
+// DefaultProcessedTable. The name may be qualified by a schema, as in "schema.table".
+func SQLProcessedStoreWithTable(table string) SQLProcessedStoreOption {
+	return func(s *SQLProcessedStore) error {
+		if err := validateSQLTableName(table); err != nil {
+			return err
+		}
+		s.table = table
+		return nil
+	}
+}
+
+// SQLProcessedStoreWithTTL configures how long a key is considered processed. By default, keys are considered
+// processed until they are deleted with DeleteOlderThan.
+func SQLProcessedStoreWithTTL(ttl time.Duration) SQLProcessedStoreOption {
+	return func(s *SQLProcessedStore) error {
+		if ttl <= 0 {
+			return fmt.Errorf("ttl must be positive, but was %v", ttl)
+		}
+		s.ttl = ttl
+		return nil
+	}
+}
+
+// SQLProcessedStoreWithNumberedPlaceholders configures the store to write query parameters as $1, $2 and so on, as
+// PostgreSQL expects, rather than as ?.
+func SQLProcessedStoreWithNumberedPlaceholders() SQLProcessedStoreOption {
+	return func(s *SQLProcessedStore) error {
+		s.placeholder = numberedSQLPlaceholder
+		return nil
+	}
+}
+
+// NewSQLProcessedStore creates a SQLProcessedStore keeping processed keys in db.
+func NewSQLProcessedStore(db *sql.DB, opts ...SQLProcessedStoreOption) (*SQLProcessedStore, error) {
+	if db == nil {
+		return nil, errors.New("db must not be nil")
+	}
+
+	s := &SQLProcessedStore{
+		db:          db,
+		table:       DefaultProcessedTable,
+		placeholder: questionMarkSQLPlaceholder,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// CreateTable creates the table of the store if it does not exist.
+func (s *SQLProcessedStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table+` (
+		message_key VARCHAR(256) NOT NULL PRIMARY KEY,
+		processed_at BIGINT NOT NULL
+	)`)
+	return err
+}
+
+// IsProcessed reports whether key was marked as processed, within the time to live if one is configured.
+func (s *SQLProcessedStore) IsProcessed(ctx context.Context, key string) (bool, error) {
+	var since int64
+	if s.ttl > 0 {
+		since = unixMillis(s.now().Add(-s.ttl))
+	}
+
+	var count int
+	err := s.db.QueryRowContext(ctx, s.query(`SELECT COUNT(*) FROM `+s.table+` WHERE message_key = %s AND processed_at >= %s`, 2),
+		key, since).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// MarkProcessed records key as processed.
+func (s *SQLProcessedStore) MarkProcessed(ctx context.Context, key string) error {
+	now := unixMillis(s.now())
+	res, err := s.db.ExecContext(ctx, s.query(`UPDATE `+s.table+` SET processed_at = %s WHERE message_key = %s`, 2), now, key)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, s.query(`INSERT INTO `+s.table+` (message_key, processed_at) VALUES (%s, %s)`, 2), key, now)
+	if err != nil {
+		// another receiver may have inserted the key since it was updated
+		if processed, checkErr := s.IsProcessed(ctx, key); checkErr == nil && processed {
+			return nil
+		}
+	}
+	return err
+}
+
+// DeleteOlderThan deletes the keys processed before t, returning how many were deleted.
+func (s *SQLProcessedStore) DeleteOlderThan(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.query(`DELETE FROM `+s.table+` WHERE processed_at < %s`, 1), unixMillis(t))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// query formats a query with n placeholders.
+func (s *SQLProcessedStore) query(format string, n int) string {
+	return formatSQLQuery(s.placeholder, format, n)
+}
@@ -0,0 +1,52 @@
+//go:build cgo
+// +build cgo
+
+package servicebus
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLProcessedStore(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	store, err := NewSQLProcessedStore(db, SQLProcessedStoreWithTable("processed"), SQLProcessedStoreWithTTL(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, store.CreateTable(ctx))
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	processed, err := store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, processed)
+
+	require.NoError(t, store.MarkProcessed(ctx, "a"))
+	// marking a key again refreshes it
+	require.NoError(t, store.MarkProcessed(ctx, "a"))
+	processed, err = store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, processed)
+
+	now = now.Add(time.Hour + time.Millisecond)
+	processed, err = store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, processed)
+
+	deleted, err := store.DeleteOlderThan(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = NewSQLProcessedStore(db, SQLProcessedStoreWithTable("processed;"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package servicebus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryProcessedStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMemoryProcessedStore(2, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, store.MarkProcessed(ctx, "a"))
+	require.NoError(t, store.MarkProcessed(ctx, "b"))
+
+	// using a makes b the least recently used
+	processed, err := store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, processed)
+
+	require.NoError(t, store.MarkProcessed(ctx, "c"))
+	assert.Equal(t, 2, store.Len())
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+		processed, err := store.IsProcessed(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, processed, key)
+	}
+}
+
+func TestMemoryProcessedStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMemoryProcessedStore(10, time.Minute)
+	require.NoError(t, err)
+
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	require.NoError(t, store.MarkProcessed(ctx, "a"))
+
+	now = now.Add(59 * time.Second)
+	processed, err := store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, processed)
+
+	now = now.Add(time.Second)
+	processed, err = store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, processed)
+	assert.Equal(t, 0, store.Len())
+
+	_, err = NewMemoryProcessedStore(0, 0)
+	assert.Error(t, err)
+	_, err = NewMemoryProcessedStore(1, -time.Second)
+	assert.Error(t, err)
+}
+
+func TestReceiver_Idempotency(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMemoryProcessedStore(10, 0)
+	require.NoError(t, err)
+
+	r := new(Receiver)
+	duplicate, err := r.isDuplicate(ctx, &Message{ID: "a"})
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	require.NoError(t, ReceiverWithIdempotency(store)(r))
+	msg := &Message{ID: "a"}
+	duplicate, err = r.isDuplicate(ctx, msg)
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	markProcessed := func(m *Message) {
+		r.trackProcessed(m)
+		m.markProcessed(ctx)
+	}
+
+	markProcessed(msg)
+	duplicate, err = r.isDuplicate(ctx, &Message{ID: "a"})
+	require.NoError(t, err)
+	assert.True(t, duplicate)
+
+	// with a key function, messages are identified by their key, and those without a key are never duplicates
+	require.NoError(t, ReceiverWithIdempotencyKey(func(m *Message) string {
+		key, _ := m.UserProperties["orderID"].(string)
+		return key
+	})(r))
+
+	order := &Message{ID: "b", UserProperties: map[string]interface{}{"orderID": "42"}}
+	markProcessed(order)
+	markProcessed(&Message{ID: "c"})
+
+	duplicate, err = r.isDuplicate(ctx, &Message{ID: "d", UserProperties: map[string]interface{}{"orderID": "42"}})
+	require.NoError(t, err)
+	assert.True(t, duplicate)
+	duplicate, err = r.isDuplicate(ctx, &Message{ID: "c"})
+	require.NoError(t, err)
+	assert.False(t, duplicate)
+
+	assert.Error(t, ReceiverWithIdempotency(nil)(r))
+	assert.Error(t, ReceiverWithIdempotencyKey(nil)(r))
+}
+
+func TestReceiver_IdempotencyRecordsCompletedMessages(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMemoryProcessedStore(10, 0)
+	require.NoError(t, err)
+
+	r := &Receiver{mode: PeekLockMode}
+	require.NoError(t, ReceiverWithIdempotency(store)(r))
+
+	handled := 0
+	h := newAmqpAdapterHandler(r, HandlerFunc(func(ctx context.Context, m *Message) error {
+		handled++
+		if r.mode == PeekLockMode {
+			// the message has no lock token, so abandoning it fails before reaching the broker, which makes no
+			// difference to the receiver
+			m.ec = &entity{rpcClient: new(rpcClient)}
+			assert.Error(t, m.Abandon(ctx))
+		}
+		return nil
+	}))
+	delivery := func() *amqp.Message {
+		return &amqp.Message{
+			Properties: &amqp.MessageProperties{MessageID: "a"},
+			Data:       [][]byte{[]byte("foo")},
+		}
+	}
+
+	// an abandoned message is not processed, so its redelivery reaches the handler
+	require.NoError(t, h.Handle(ctx, delivery(), nil))
+	require.NoError(t, h.Handle(ctx, delivery(), nil))
+	assert.Equal(t, 2, handled)
+	processed, err := store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, processed)
+
+	// in ReceiveAndDeleteMode, the message is processed once the handler returns
+	r.mode = ReceiveAndDeleteMode
+	require.NoError(t, h.Handle(ctx, delivery(), nil))
+	require.NoError(t, h.Handle(ctx, delivery(), nil))
+	assert.Equal(t, 3, handled)
+	processed, err = store.IsProcessed(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, processed)
+}
@@ -3,6 +3,7 @@ package servicebus
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/devigned/tab"
 )
@@ -21,9 +22,27 @@ type (
 	}
 
 	peekIterator struct {
-		entity             *entity
+		ctx                context.Context
+		getRPCClient       func(context.Context) (*rpcClient, error)
 		buffer             chan *Message
 		lastSequenceNumber int64
+		sessionID          *string
+		fromEnqueuedTime   *time.Time
+		readAhead          bool
+		pending            chan peekPage
+		// restore restores the payload of the messages returned by Next, if set
+		restore func(context.Context, *Message) error
+
+		// replaceable for testing
+
+		// fetches a page of messages starting at 'fromSequenceNumber'
+		fetchPage func(ctx context.Context, fromSequenceNumber int64, messageCount int32) ([]*Message, error)
+	}
+
+	// peekPage is the result of a page fetched in the background by a peekIterator.
+	peekPage struct {
+		msgs []*Message
+		err  error
 	}
 
 	// PeekOption allows customization of parameters when querying a Service Bus entity for messages without committing
@@ -33,6 +52,10 @@ type (
 
 const (
 	defaultPeekPageSize = 10
+
+	// peekReadAheadTimeout bounds how long a background page fetch may take, since it is not tied to the context of
+	// any call to Next, but to the one the iterator was created with.
+	peekReadAheadTimeout = 1 * time.Minute
 )
 
 // AsMessageSliceIterator wraps a slice of Message pointers to allow it to be made into a MessageIterator.
@@ -58,10 +81,14 @@ func (ms *MessageSliceIterator) Next(_ context.Context) (*Message, error) {
 	return retval, nil
 }
 
-func newPeekIterator(entity *entity, options ...PeekOption) (*peekIterator, error) {
+// newPeekIterator builds a peekIterator. Pages fetched in the background are tied to ctx, so cancelling it stops them.
+func newPeekIterator(ctx context.Context, getRPCClient func(context.Context) (*rpcClient, error), options ...PeekOption) (*peekIterator, error) {
 	retval := &peekIterator{
-		entity: entity,
+		ctx:          ctx,
+		getRPCClient: getRPCClient,
+		readAhead:    true,
 	}
+	retval.fetchPage = retval.fetchPageFromRPCClient
 
 	foundPageSize := false
 	for i := range options {
@@ -109,14 +136,51 @@ func PeekFromSequenceNumber(seq int64) PeekOption {
 	}
 }
 
+// PeekWithSession adds a filter to the Peek operation, so that only messages belonging to the session identified by
+// 'sessionID' are returned. Peeking does not acquire the session lock, so the messages of a session held by another
+// receiver can be inspected.
+func PeekWithSession(sessionID string) PeekOption {
+	return func(pi *peekIterator) error {
+		if sessionID == "" {
+			return errors.New("session ID must not be empty")
+		}
+
+		pi.sessionID = &sessionID
+		return nil
+	}
+}
+
+// PeekFromEnqueuedTime adds a filter to the Peek operation, so that browsing starts at the first message enqueued at
+// or after 't'. The starting sequence number is found by a binary search over the sequence numbers of the entity,
+// peeking a single message per step and comparing its EnqueuedTime. The search assumes that EnqueuedTime increases
+// with the sequence number, so for partitioned entities the starting point is approximate.
+func PeekFromEnqueuedTime(t time.Time) PeekOption {
+	return func(pi *peekIterator) error {
+		if t.IsZero() {
+			return errors.New("enqueued time must not be the zero time")
+		}
+
+		pi.fromEnqueuedTime = &t
+		return nil
+	}
+}
+
 func (pi peekIterator) Done() bool {
 	return false
 }
 
+// Next returns the next message of the current page. The following page is fetched in the background while the
+// current one is consumed, so that iterating over a large entity does not stall at every page boundary.
 func (pi *peekIterator) Next(ctx context.Context) (*Message, error) {
 	ctx, span := startConsumerSpanFromContext(ctx, "sb.peekIterator.Next")
 	defer span.End()
 
+	if pi.fromEnqueuedTime != nil {
+		if err := pi.seekEnqueuedTime(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	if len(pi.buffer) == 0 {
 		if err := pi.getNextPage(ctx); err != nil {
 			return nil, err
@@ -125,26 +189,46 @@ func (pi *peekIterator) Next(ctx context.Context) (*Message, error) {
 
 	select {
 	case next := <-pi.buffer:
+		pi.restorePayload(ctx, next)
 		return next, nil
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
 }
 
+// restorePayload restores the payload of a peeked message. A message whose payload cannot be restored is returned as it
+// is, so that it does not stop the browsing of the entity.
+func (pi *peekIterator) restorePayload(ctx context.Context, m *Message) {
+	if pi.restore == nil {
+		return
+	}
+
+	if err := pi.restore(ctx, m); err != nil {
+		tab.For(ctx).Error(err)
+	}
+	// peeked messages cannot be completed, so the payload is left in the store for the receiver of the message
+	m.payloadStore = nil
+}
+
 func (pi *peekIterator) getNextPage(ctx context.Context) error {
 	ctx, span := startConsumerSpanFromContext(ctx, "sb.peekIterator.getNextPage")
 	defer span.End()
 
-	client, err := pi.entity.GetRPCClient(ctx)
+	msgs, fetched, err := pi.takePending(ctx)
 	if err != nil {
-		tab.For(ctx).Error(err)
 		return err
 	}
 
-	msgs, err := client.GetNextPage(ctx, pi.lastSequenceNumber, int32(cap(pi.buffer)))
-	if err != nil {
-		tab.For(ctx).Error(err)
-		return err
+	if !fetched {
+		msgs, err = pi.fetchPage(ctx, pi.lastSequenceNumber, int32(cap(pi.buffer)))
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return err
+		}
+	}
+
+	if len(msgs) == 0 {
+		return ErrNoMessages{}
 	}
 
 	for i := range msgs {
@@ -159,5 +243,150 @@ func (pi *peekIterator) getNextPage(ctx context.Context) error {
 	// Update last seen sequence number so that the next read starts from where this ended.
 	lastMsg := msgs[len(msgs)-1]
 	pi.lastSequenceNumber = *lastMsg.SystemProperties.SequenceNumber + 1
+
+	if pi.readAhead {
+		pi.startReadAhead()
+	}
 	return nil
 }
+
+// takePending waits for the page being fetched in the background, if any, and reports whether it was usable. A page
+// which found no messages is usable, since it marks the end of the entity; other failed background fetches are
+// discarded so that the caller retries with its own context.
+func (pi *peekIterator) takePending(ctx context.Context) ([]*Message, bool, error) {
+	if pi.pending == nil {
+		return nil, false, nil
+	}
+
+	select {
+	case page := <-pi.pending:
+		pi.pending = nil
+		if _, ok := page.err.(ErrNoMessages); ok {
+			return nil, true, nil
+		}
+		if page.err != nil {
+			tab.For(ctx).Debug("discarding failed read-ahead page: " + page.err.Error())
+			return nil, false, nil
+		}
+		return page.msgs, true, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// startReadAhead fetches the page following the last seen sequence number in the background.
+func (pi *peekIterator) startReadAhead() {
+	pending := make(chan peekPage, 1)
+	pi.pending = pending
+
+	from, count := pi.lastSequenceNumber, int32(cap(pi.buffer))
+	go func() {
+		ctx, cancel := context.WithTimeout(pi.ctx, peekReadAheadTimeout)
+		defer cancel()
+
+		ctx, span := startConsumerSpanFromContext(ctx, "sb.peekIterator.readAhead")
+		defer span.End()
+
+		msgs, err := pi.fetchPage(ctx, from, count)
+		pending <- peekPage{msgs: msgs, err: err}
+	}()
+}
+
+// seekEnqueuedTime moves the starting point of the iterator to the first message enqueued at or after
+// fromEnqueuedTime. It is run once, before the first page is fetched.
+func (pi *peekIterator) seekEnqueuedTime(ctx context.Context) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.peekIterator.seekEnqueuedTime")
+	defer span.End()
+
+	target := *pi.fromEnqueuedTime
+
+	// peekAt returns the first message with a sequence number of at least 'seq', or nil if there is none.
+	peekAt := func(seq int64) (*Message, error) {
+		msgs, err := pi.fetchPage(ctx, seq, 1)
+		if _, ok := err.(ErrNoMessages); ok || (err == nil && len(msgs) == 0) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return msgs[0], nil
+	}
+
+	first, err := peekAt(pi.lastSequenceNumber)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+
+	pi.fromEnqueuedTime = nil
+	if first == nil || !enqueuedBefore(first, target) {
+		return nil
+	}
+
+	// lo is always the sequence number of a message enqueued before the target, while hi is either the sequence number
+	// of a message enqueued at or after the target, or a sequence number past the last message.
+	lo := *first.SystemProperties.SequenceNumber
+	var hi int64
+
+	// gallop forward until the target is bracketed, since the last sequence number of the entity is unknown.
+	for step := int64(1); ; step *= 2 {
+		probe := lo + step
+		msg, err := peekAt(probe)
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return err
+		}
+
+		if msg == nil {
+			hi = probe
+			break
+		}
+
+		if seq := *msg.SystemProperties.SequenceNumber; enqueuedBefore(msg, target) {
+			lo = seq
+		} else {
+			hi = seq
+			break
+		}
+	}
+
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		msg, err := peekAt(mid)
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return err
+		}
+
+		switch {
+		case msg == nil || *msg.SystemProperties.SequenceNumber >= hi:
+			// nothing between mid and hi, so hi is the first candidate after lo.
+			hi = mid
+		case enqueuedBefore(msg, target):
+			lo = *msg.SystemProperties.SequenceNumber
+		default:
+			hi = *msg.SystemProperties.SequenceNumber
+		}
+	}
+
+	pi.lastSequenceNumber = hi
+	return nil
+}
+
+// enqueuedBefore reports whether msg was enqueued before t. Messages without an enqueued time are not.
+func enqueuedBefore(msg *Message, t time.Time) bool {
+	if msg.SystemProperties == nil || msg.SystemProperties.EnqueuedTime == nil {
+		return false
+	}
+	return msg.SystemProperties.EnqueuedTime.Before(t)
+}
+
+func (pi *peekIterator) fetchPageFromRPCClient(ctx context.Context, fromSequenceNumber int64, messageCount int32) ([]*Message, error) {
+	client, err := pi.getRPCClient(ctx)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	return client.GetNextPage(ctx, fromSequenceNumber, messageCount, pi.sessionID)
+}
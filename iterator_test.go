@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"math/rand"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -251,3 +252,150 @@ func logMessageMatches(t *testing.T, matches, total uint) {
 			float32(matches)/float32(total)*100)
 	}
 }
+
+func TestPeekWithSession(t *testing.T) {
+	pi, err := newPeekIterator(context.Background(), nil, PeekWithSession("my-session"))
+	require.NoError(t, err)
+	require.NotNil(t, pi.sessionID)
+	assert.Equal(t, "my-session", *pi.sessionID)
+
+	_, err = newPeekIterator(context.Background(), nil, PeekWithSession(""))
+	assert.Error(t, err)
+
+	pi, err = newPeekIterator(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Nil(t, pi.sessionID)
+}
+
+// fakePeekEntity serves peek requests from an in-memory list of messages, ordered by sequence number.
+type fakePeekEntity struct {
+	msgs    []*Message
+	fetches int32
+}
+
+func newFakePeekEntity(start time.Time, seqs ...int64) *fakePeekEntity {
+	fe := &fakePeekEntity{}
+	for _, seq := range seqs {
+		seq := seq
+		enqueued := start.Add(time.Duration(seq) * time.Minute)
+		fe.msgs = append(fe.msgs, &Message{
+			Data: []byte(strconv.FormatInt(seq, 10)),
+			SystemProperties: &SystemProperties{
+				SequenceNumber: &seq,
+				EnqueuedTime:   &enqueued,
+			},
+		})
+	}
+	return fe
+}
+
+func (fe *fakePeekEntity) fetchPage(ctx context.Context, from int64, count int32) ([]*Message, error) {
+	atomic.AddInt32(&fe.fetches, 1)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var page []*Message
+	for _, msg := range fe.msgs {
+		if *msg.SystemProperties.SequenceNumber >= from && int32(len(page)) < count {
+			page = append(page, msg)
+		}
+	}
+	if len(page) == 0 {
+		return nil, ErrNoMessages{}
+	}
+	return page, nil
+}
+
+func newFakePeekIterator(t *testing.T, fe *fakePeekEntity, options ...PeekOption) *peekIterator {
+	return newFakePeekIteratorWithContext(t, context.Background(), fe, options...)
+}
+
+func newFakePeekIteratorWithContext(t *testing.T, ctx context.Context, fe *fakePeekEntity, options ...PeekOption) *peekIterator {
+	pi, err := newPeekIterator(ctx, nil, options...)
+	require.NoError(t, err)
+	pi.fetchPage = fe.fetchPage
+	return pi
+}
+
+func TestPeekIterator_ReadAhead(t *testing.T) {
+	ctx := context.Background()
+	fe := newFakePeekEntity(time.Now(), 1, 2, 3, 4, 5)
+	pi := newFakePeekIterator(t, fe, PeekWithPageSize(2))
+
+	for want := int64(1); want <= 5; want++ {
+		msg, err := pi.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, *msg.SystemProperties.SequenceNumber)
+
+		if want == 1 {
+			// the second page is requested in the background as soon as the first one is buffered
+			require.NotNil(t, pi.pending)
+		}
+	}
+
+	_, err := pi.Next(ctx)
+	assert.IsType(t, ErrNoMessages{}, err)
+	// the end of the entity found by the last read-ahead is not fetched again
+	assert.Equal(t, int32(4), atomic.LoadInt32(&fe.fetches))
+
+	// the end of the entity is not remembered, so messages sent afterwards are still found
+	seq := int64(6)
+	fe.msgs = append(fe.msgs, &Message{SystemProperties: &SystemProperties{SequenceNumber: &seq}})
+	msg, err := pi.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, seq, *msg.SystemProperties.SequenceNumber)
+}
+
+func TestPeekIterator_ReadAheadStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fe := newFakePeekEntity(time.Now(), 1, 2, 3, 4)
+	pi := newFakePeekIteratorWithContext(t, ctx, fe, PeekWithPageSize(2))
+
+	msg, err := pi.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *msg.SystemProperties.SequenceNumber)
+	cancel()
+
+	// the cancelled read-ahead is discarded, and the page fetched again with the context of Next
+	for want := int64(2); want <= 4; want++ {
+		msg, err := pi.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, want, *msg.SystemProperties.SequenceNumber)
+	}
+}
+
+func TestPeekFromEnqueuedTime_WithoutEnqueuedTime(t *testing.T) {
+	start := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	fe := newFakePeekEntity(start, 1, 2, 3, 4)
+	fe.msgs[1].SystemProperties.EnqueuedTime = nil
+
+	pi := newFakePeekIterator(t, fe, PeekFromEnqueuedTime(start.Add(3*time.Minute)))
+	msg, err := pi.Next(context.Background())
+	require.NoError(t, err)
+	// a message without an enqueued time is not enqueued before the target, so browsing starts there
+	assert.Equal(t, int64(2), *msg.SystemProperties.SequenceNumber)
+}
+
+func TestPeekFromEnqueuedTime(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC)
+	seqs := []int64{3, 4, 7, 8, 9, 15, 16, 40, 41, 42, 100}
+
+	for _, want := range seqs {
+		fe := newFakePeekEntity(start, seqs...)
+		pi := newFakePeekIterator(t, fe, PeekFromEnqueuedTime(start.Add(time.Duration(want)*time.Minute-time.Second)))
+
+		msg, err := pi.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, *msg.SystemProperties.SequenceNumber)
+	}
+
+	fe := newFakePeekEntity(start, seqs...)
+	pi := newFakePeekIterator(t, fe, PeekFromEnqueuedTime(start.Add(time.Hour*24)))
+	_, err := pi.Next(ctx)
+	assert.IsType(t, ErrNoMessages{}, err)
+
+	_, err = newPeekIterator(context.Background(), nil, PeekFromEnqueuedTime(time.Time{}))
+	assert.Error(t, err)
+}
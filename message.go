@@ -41,6 +41,8 @@ type (
 		ContentType      string
 		CorrelationID    string
 		Data             []byte
+		Body             *MessageBody
+		AMQP             *AMQPAttributes
 		DeliveryCount    uint32
 		SessionID        *string
 		GroupSequence    *uint32
@@ -59,6 +61,10 @@ type (
 		useSession       bool
 		sessionID        *string
 		receiver         *amqp.Receiver
+		payloadStore     PayloadStore
+		payloadReference string
+		processedStore   ProcessedStore
+		processedKey     string
 	}
 
 	// DispositionAction represents the action to notify Azure Service Bus of the Message's disposition
@@ -120,6 +126,18 @@ const (
 	lockTokenName = "x-opt-lock-token"
 )
 
+// Dead-letter user properties
+const (
+	// DeadLetterReasonProperty is the user property in which Service Bus records why a message was dead-lettered
+	DeadLetterReasonProperty = "DeadLetterReason"
+	// DeadLetterErrorDescriptionProperty is the user property in which Service Bus records the description of the
+	// error which caused a message to be dead-lettered
+	DeadLetterErrorDescriptionProperty = "DeadLetterErrorDescription"
+	// DeadLetterSourceProperty is the user property into which Message.Clone copies the entity a message was
+	// dead-lettered from, when CloneWithDeadLetterMetadata is used
+	DeadLetterSourceProperty = "DeadLetterSource"
+)
+
 // NewMessageFromString builds an Message from a string message
 func NewMessageFromString(message string) *Message {
 	return NewMessage([]byte(message))
@@ -184,11 +202,18 @@ func (m *Message) Complete(ctx context.Context) error {
 	_, span := m.startSpanFromContext(ctx, "sb.Message.Complete")
 	defer span.End()
 
+	var err error
 	if m.ec != nil {
-		return sendMgmtDisposition(ctx, m, disposition{Status: completedDisposition})
+		err = sendMgmtDisposition(ctx, m, disposition{Status: completedDisposition})
+	} else {
+		err = m.receiver.AcceptMessage(ctx, m.message)
 	}
 
-	return m.receiver.AcceptMessage(ctx, m.message)
+	if err == nil {
+		m.deletePayload(ctx)
+		m.markProcessed(ctx)
+	}
+	return err
 }
 
 // Abandon will notify Azure Service Bus the message failed but should be re-queued for delivery.
@@ -266,6 +291,9 @@ func (m *Message) DeadLetter(ctx context.Context, err error) error {
 
 // DeadLetterWithInfo will notify Azure Service Bus the message failed and should not be re-queued with additional
 // context
+//
+// The "DeadLetterReason" and "DeadLetterErrorDescription" keys of additionalData are recorded by Service Bus as the
+// dead-letter reason and description of the message.
 func (m *Message) DeadLetterWithInfo(ctx context.Context, err error, condition MessageErrorCondition, additionalData map[string]string) error {
 	_, span := m.startSpanFromContext(ctx, "sb.Message.DeadLetterWithInfo")
 	defer span.End()
@@ -276,6 +304,12 @@ func (m *Message) DeadLetterWithInfo(ctx context.Context, err error, condition M
 			DeadLetterDescription: ptrString(err.Error()),
 			DeadLetterReason:      ptrString("amqp:error"),
 		}
+		if reason, ok := additionalData[DeadLetterReasonProperty]; ok {
+			d.DeadLetterReason = ptrString(reason)
+		}
+		if description, ok := additionalData[DeadLetterErrorDescriptionProperty]; ok {
+			d.DeadLetterDescription = ptrString(description)
+		}
 		return sendMgmtDisposition(ctx, m, d)
 	}
 
@@ -332,20 +366,35 @@ func sendMgmtDisposition(ctx context.Context, m *Message, state disposition) err
 }
 
 func (m *Message) toMsg() (*amqp.Message, error) {
-	amqpMsg := m.message
-	if amqpMsg == nil {
-		amqpMsg = amqp.NewMessage(m.Data)
+	if err := m.validateProperties(); err != nil {
+		return nil, err
+	}
+
+	// every section is built from the fields of the Message, so the AMQP message it was received as is not reused.
+	amqpMsg := &amqp.Message{
+		Format: m.Format,
+	}
+
+	if err := m.setAMQPBody(amqpMsg); err != nil {
+		return nil, err
 	}
 
 	if m.TTL != nil {
 		if amqpMsg.Header == nil {
-			amqpMsg.Header = new(amqp.MessageHeader)
+			// 4 is the default priority, which is omitted on the wire
+			amqpMsg.Header = &amqp.MessageHeader{Priority: 4}
 		}
 		amqpMsg.Header.TTL = *m.TTL
 	}
 
+	var typedMessageID, typedCorrelationID interface{}
+	if m.AMQP != nil {
+		typedMessageID, typedCorrelationID = m.AMQP.MessageID, m.AMQP.CorrelationID
+	}
+
 	amqpMsg.Properties = &amqp.MessageProperties{
-		MessageID: m.ID,
+		MessageID:     amqpID(m.ID, typedMessageID),
+		CorrelationID: amqpID(m.CorrelationID, typedCorrelationID),
 	}
 
 	if m.SessionID != nil {
@@ -356,7 +405,6 @@ func (m *Message) toMsg() (*amqp.Message, error) {
 		amqpMsg.Properties.GroupSequence = *m.GroupSequence
 	}
 
-	amqpMsg.Properties.CorrelationID = m.CorrelationID
 	amqpMsg.Properties.ContentType = m.ContentType
 	amqpMsg.Properties.Subject = m.Label
 	amqpMsg.Properties.To = m.To
@@ -382,6 +430,8 @@ func (m *Message) toMsg() (*amqp.Message, error) {
 		amqpMsg.Annotations = addMapToAnnotations(amqpMsg.Annotations, sysPropMap)
 	}
 
+	m.setAMQPAttributes(amqpMsg)
+
 	if m.LockToken != nil {
 		if amqpMsg.DeliveryAnnotations == nil {
 			amqpMsg.DeliveryAnnotations = make(amqp.Annotations)
@@ -392,6 +442,22 @@ func (m *Message) toMsg() (*amqp.Message, error) {
 	return amqpMsg, nil
 }
 
+// EncodedSize returns the number of bytes the message takes once encoded for the wire, as it is. Send assigns an ID
+// and a session to messages which have none, and a Sender may compress, encrypt or claim check the payload, all of
+// which change the size of what is sent.
+func (m *Message) EncodedSize() (int, error) {
+	msg, err := m.toMsg()
+	if err != nil {
+		return 0, err
+	}
+
+	bin, err := msg.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	return len(bin), nil
+}
+
 func addMapToAnnotations(a amqp.Annotations, m map[string]interface{}) amqp.Annotations {
 	if a == nil && len(m) > 0 {
 		a = make(amqp.Annotations)
@@ -404,7 +470,12 @@ func addMapToAnnotations(a amqp.Annotations, m map[string]interface{}) amqp.Anno
 }
 
 func messageFromAMQPMessage(msg *amqp.Message, r *amqp.Receiver) (*Message, error) {
-	return newMessage(msg.GetData(), msg, r)
+	data, body := bodyFromAMQPMessage(msg)
+	m, err := newMessage(data, msg, r)
+	if m != nil {
+		m.Body = body
+	}
+	return m, err
 }
 
 func newMessage(data []byte, amqpMsg *amqp.Message, r *amqp.Receiver) (*Message, error) {
@@ -419,25 +490,25 @@ func newMessage(data []byte, amqpMsg *amqp.Message, r *amqp.Receiver) (*Message,
 	}
 
 	if amqpMsg.Properties != nil {
-		if id, ok := amqpMsg.Properties.MessageID.(string); ok {
-			msg.ID = id
-		}
+		// IDs which are not strings are kept in AMQPAttributes, and exposed here in their string form.
+		msg.ID = formatAMQPID(amqpMsg.Properties.MessageID)
 		msg.SessionID = &amqpMsg.Properties.GroupID
 		msg.GroupSequence = &amqpMsg.Properties.GroupSequence
-		if id, ok := amqpMsg.Properties.CorrelationID.(string); ok {
-			msg.CorrelationID = id
-		}
+		msg.CorrelationID = formatAMQPID(amqpMsg.Properties.CorrelationID)
 		msg.ContentType = amqpMsg.Properties.ContentType
 		msg.Label = amqpMsg.Properties.Subject
 		msg.To = amqpMsg.Properties.To
 		msg.ReplyTo = amqpMsg.Properties.ReplyTo
 		msg.ReplyToGroupID = amqpMsg.Properties.ReplyToGroupID
-		if amqpMsg.Header != nil {
-			msg.DeliveryCount = amqpMsg.Header.DeliveryCount + 1
-			msg.TTL = &amqpMsg.Header.TTL
-		}
 	}
 
+	if amqpMsg.Header != nil {
+		msg.DeliveryCount = amqpMsg.Header.DeliveryCount + 1
+		msg.TTL = &amqpMsg.Header.TTL
+	}
+
+	msg.readAMQPAttributes(amqpMsg)
+
 	if amqpMsg.ApplicationProperties != nil {
 		msg.UserProperties = make(map[string]interface{}, len(amqpMsg.ApplicationProperties))
 		for key, value := range amqpMsg.ApplicationProperties {
@@ -168,6 +168,14 @@ func newEntityManager(host string, tokenProvider auth.TokenProvider) *entityMana
 	}
 }
 
+// newNamespaceEntityManager creates a new instance of an entityManager for the namespace, whose calls consult the
+// throttle of the namespace
+func newNamespaceEntityManager(ns *Namespace) *entityManager {
+	em := newEntityManager(ns.getHTTPSHostURI(), ns.TokenProvider)
+	em.Use(ns.throttle.throttleMiddleware)
+	return em
+}
+
 // Get performs an HTTP Get for a given entity path
 func (em *entityManager) Get(ctx context.Context, entityPath string, mw ...MiddlewareFunc) (*http.Response, error) {
 	ctx, span := em.startSpanFromContext(ctx, "sb.EntityManger.Get")
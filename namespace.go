@@ -75,11 +75,16 @@ type (
 		initRefresh sync.Once
 		// populated with the result from auto-refresh, to be called elsewhere
 		cancelRefresh func() <-chan struct{}
+		// shared by every sender, receiver, RPC and management call of the namespace
+		throttle *throttle
 
 		// for testing
 
 		// alias for 'amqp.Dial'
 		amqpDial func(addr string, opts ...amqp.ConnOption) (*amqp.Client, error)
+
+		// replaces 'Namespace.NewSender' for the Senders of a SenderPool, if set
+		newPooledSender func(ctx context.Context, entityPath string, opts ...SenderOption) (pooledSender, error)
 	}
 
 	// NamespaceOption provides structure for configuring a new Service Bus namespace
@@ -195,6 +200,7 @@ func NewNamespace(opts ...NamespaceOption) (*Namespace, error) {
 	ns := &Namespace{
 		Environment: azure.PublicCloud,
 		amqpDial:    amqp.Dial,
+		throttle:    newThrottle(),
 	}
 
 	for _, opt := range opts {
@@ -8,6 +8,9 @@ const (
 	peekMessageOperationID     = vendorPrefix + "peek-message"
 	scheduleMessageOperationID = vendorPrefix + "schedule-message"
 	cancelScheduledOperationID = vendorPrefix + "cancel-scheduled-message"
+	addRuleOperationID         = vendorPrefix + "add-rule"
+	removeRuleOperationID      = vendorPrefix + "remove-rule"
+	enumerateRulesOperationID  = vendorPrefix + "enumerate-rules"
 )
 
 // Field Descriptions
@@ -16,4 +19,8 @@ const (
 	lockTokensFieldName    = "lock-tokens"
 	serverTimeoutFieldName = vendorPrefix + "server-timeout"
 	associatedLinkName     = "associated-link-name"
+
+	ruleNameFieldName        = "rule-name"
+	ruleDescriptionFieldName = "rule-description"
+	rulesFieldName           = "rules"
 )
@@ -0,0 +1,490 @@
+package servicebus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/devigned/tab"
+)
+
+type (
+	// Outbox stores outgoing messages in a database/sql table within the transaction of the caller, so that they are
+	// published if and only if the transaction commits. Stored messages are published by an OutboxRelay.
+	//
+	// The table has the following columns, and can be created with CreateTable:
+	//
+	//	message_id      VARCHAR(128) PRIMARY KEY
+	//	message         TEXT, the message encoded by Message.MarshalJSON
+	//	enqueued_at     BIGINT, in Unix microseconds, increasing with each message added through the Outbox
+	//	attempts        INTEGER
+	//	next_attempt_at BIGINT, in Unix milliseconds
+	//	sent_at         BIGINT NULL, in Unix milliseconds
+	//	last_error      TEXT NULL
+	Outbox struct {
+		db          *sql.DB
+		table       string
+		placeholder func(n int) string
+		// lastEnqueuedAt keeps the messages added through the Outbox in order, even within a microsecond
+		lastEnqueuedAt int64
+		mu             sync.Mutex
+	}
+
+	// OutboxOption provides a way to customize an Outbox
+	OutboxOption func(*Outbox) error
+
+	// OutboxRelay publishes the messages stored in an Outbox, oldest first, and marks them as sent. Messages which fail
+	// to publish are retried with an exponential backoff. Each message keeps the MessageID it was stored with, so that
+	// the duplicate detection of the entity discards messages published more than once, such as when the relay stops
+	// between publishing a message and marking it as sent, or when several relays run against the same table.
+	//
+	// A stored message which cannot be decoded is marked as failed with the decoding error and retried with the same
+	// backoff, so that it does not hold back the messages stored after it.
+	OutboxRelay struct {
+		outbox       *Outbox
+		batchSize    int
+		pollInterval time.Duration
+		minBackoff   time.Duration
+		maxBackoff   time.Duration
+
+		// publish sends messages, returning an error for each, and is replaceable for testing
+		publish func(ctx context.Context, msgs []*Message) []error
+		// replaceable for testing
+		now func() time.Time
+	}
+
+	// OutboxRelayOption provides a way to customize an OutboxRelay
+	OutboxRelayOption func(*OutboxRelay) error
+
+	outboxRow struct {
+		id       string
+		msg      *Message
+		attempts int
+		// decodeErr is the error decoding the stored message, which is then not published
+		decodeErr error
+	}
+)
+
+const (
+	// DefaultOutboxTable is the name of the table of an Outbox by default
+	DefaultOutboxTable = "servicebus_outbox"
+	// DefaultOutboxBatchSize is how many messages an OutboxRelay publishes at once by default
+	DefaultOutboxBatchSize = 100
+	// DefaultOutboxPollInterval is how long an OutboxRelay waits for new messages once the outbox is empty by default
+	DefaultOutboxPollInterval = time.Second
+
+	defaultOutboxMinBackoff = time.Second
+	defaultOutboxMaxBackoff = 5 * time.Minute
+	outboxMaxMessageIDSize  = 128
+)
+
+var (
+	sqlTableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+// OutboxWithTable configures the table the Outbox stores messages in. The default is DefaultOutboxTable. The name may
+// be qualified by a schema, as in "schema.table".
+func OutboxWithTable(table string) OutboxOption {
+	return func(o *Outbox) error {
+		if err := validateSQLTableName(table); err != nil {
+			return err
+		}
+		o.table = table
+		return nil
+	}
+}
+
+// OutboxWithNumberedPlaceholders configures the Outbox to write query parameters as $1, $2 and so on, as PostgreSQL
+// expects, rather than as ?.
+func OutboxWithNumberedPlaceholders() OutboxOption {
+	return func(o *Outbox) error {
+		o.placeholder = numberedSQLPlaceholder
+		return nil
+	}
+}
+
+// NewOutbox creates an Outbox storing messages in db.
+func NewOutbox(db *sql.DB, opts ...OutboxOption) (*Outbox, error) {
+	if db == nil {
+		return nil, errors.New("db must not be nil")
+	}
+
+	o := &Outbox{
+		db:          db,
+		table:       DefaultOutboxTable,
+		placeholder: questionMarkSQLPlaceholder,
+	}
+
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// CreateTable creates the table of the Outbox if it does not exist, along with an index of the messages to publish.
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + o.table + ` (
+			message_id VARCHAR(128) NOT NULL PRIMARY KEY,
+			message TEXT NOT NULL,
+			enqueued_at BIGINT NOT NULL,
+			attempts INTEGER NOT NULL,
+			next_attempt_at BIGINT NOT NULL,
+			sent_at BIGINT NULL,
+			last_error TEXT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + strings.Replace(o.table, ".", "_", -1) + `_pending ON ` + o.table + ` (sent_at, next_attempt_at)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := o.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add stores msgs within tx, to be published once tx commits. Messages without an ID are given a new UUID, which they
+// keep when published.
+func (o *Outbox) Add(ctx context.Context, tx *sql.Tx, msgs ...*Message) error {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.Outbox.Add")
+	defer span.End()
+
+	query := o.query(`INSERT INTO `+o.table+` (message_id, message, enqueued_at, attempts, next_attempt_at) VALUES (%s, %s, %s, 0, %s)`, 4)
+	now := time.Now()
+	for _, msg := range msgs {
+		if msg.ID == "" {
+			id, err := uuid.NewV4()
+			if err != nil {
+				tab.For(ctx).Error(err)
+				return err
+			}
+			msg.ID = id.String()
+		}
+
+		if len(msg.ID) > outboxMaxMessageIDSize {
+			err := fmt.Errorf("message ID must be at most %d characters long, but was %d", outboxMaxMessageIDSize, len(msg.ID))
+			tab.For(ctx).Error(err)
+			return err
+		}
+
+		encoded, err := msg.MarshalJSON()
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, query, msg.ID, string(encoded), o.nextEnqueuedAt(now), unixMillis(now)); err != nil {
+			tab.For(ctx).Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Pending returns the number of messages which are not published yet.
+func (o *Outbox) Pending(ctx context.Context) (int, error) {
+	var count int
+	err := o.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+o.table+` WHERE sent_at IS NULL`).Scan(&count)
+	return count, err
+}
+
+// DeleteSent deletes the messages published before olderThan, returning how many were deleted.
+func (o *Outbox) DeleteSent(ctx context.Context, olderThan time.Time) (int64, error) {
+	res, err := o.db.ExecContext(ctx, o.query(`DELETE FROM `+o.table+` WHERE sent_at IS NOT NULL AND sent_at < %s`, 1), unixMillis(olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// nextEnqueuedAt returns now in Unix microseconds, or just after the last message added if that is later.
+func (o *Outbox) nextEnqueuedAt(now time.Time) int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	enqueuedAt := now.UnixNano() / int64(time.Microsecond)
+	if enqueuedAt <= o.lastEnqueuedAt {
+		enqueuedAt = o.lastEnqueuedAt + 1
+	}
+	o.lastEnqueuedAt = enqueuedAt
+	return enqueuedAt
+}
+
+// query formats a query with n placeholders.
+func (o *Outbox) query(format string, n int) string {
+	return formatSQLQuery(o.placeholder, format, n)
+}
+
+// OutboxRelayWithBatchSize configures how many messages the relay publishes at once. The default is
+// DefaultOutboxBatchSize.
+func OutboxRelayWithBatchSize(size int) OutboxRelayOption {
+	return func(r *OutboxRelay) error {
+		if size < 1 {
+			return fmt.Errorf("batch size must be at least 1, but was %d", size)
+		}
+		r.batchSize = size
+		return nil
+	}
+}
+
+// OutboxRelayWithPollInterval configures how long the relay waits for new messages once the outbox is empty. The
+// default is DefaultOutboxPollInterval.
+func OutboxRelayWithPollInterval(interval time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) error {
+		if interval <= 0 {
+			return fmt.Errorf("poll interval must be positive, but was %v", interval)
+		}
+		r.pollInterval = interval
+		return nil
+	}
+}
+
+// OutboxRelayWithBackoff configures how long the relay waits before publishing a message again after a failure. The
+// wait starts at min and doubles with each attempt, up to max. The default is from one second to five minutes.
+func OutboxRelayWithBackoff(min, max time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("backoff must be positive and its maximum at least its minimum, but was %v to %v", min, max)
+		}
+		r.minBackoff = min
+		r.maxBackoff = max
+		return nil
+	}
+}
+
+// NewOutboxRelay creates an OutboxRelay publishing the messages of outbox with sender, one at a time.
+func NewOutboxRelay(outbox *Outbox, sender *Sender, opts ...OutboxRelayOption) (*OutboxRelay, error) {
+	if sender == nil {
+		return nil, errors.New("sender must not be nil")
+	}
+
+	return newOutboxRelay(outbox, func(ctx context.Context, msgs []*Message) []error {
+		errs := make([]error, len(msgs))
+		for i, msg := range msgs {
+			errs[i] = sender.Send(ctx, msg)
+		}
+		return errs
+	}, opts...)
+}
+
+// NewBufferedOutboxRelay creates an OutboxRelay publishing the messages of outbox with sender, which sends them in
+// batches.
+func NewBufferedOutboxRelay(outbox *Outbox, sender *BufferedSender, opts ...OutboxRelayOption) (*OutboxRelay, error) {
+	if sender == nil {
+		return nil, errors.New("sender must not be nil")
+	}
+
+	return newOutboxRelay(outbox, func(ctx context.Context, msgs []*Message) []error {
+		errs := make([]error, len(msgs))
+		results := make([]*SendResult, len(msgs))
+		for i, msg := range msgs {
+			results[i], errs[i] = sender.Send(ctx, msg)
+		}
+
+		for i, result := range results {
+			if result != nil {
+				errs[i] = result.Wait(ctx)
+			}
+		}
+		return errs
+	}, opts...)
+}
+
+func newOutboxRelay(outbox *Outbox, publish func(context.Context, []*Message) []error, opts ...OutboxRelayOption) (*OutboxRelay, error) {
+	if outbox == nil {
+		return nil, errors.New("outbox must not be nil")
+	}
+
+	r := &OutboxRelay{
+		outbox:       outbox,
+		batchSize:    DefaultOutboxBatchSize,
+		pollInterval: DefaultOutboxPollInterval,
+		minBackoff:   defaultOutboxMinBackoff,
+		maxBackoff:   defaultOutboxMaxBackoff,
+		publish:      publish,
+		now:          time.Now,
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Run publishes messages until ctx is done, waiting for the poll interval whenever the outbox has no message to
+// publish. Errors reading or updating the outbox are retried after the poll interval; Run only returns the error of
+// ctx.
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			tab.For(ctx).Error(err)
+		}
+
+		if n > 0 && err == nil {
+			continue
+		}
+
+		timer := time.NewTimer(r.pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RelayOnce publishes a batch of the messages due for publishing, and returns how many were published.
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.OutboxRelay.RelayOnce")
+	defer span.End()
+
+	rows, err := r.due(ctx)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return 0, err
+	}
+
+	var msgs []*Message
+	decoded := rows[:0]
+	for _, row := range rows {
+		if row.decodeErr != nil {
+			tab.For(ctx).Error(row.decodeErr)
+			if err := r.markFailed(ctx, row, row.decodeErr); err != nil {
+				tab.For(ctx).Error(err)
+				return 0, err
+			}
+			continue
+		}
+
+		msgs = append(msgs, row.msg)
+		decoded = append(decoded, row)
+	}
+	rows = decoded
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	errs := r.publish(ctx, msgs)
+
+	published := 0
+	for i, row := range rows {
+		if errs[i] != nil {
+			tab.For(ctx).Error(errs[i])
+			if err := r.markFailed(ctx, row, errs[i]); err != nil {
+				tab.For(ctx).Error(err)
+				return published, err
+			}
+			continue
+		}
+
+		if err := r.markSent(ctx, row); err != nil {
+			tab.For(ctx).Error(err)
+			return published, err
+		}
+		published++
+	}
+	return published, nil
+}
+
+// due reads the oldest messages due for publishing. Messages which cannot be decoded are returned with their
+// decodeErr set.
+func (r *OutboxRelay) due(ctx context.Context) ([]outboxRow, error) {
+	o := r.outbox
+	query := o.query(`SELECT message_id, message, attempts FROM `+o.table+
+		` WHERE sent_at IS NULL AND next_attempt_at <= %s ORDER BY enqueued_at, message_id LIMIT %s`, 2)
+
+	rows, err := o.db.QueryContext(ctx, query, unixMillis(r.now()), r.batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		var encoded string
+		if err := rows.Scan(&row.id, &encoded, &row.attempts); err != nil {
+			return nil, err
+		}
+
+		msg := new(Message)
+		if err := msg.UnmarshalJSON([]byte(encoded)); err != nil {
+			row.decodeErr = fmt.Errorf("outbox message %q cannot be decoded: %v", row.id, err)
+		} else {
+			row.msg = msg
+		}
+		due = append(due, row)
+	}
+	return due, rows.Err()
+}
+
+func (r *OutboxRelay) markSent(ctx context.Context, row outboxRow) error {
+	o := r.outbox
+	_, err := o.db.ExecContext(ctx, o.query(`UPDATE `+o.table+` SET sent_at = %s, last_error = NULL WHERE message_id = %s`, 2),
+		unixMillis(r.now()), row.id)
+	return err
+}
+
+func (r *OutboxRelay) markFailed(ctx context.Context, row outboxRow, cause error) error {
+	o := r.outbox
+	attempts := row.attempts + 1
+	next := r.now().Add(r.backoff(attempts))
+	_, err := o.db.ExecContext(ctx, o.query(`UPDATE `+o.table+` SET attempts = %s, next_attempt_at = %s, last_error = %s WHERE message_id = %s`, 4),
+		attempts, unixMillis(next), cause.Error(), row.id)
+	return err
+}
+
+// backoff returns how long to wait before publishing a message again after its attempts failed.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	backoff := r.minBackoff
+	for i := 1; i < attempts && backoff < r.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > r.maxBackoff {
+		backoff = r.maxBackoff
+	}
+	return backoff
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func validateSQLTableName(table string) error {
+	if !sqlTableNamePattern.MatchString(table) {
+		return fmt.Errorf("%q is not a valid table name", table)
+	}
+	return nil
+}
+
+func questionMarkSQLPlaceholder(int) string {
+	return "?"
+}
+
+func numberedSQLPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// formatSQLQuery formats a query with n placeholders, numbered from 1.
+func formatSQLQuery(placeholder func(n int) string, format string, n int) string {
+	placeholders := make([]interface{}, n)
+	for i := range placeholders {
+		placeholders[i] = placeholder(i + 1)
+	}
+	return fmt.Sprintf(format, placeholders...)
+}
@@ -0,0 +1,241 @@
+//go:build cgo
+// +build cgo
+
+package servicebus
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePublisher struct {
+	published []*Message
+	fail      map[string]error
+}
+
+func (f *fakePublisher) publish(_ context.Context, msgs []*Message) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if err, ok := f.fail[msg.ID]; ok {
+			errs[i] = err
+			continue
+		}
+		f.published = append(f.published, msg)
+	}
+	return errs
+}
+
+func newTestOutbox(t *testing.T, opts ...OutboxOption) *Outbox {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	// every connection to :memory: has a database of its own
+	db.SetMaxOpenConns(1)
+
+	outbox, err := NewOutbox(db, opts...)
+	require.NoError(t, err)
+	require.NoError(t, outbox.CreateTable(context.Background()))
+	return outbox
+}
+
+func addToOutbox(t *testing.T, outbox *Outbox, commit bool, msgs ...*Message) {
+	ctx := context.Background()
+	tx, err := outbox.db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, outbox.Add(ctx, tx, msgs...))
+	if commit {
+		require.NoError(t, tx.Commit())
+	} else {
+		require.NoError(t, tx.Rollback())
+	}
+}
+
+func TestOutbox_AddWithinTransaction(t *testing.T) {
+	ctx := context.Background()
+	outbox := newTestOutbox(t)
+	defer outbox.db.Close()
+
+	committed := NewMessageFromString("committed")
+	addToOutbox(t, outbox, true, committed)
+	addToOutbox(t, outbox, false, NewMessageFromString("rolled back"))
+
+	assert.NotEmpty(t, committed.ID)
+	pending, err := outbox.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+}
+
+func TestOutboxRelay_RelayOnce(t *testing.T) {
+	ctx := context.Background()
+	outbox := newTestOutbox(t, OutboxWithTable("custom_outbox"))
+	defer outbox.db.Close()
+
+	first := NewMessageFromString("first")
+	first.ID = "first"
+	first.UserProperties = map[string]interface{}{"key": "value"}
+	second := NewMessageFromString("second")
+	addToOutbox(t, outbox, true, first, second)
+
+	f := new(fakePublisher)
+	relay, err := newOutboxRelay(outbox, f.publish, OutboxRelayWithBatchSize(10))
+	require.NoError(t, err)
+
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// messages are published in order, with the ID and properties they were stored with
+	require.Len(t, f.published, 2)
+	assert.Equal(t, "first", f.published[0].ID)
+	assert.Equal(t, "first", string(f.published[0].Data))
+	assert.Equal(t, "value", f.published[0].UserProperties["key"])
+	assert.Equal(t, second.ID, f.published[1].ID)
+
+	pending, err := outbox.Pending(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, pending)
+
+	n, err = relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	deleted, err := outbox.DeleteSent(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+}
+
+func TestOutboxRelay_RetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	outbox := newTestOutbox(t)
+	defer outbox.db.Close()
+
+	msg := NewMessageFromString("foo")
+	msg.ID = "failing"
+	addToOutbox(t, outbox, true, msg)
+
+	now := time.Now()
+	f := &fakePublisher{fail: map[string]error{"failing": errors.New("send failed")}}
+	relay, err := newOutboxRelay(outbox, f.publish, OutboxRelayWithBackoff(time.Second, 3*time.Second))
+	require.NoError(t, err)
+	relay.now = func() time.Time { return now }
+
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	var attempts int
+	var lastError string
+	require.NoError(t, outbox.db.QueryRow(`SELECT attempts, last_error FROM servicebus_outbox`).Scan(&attempts, &lastError))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, "send failed", lastError)
+
+	// the message is not due before its backoff expires
+	now = now.Add(500 * time.Millisecond)
+	n, err = relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, outbox.db.QueryRow(`SELECT attempts FROM servicebus_outbox`).Scan(&attempts))
+	assert.Equal(t, 1, attempts)
+
+	delete(f.fail, "failing")
+	now = now.Add(time.Second)
+	n, err = relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, f.published, 1)
+}
+
+func TestOutboxRelay_SkipsUndecodableMessages(t *testing.T) {
+	ctx := context.Background()
+	outbox := newTestOutbox(t)
+	defer outbox.db.Close()
+
+	_, err := outbox.db.Exec(`INSERT INTO servicebus_outbox (message_id, message, enqueued_at, attempts, next_attempt_at)
+		VALUES ('corrupt', 'not json', 0, 0, 0)`)
+	require.NoError(t, err)
+	msg := NewMessageFromString("foo")
+	msg.ID = "valid"
+	addToOutbox(t, outbox, true, msg)
+
+	f := new(fakePublisher)
+	relay, err := newOutboxRelay(outbox, f.publish, OutboxRelayWithBatchSize(1))
+	require.NoError(t, err)
+
+	// the corrupt message is the oldest, and does not hold back the valid one
+	n, err := relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = relay.RelayOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, f.published, 1)
+	assert.Equal(t, "valid", f.published[0].ID)
+
+	var attempts int
+	var lastError string
+	require.NoError(t, outbox.db.QueryRow(`SELECT attempts, last_error FROM servicebus_outbox WHERE message_id = 'corrupt'`).
+		Scan(&attempts, &lastError))
+	assert.Equal(t, 1, attempts)
+	assert.Contains(t, lastError, "cannot be decoded")
+}
+
+func TestOutboxRelay_Backoff(t *testing.T) {
+	relay := &OutboxRelay{minBackoff: time.Second, maxBackoff: 5 * time.Second}
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 2*time.Second, relay.backoff(2))
+	assert.Equal(t, 4*time.Second, relay.backoff(3))
+	assert.Equal(t, 5*time.Second, relay.backoff(4))
+	assert.Equal(t, 5*time.Second, relay.backoff(100))
+}
+
+func TestOutboxRelay_Run(t *testing.T) {
+	outbox := newTestOutbox(t)
+	defer outbox.db.Close()
+	addToOutbox(t, outbox, true, NewMessageFromString("foo"), NewMessageFromString("bar"), NewMessageFromString("baz"))
+
+	f := new(fakeBatchSender)
+	bs := newTestBufferedSender(t, f, BufferedSenderWithLinger(time.Millisecond))
+	defer bs.Close(context.Background())
+
+	relay, err := newOutboxRelay(outbox, nil, OutboxRelayWithBatchSize(2), OutboxRelayWithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+	buffered, err := NewBufferedOutboxRelay(outbox, bs, OutboxRelayWithBatchSize(2), OutboxRelayWithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+	relay.publish = buffered.publish
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, relay.Run(ctx))
+
+	assert.Equal(t, []int{2, 1}, f.batchSizes())
+	pending, err := outbox.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, pending)
+}
+
+func TestOutbox_Options(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	outbox, err := NewOutbox(db, OutboxWithNumberedPlaceholders(), OutboxWithTable("schema.outbox"))
+	require.NoError(t, err)
+	assert.Equal(t, "DELETE FROM schema.outbox WHERE message_id = $1 OR message_id = $2",
+		outbox.query("DELETE FROM "+outbox.table+" WHERE message_id = %s OR message_id = %s", 2))
+
+	_, err = NewOutbox(db, OutboxWithTable("outbox; DROP TABLE users"))
+	assert.Error(t, err)
+	_, err = NewOutbox(nil)
+	assert.Error(t, err)
+
+	_, err = newOutboxRelay(outbox, nil, OutboxRelayWithBackoff(time.Second, time.Millisecond))
+	assert.Error(t, err)
+	_, err = newOutboxRelay(outbox, nil, OutboxRelayWithBatchSize(0))
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package servicebus
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// PropertyConverter coerces the value of a user property or annotation of a type Service Bus does not support into
+	// one it does. It returns the converted value and true, or false if it does not apply to the value.
+	PropertyConverter func(value interface{}) (interface{}, bool)
+)
+
+const (
+	goAMQPPackagePath = "github.com/Azure/go-amqp"
+)
+
+var (
+	// DurationConverter converts a time.Duration into its number of milliseconds, as an int64
+	DurationConverter PropertyConverter = func(value interface{}) (interface{}, bool) {
+		d, ok := value.(time.Duration)
+		if !ok {
+			return nil, false
+		}
+		return int64(d / time.Millisecond), true
+	}
+
+	// UUIDConverter converts a uuid.UUID into an amqp.UUID
+	UUIDConverter PropertyConverter = func(value interface{}) (interface{}, bool) {
+		id, ok := value.(uuid.UUID)
+		if !ok {
+			return nil, false
+		}
+		return amqp.UUID(id), true
+	}
+
+	// StringerConverter converts a fmt.Stringer into the string it returns
+	StringerConverter PropertyConverter = func(value interface{}) (interface{}, bool) {
+		s, ok := value.(fmt.Stringer)
+		if !ok {
+			return nil, false
+		}
+		return s.String(), true
+	}
+
+	// DefaultPropertyConverters are the converters applied by SendWithPropertyConverters when none are given. The
+	// StringerConverter comes last, so that a time.Duration is sent as a number rather than a string.
+	DefaultPropertyConverters = []PropertyConverter{DurationConverter, UUIDConverter, StringerConverter}
+
+	typeOfAMQPUUID = reflect.TypeOf(amqp.UUID{})
+	typeOfTime     = reflect.TypeOf(time.Time{})
+)
+
+// SendWithPropertyConverters converts the user properties and annotations of the message which have a type Service
+// Bus does not support with the first of converters that applies. DefaultPropertyConverters are used when no
+// converters are given. Values which no converter applies to are left for validation to reject.
+func SendWithPropertyConverters(converters ...PropertyConverter) SendOption {
+	return func(m *Message) error {
+		m.ConvertProperties(converters...)
+		return nil
+	}
+}
+
+// ConvertProperties converts the user properties and annotations of the message which have a type Service Bus does
+// not support with the first of converters that applies, or with DefaultPropertyConverters when none are given.
+func (m *Message) ConvertProperties(converters ...PropertyConverter) {
+	if len(converters) == 0 {
+		converters = DefaultPropertyConverters
+	}
+
+	convertProperties(m.UserProperties, converters)
+	if m.SystemProperties != nil {
+		convertProperties(m.SystemProperties.Annotations, converters)
+	}
+}
+
+func convertProperties(props map[string]interface{}, converters []PropertyConverter) {
+	for key, val := range props {
+		if isSupportedPropertyType(val) {
+			continue
+		}
+
+		for _, convert := range converters {
+			if converted, ok := convert(val); ok {
+				props[key] = converted
+				break
+			}
+		}
+	}
+}
+
+// validateProperties checks the user properties and annotations of the message only hold values of the AMQP types
+// Service Bus supports.
+func (m *Message) validateProperties() error {
+	for key, val := range m.UserProperties {
+		if !isSupportedPropertyType(val) {
+			return ErrUnsupportedPropertyType{Key: key, Type: reflect.TypeOf(val)}
+		}
+	}
+
+	if m.SystemProperties != nil {
+		for key, val := range m.SystemProperties.Annotations {
+			if !isSupportedPropertyType(val) {
+				return ErrUnsupportedPropertyType{Key: key, Type: reflect.TypeOf(val), Annotation: true}
+			}
+		}
+	}
+	return nil
+}
+
+// isSupportedPropertyType reports whether val is of an AMQP simple type Service Bus supports for user properties and
+// annotations: a boolean, an integer, a floating point number, a string, binary, a timestamp or a UUID, or a pointer
+// to one of those. Values of a type declared by go-amqp are accepted as they are, as they can only come from a
+// received message.
+func isSupportedPropertyType(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+
+	t := reflect.TypeOf(val)
+	if t.Kind() == reflect.Ptr {
+		if reflect.ValueOf(val).IsNil() {
+			return false
+		}
+		t = t.Elem()
+	}
+
+	if t.PkgPath() == "" {
+		switch t.Kind() {
+		case reflect.Bool,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64,
+			reflect.String:
+			return true
+		case reflect.Slice:
+			return t.Elem().Kind() == reflect.Uint8 && t.Elem().PkgPath() == ""
+		}
+		return false
+	}
+
+	return t == typeOfTime || t == typeOfAMQPUUID || strings.HasPrefix(t.PkgPath(), goAMQPPackagePath)
+}
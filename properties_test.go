@@ -0,0 +1,75 @@
+package servicebus
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_ValidateProperties(t *testing.T) {
+	s := "pointer"
+	supported := map[string]interface{}{
+		"nil":     nil,
+		"bool":    true,
+		"int":     1,
+		"int8":    int8(1),
+		"uint64":  uint64(1),
+		"float32": float32(1),
+		"string":  "value",
+		"pointer": &s,
+		"binary":  []byte{1},
+		"time":    time.Now(),
+		"uuid":    amqp.UUID{},
+	}
+
+	m := NewMessageFromString("foo")
+	m.UserProperties = supported
+	m.SystemProperties = &SystemProperties{Annotations: map[string]interface{}{"custom": int64(1)}}
+	_, err := m.toMsg()
+	assert.NoError(t, err)
+
+	for _, val := range []interface{}{struct{}{}, []string{"a"}, time.Second, uuid.UUID{}, map[string]interface{}{}} {
+		m := NewMessageFromString("foo")
+		m.Set("bad", val)
+		_, err := m.toMsg()
+		assert.Equal(t, ErrUnsupportedPropertyType{Key: "bad", Type: reflect.TypeOf(val)}, err)
+		assert.Contains(t, err.Error(), `user property "bad"`)
+	}
+
+	m = NewMessageFromString("foo")
+	m.SystemProperties = &SystemProperties{Annotations: map[string]interface{}{"bad": time.Second}}
+	_, err = NewMessageBatch(StandardMaxMessageSizeInBytes, "batch", nil).Add(m)
+	assert.Equal(t, ErrUnsupportedPropertyType{Key: "bad", Type: reflect.TypeOf(time.Second), Annotation: true}, err)
+}
+
+func TestSendWithPropertyConverters(t *testing.T) {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	u, err := url.Parse("https://example.com/path")
+	require.NoError(t, err)
+
+	m := NewMessageFromString("foo")
+	m.Set("duration", 1500*time.Millisecond)
+	m.Set("uuid", id)
+	m.Set("stringer", u)
+	m.Set("struct", struct{}{})
+	m.Set("string", "untouched")
+	require.NoError(t, SendWithPropertyConverters()(m))
+
+	assert.Equal(t, int64(1500), m.UserProperties["duration"])
+	assert.Equal(t, amqp.UUID(id), m.UserProperties["uuid"])
+	assert.Equal(t, "https://example.com/path", m.UserProperties["stringer"])
+	assert.Equal(t, struct{}{}, m.UserProperties["struct"], "values no converter applies to are left as they are")
+	assert.Equal(t, "untouched", m.UserProperties["string"])
+
+	m = NewMessageFromString("foo")
+	m.Set("duration", time.Second)
+	require.NoError(t, SendWithPropertyConverters(StringerConverter)(m))
+	assert.Equal(t, "1s", m.UserProperties["duration"])
+}
@@ -42,12 +42,14 @@ type (
 	// message consumer.
 	Queue struct {
 		*sendAndReceiveEntity
-		sender        *Sender
-		receiver      *Receiver
-		receiverMu    sync.Mutex
-		senderMu      sync.Mutex
-		receiveMode   ReceiveMode
-		prefetchCount *uint32
+		sender          *Sender
+		receiver        *Receiver
+		receiverMu      sync.Mutex
+		senderMu        sync.Mutex
+		receiveMode     ReceiveMode
+		prefetchCount   *uint32
+		senderOptions   []SenderOption
+		receiverOptions []ReceiverOption
 	}
 
 	// queueContent is a specialized Queue body for an Atom entry
@@ -185,9 +187,7 @@ func (q *Queue) SendBatch(ctx context.Context, iterator BatchIterator) error {
 			return err
 		}
 
-		batch, err := iterator.Next(id.String(), &BatchOptions{
-			SessionID: q.sender.sessionID,
-		})
+		batch, err := iterator.Next(id.String(), q.sender.batchOptions(ctx))
 		if err != nil {
 			tab.For(ctx).Error(err)
 			return err
@@ -254,6 +254,7 @@ func (q *Queue) NewReceiver(ctx context.Context, opts ...ReceiverOption) (*Recei
 	defer span.End()
 
 	opts = append(opts, ReceiverWithReceiveMode(q.receiveMode))
+	opts = append(opts, q.receiverOptions...)
 	return q.namespace.NewReceiver(ctx, q.Name, opts...)
 }
 
@@ -262,7 +263,8 @@ func (q *Queue) NewSender(ctx context.Context, opts ...SenderOption) (*Sender, e
 	ctx, span := q.startSpanFromContext(ctx, "sb.Queue.NewSender")
 	defer span.End()
 
-	return q.namespace.NewSender(ctx, q.Name)
+	opts = append(q.senderOptions[:len(q.senderOptions):len(q.senderOptions)], opts...)
+	return q.namespace.NewSender(ctx, q.Name, opts...)
 }
 
 // NewDeadLetter creates an entity that represents the dead letter sub queue of the queue
@@ -363,6 +365,7 @@ func (q *Queue) newReceiver(ctx context.Context, opts ...ReceiverOption) (*Recei
 	defer span.End()
 
 	opts = append(opts, ReceiverWithReceiveMode(q.receiveMode))
+	opts = append(opts, q.receiverOptions...)
 
 	if q.prefetchCount != nil {
 		opts = append(opts, ReceiverWithPrefetchCount(*q.prefetchCount))
@@ -249,7 +249,7 @@ func QueueEntityWithMaxDeliveryCount(count int32) QueueManagementOption {
 // NewQueueManager creates a new QueueManager for a Service Bus Namespace
 func (ns *Namespace) NewQueueManager() *QueueManager {
 	return &QueueManager{
-		entityManager: newEntityManager(ns.getHTTPSHostURI(), ns.TokenProvider),
+		entityManager: newNamespaceEntityManager(ns),
 	}
 }
 
@@ -57,6 +57,10 @@ type (
 		DefaultDisposition DispositionAction
 		Closed             bool
 		cancelAuthRefresh  func() <-chan struct{}
+		payloadStore       PayloadStore
+		keyProvider        KeyProvider
+		processedStore     ProcessedStore
+		idempotencyKey     func(*Message) string
 	}
 
 	// ReceiverOption provides a structure for configuring receivers
@@ -290,11 +294,21 @@ func (r *Receiver) listenForMessage(ctx context.Context, handler amqpHandler) er
 	}
 	receiver = r.receiver
 	r.clientMu.RUnlock()
+
+	if err := r.namespace.throttle.wait(ctx, 1, 0); err != nil {
+		tab.For(ctx).Debug(err.Error())
+		return err
+	}
+
 	msg, err := receiver.Receive(ctx)
 	if err != nil {
+		if isServerBusy(err) {
+			r.namespace.throttle.onServerBusy()
+		}
 		tab.For(ctx).Debug(err.Error())
 		return err
 	}
+	r.namespace.throttle.onSuccess(1)
 	handler.Handle(ctx, msg, r.receiver)
 	return nil
 }
@@ -421,3 +435,39 @@ func (lc *ListenerHandle) Err() error {
 	}
 	return lc.ctx.Err()
 }
+
+// restorePayload undoes the payload transforms applied by the Sender of a message.
+func (r *Receiver) restorePayload(ctx context.Context, m *Message) error {
+	return restorePayload(ctx, m, r.payloadStore, r.keyProvider)
+}
+
+// restorePayload undoes the payload transforms applied by the Sender of a message, in reverse order: the claim-checked
+// payload is downloaded from store, then decrypted and decompressed. A nil store or keyProvider skips its step.
+func restorePayload(ctx context.Context, m *Message, store PayloadStore, keyProvider KeyProvider) error {
+	if store != nil {
+		if err := m.downloadPayload(ctx, store); err != nil {
+			return err
+		}
+	}
+
+	if keyProvider != nil {
+		if err := m.decryptPayload(ctx, keyProvider); err != nil {
+			return err
+		}
+	}
+
+	return m.decompressData()
+}
+
+// settleUnrestorable settles a message received in PeekLockMode whose payload could not be restored: it is
+// dead-lettered if it cannot be decrypted or decompressed, and abandoned otherwise, as the payload may be available on
+// redelivery.
+func settleUnrestorable(ctx context.Context, m *Message, err error) error {
+	switch err.(type) {
+	case ErrDecryptionFailed:
+		return deadLetterWithReason(ctx, m, ErrorDecodeError, deadLetterReasonDecryptionFailed, err)
+	case ErrDecompressionFailed:
+		return deadLetterWithReason(ctx, m, ErrorDecodeError, deadLetterReasonDecompressionFailed, err)
+	}
+	return m.Abandon(ctx)
+}
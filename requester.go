@@ -0,0 +1,349 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+	"github.com/devigned/tab"
+)
+
+type (
+	// Requester sends request messages and waits for their replies. Replies are received from a session-enabled reply
+	// queue, on a session of the Requester's own, and matched to requests by their CorrelationID, which a Responder
+	// sets to the MessageID of the request. A Requester is created with NewRequester and is safe for concurrent use.
+	//
+	// The lock on the session is lost when it is not renewed in time, or when the link of the receiver fails. The
+	// Requester then accepts the session again with a new receiver, so that replies keep being received.
+	Requester struct {
+		replyTo       string
+		sessionID     string
+		timeout       time.Duration
+		reacceptDelay time.Duration
+		pending       map[string]chan *Message
+		closed        bool
+		mu            sync.Mutex
+
+		// send sends requests, and accept accepts the session of the reply queue and listens for replies on it.
+		// Both are replaceable for testing.
+		send     func(context.Context, *Message) error
+		accept   func(context.Context) (*ListenerHandle, error)
+		sender   *Sender
+		listener *ListenerHandle
+		// stopListening stops accepting the session again, and listening is closed once it is stopped
+		stopListening context.CancelFunc
+		listening     chan struct{}
+	}
+
+	// RequesterOption provides a way to customize a Requester
+	RequesterOption func(*Requester) error
+)
+
+const (
+	// DefaultRequestTimeout is how long a Requester waits for a reply by default
+	DefaultRequestTimeout = 30 * time.Second
+
+	// how long a Requester waits before accepting its session again once its listener failed
+	defaultRequesterReacceptDelay = time.Second
+)
+
+var (
+	// ErrRequesterClosed is returned when a request is made through a Requester which was closed, or which was closed
+	// while waiting for the reply
+	ErrRequesterClosed = errors.New("requester is closed")
+)
+
+// RequesterWithTimeout configures how long Request waits for a reply, unless the context of the request is done
+// earlier. The default is DefaultRequestTimeout; a timeout of zero waits for as long as the context allows.
+func RequesterWithTimeout(timeout time.Duration) RequesterOption {
+	return func(r *Requester) error {
+		if timeout < 0 {
+			return fmt.Errorf("timeout must not be negative, but was %v", timeout)
+		}
+		r.timeout = timeout
+		return nil
+	}
+}
+
+// RequesterWithSessionID configures the session of the reply queue the Requester receives its replies on. By default,
+// a new UUID is used, so that each Requester receives only the replies to its own requests.
+func RequesterWithSessionID(sessionID string) RequesterOption {
+	return func(r *Requester) error {
+		if sessionID == "" {
+			return errors.New("session ID must not be empty")
+		}
+		r.sessionID = sessionID
+		return nil
+	}
+}
+
+// NewRequester creates a Requester sending requests through requests, typically a Queue or a Topic, and listening
+// for replies on replyQueue, which must have sessions enabled. The Requester must be closed to release its links.
+func NewRequester(ctx context.Context, requests SenderBuilder, replyQueue *Queue, opts ...RequesterOption) (*Requester, error) {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.NewRequester")
+	defer span.End()
+
+	r, err := newRequester(replyQueue.Name, opts...)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	r.sender, err = requests.NewSender(ctx)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+	r.send = func(ctx context.Context, msg *Message) error {
+		return r.sender.Send(ctx, msg)
+	}
+
+	r.accept = func(ctx context.Context) (*ListenerHandle, error) {
+		receiver, err := replyQueue.NewReceiver(ctx, ReceiverWithSession(&r.sessionID))
+		if err != nil {
+			return nil, err
+		}
+		return receiver.Listen(ctx, HandlerFunc(r.handleReply)), nil
+	}
+
+	// replies are received until the Requester is closed, regardless of ctx
+	if err := r.startListening(context.Background()); err != nil {
+		tab.For(ctx).Error(err)
+		_ = r.sender.Close(ctx)
+		return nil, err
+	}
+	return r, nil
+}
+
+func newRequester(replyTo string, opts ...RequesterOption) (*Requester, error) {
+	r := &Requester{
+		replyTo:       replyTo,
+		timeout:       DefaultRequestTimeout,
+		reacceptDelay: defaultRequesterReacceptDelay,
+		pending:       make(map[string]chan *Message),
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.sessionID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+		r.sessionID = id.String()
+	}
+	return r, nil
+}
+
+// startListening accepts the session of the Requester, and keeps listening for replies on it in the background until
+// the Requester is closed.
+func (r *Requester) startListening(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	listener, err := r.accept(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	r.listener = listener
+	r.stopListening = cancel
+	r.listening = make(chan struct{})
+	go r.keepListening(ctx, listener)
+	return nil
+}
+
+// keepListening accepts the session again each time the listener fails, until ctx is done.
+func (r *Requester) keepListening(ctx context.Context, listener *ListenerHandle) {
+	defer close(r.listening)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-listener.Done():
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		tab.For(ctx).Error(fmt.Errorf("listener of the replies stopped, accepting the session again: %v", listener.Err()))
+		// closing the receiver releases the session, if it is still locked
+		if err := listener.Close(ctx); err != nil {
+			tab.For(ctx).Debug(err.Error())
+		}
+
+		for {
+			timer := time.NewTimer(r.reacceptDelay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			next, err := r.accept(ctx)
+			if err != nil {
+				tab.For(ctx).Error(err)
+				continue
+			}
+
+			listener = next
+			r.mu.Lock()
+			r.listener = listener
+			r.mu.Unlock()
+			break
+		}
+	}
+}
+
+// SessionID returns the session of the reply queue the Requester receives its replies on.
+func (r *Requester) SessionID() string {
+	return r.sessionID
+}
+
+// Request sends msg and blocks until its reply is received, the timeout of the Requester expires, or ctx is done.
+// The ReplyTo and ReplyToGroupID of msg are set to the reply queue and session of the Requester, and its ID to a new
+// UUID if it has none. The ID must be unique among the requests in flight.
+func (r *Requester) Request(ctx context.Context, msg *Message) (*Message, error) {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.Requester.Request")
+	defer span.End()
+
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	if msg.ID == "" {
+		id, err := uuid.NewV4()
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return nil, err
+		}
+		msg.ID = id.String()
+	}
+	msg.ReplyTo = r.replyTo
+	msg.ReplyToGroupID = r.sessionID
+
+	replies, err := r.register(msg.ID)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+	defer r.unregister(msg.ID)
+
+	if err := r.send(ctx, msg); err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-replies:
+		if !ok {
+			return nil, ErrRequesterClosed
+		}
+		return reply, nil
+	case <-ctx.Done():
+		tab.For(ctx).Error(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops listening for replies and closes the links of the Requester. Requests waiting for their reply return
+// ErrRequesterClosed.
+func (r *Requester) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	for id, replies := range r.pending {
+		close(replies)
+		delete(r.pending, id)
+	}
+	r.mu.Unlock()
+
+	var lastErr error
+	if r.stopListening != nil {
+		r.stopListening()
+		select {
+		case <-r.listening:
+			// the listener is no longer replaced once listening is closed
+			if err := r.listener.Close(ctx); err != nil {
+				tab.For(ctx).Error(err)
+				lastErr = err
+			}
+		case <-ctx.Done():
+			tab.For(ctx).Error(ctx.Err())
+			lastErr = ctx.Err()
+		}
+	}
+
+	if r.sender != nil {
+		if err := r.sender.Close(ctx); err != nil {
+			tab.For(ctx).Error(err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (r *Requester) register(id string) (chan *Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, ErrRequesterClosed
+	}
+
+	if _, ok := r.pending[id]; ok {
+		return nil, fmt.Errorf("a request with ID %q is already waiting for its reply", id)
+	}
+
+	replies := make(chan *Message, 1)
+	r.pending[id] = replies
+	return replies, nil
+}
+
+func (r *Requester) unregister(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, id)
+}
+
+// deliver hands reply to the request it correlates to, returning false if no request is waiting for it.
+func (r *Requester) deliver(reply *Message) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	replies, ok := r.pending[reply.CorrelationID]
+	if !ok {
+		return false
+	}
+
+	delete(r.pending, reply.CorrelationID)
+	replies <- reply
+	return true
+}
+
+// handleReply delivers a reply and completes it. Replies no request waits for, such as those arriving after their
+// request timed out, are completed and dropped.
+func (r *Requester) handleReply(ctx context.Context, reply *Message) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.Requester.handleReply")
+	defer span.End()
+
+	if !r.deliver(reply) {
+		tab.For(ctx).Debug(fmt.Sprintf("dropping reply %q, as no request with ID %q is waiting for it", reply.ID, reply.CorrelationID))
+	}
+	return reply.Complete(ctx)
+}
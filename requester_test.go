@@ -0,0 +1,205 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequester_Request(t *testing.T) {
+	r, err := newRequester("replies")
+	require.NoError(t, err)
+	assert.NotEmpty(t, r.SessionID())
+
+	var sent *Message
+	r.send = func(_ context.Context, msg *Message) error {
+		sent = msg
+		go r.deliver(&Message{CorrelationID: msg.ID, Data: []byte("pong")})
+		return nil
+	}
+
+	reply, err := r.Request(context.Background(), NewMessageFromString("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(reply.Data))
+
+	assert.NotEmpty(t, sent.ID)
+	assert.Equal(t, "replies", sent.ReplyTo)
+	assert.Equal(t, r.SessionID(), sent.ReplyToGroupID)
+	assert.Empty(t, r.pending)
+}
+
+func TestRequester_ConcurrentRequests(t *testing.T) {
+	r, err := newRequester("replies")
+	require.NoError(t, err)
+
+	// replies arrive in the reverse order of the requests
+	var mu sync.Mutex
+	var requests []*Message
+	r.send = func(_ context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, msg)
+		if len(requests) == 5 {
+			for i := len(requests) - 1; i >= 0; i-- {
+				r.deliver(&Message{CorrelationID: requests[i].ID, Data: requests[i].Data})
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, body := range []string{"a", "b", "c", "d", "e"} {
+		wg.Add(1)
+		go func(body string) {
+			defer wg.Done()
+			reply, err := r.Request(context.Background(), NewMessageFromString(body))
+			if assert.NoError(t, err) {
+				assert.Equal(t, body, string(reply.Data))
+			}
+		}(body)
+	}
+	wg.Wait()
+}
+
+func TestRequester_Timeout(t *testing.T) {
+	r, err := newRequester("replies", RequesterWithTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	var sent *Message
+	r.send = func(_ context.Context, msg *Message) error {
+		sent = msg
+		return nil
+	}
+
+	_, err = r.Request(context.Background(), NewMessageFromString("ping"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// a late reply is dropped
+	assert.False(t, r.deliver(&Message{CorrelationID: sent.ID}))
+}
+
+func TestRequester_Cancel(t *testing.T) {
+	r, err := newRequester("replies")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.send = func(context.Context, *Message) error {
+		cancel()
+		return nil
+	}
+
+	_, err = r.Request(ctx, NewMessageFromString("ping"))
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRequester_Close(t *testing.T) {
+	r, err := newRequester("replies")
+	require.NoError(t, err)
+
+	sent := make(chan struct{})
+	r.send = func(context.Context, *Message) error {
+		close(sent)
+		return nil
+	}
+
+	go func() {
+		<-sent
+		assert.NoError(t, r.Close(context.Background()))
+	}()
+
+	_, err = r.Request(context.Background(), NewMessageFromString("ping"))
+	assert.Equal(t, ErrRequesterClosed, err)
+
+	_, err = r.Request(context.Background(), NewMessageFromString("ping"))
+	assert.Equal(t, ErrRequesterClosed, err)
+}
+
+func TestRequester_DuplicateID(t *testing.T) {
+	r, err := newRequester("replies")
+	require.NoError(t, err)
+
+	sent := make(chan struct{})
+	r.send = func(context.Context, *Message) error {
+		close(sent)
+		return nil
+	}
+
+	go func() {
+		_, _ = r.Request(context.Background(), &Message{ID: "1"})
+	}()
+	<-sent
+
+	_, err = r.Request(context.Background(), &Message{ID: "1"})
+	assert.Error(t, err)
+	assert.NoError(t, r.Close(context.Background()))
+}
+
+func TestRequester_Options(t *testing.T) {
+	r, err := newRequester("replies", RequesterWithSessionID("mine"), RequesterWithTimeout(0))
+	require.NoError(t, err)
+	assert.Equal(t, "mine", r.SessionID())
+	assert.Equal(t, time.Duration(0), r.timeout)
+
+	_, err = newRequester("replies", RequesterWithSessionID(""))
+	assert.Error(t, err)
+	_, err = newRequester("replies", RequesterWithTimeout(-time.Second))
+	assert.Error(t, err)
+}
+
+// newTestListenerHandle returns a ListenerHandle without a link, and a func making it fail with err
+func newTestListenerHandle(ctx context.Context) (*ListenerHandle, func(err error)) {
+	ctx, cancel := context.WithCancel(ctx)
+	receiver := &Receiver{doneListening: cancel}
+	return &ListenerHandle{r: receiver, ctx: ctx}, func(err error) {
+		receiver.setLastError(err)
+		cancel()
+	}
+}
+
+func TestRequester_ReacceptsSession(t *testing.T) {
+	r, err := newRequester("replies", RequesterWithTimeout(time.Second))
+	require.NoError(t, err)
+	r.reacceptDelay = time.Millisecond
+
+	accepted := make(chan func(error), 3)
+	attempts := 0
+	r.accept = func(ctx context.Context) (*ListenerHandle, error) {
+		attempts++
+		if attempts == 2 {
+			return nil, errors.New("session is still locked")
+		}
+		listener, fail := newTestListenerHandle(ctx)
+		accepted <- fail
+		return listener, nil
+	}
+	require.NoError(t, r.startListening(context.Background()))
+
+	// the lock on the session is lost, so the listener fails
+	fail := <-accepted
+	fail(errors.New("session lock lost"))
+
+	// replies are delivered by the listener accepting the session again
+	r.send = func(_ context.Context, msg *Message) error {
+		go func() {
+			<-accepted
+			r.deliver(&Message{CorrelationID: msg.ID, Data: []byte("pong")})
+		}()
+		return nil
+	}
+	reply, err := r.Request(context.Background(), NewMessageFromString("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(reply.Data))
+	assert.Equal(t, 3, attempts)
+
+	require.NoError(t, r.Close(context.Background()))
+	select {
+	case <-r.listening:
+	default:
+		t.Fatal("the Requester is still listening once closed")
+	}
+}
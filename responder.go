@@ -0,0 +1,148 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/devigned/tab"
+)
+
+type (
+	// ReplyHandlerFunc handles a request message, returning the reply to send back to the requester, or nil to send
+	// no reply.
+	ReplyHandlerFunc func(ctx context.Context, request *Message) (*Message, error)
+
+	// Responder is a Handler answering requests made through a Requester. The reply returned by its ReplyHandlerFunc is
+	// sent to the entity named by the ReplyTo of the request, on the session named by its ReplyToGroupID, with the
+	// request's ID as its CorrelationID. The request is completed once the reply is sent.
+	//
+	// Requests without a ReplyTo are dead-lettered with a DeadLetterReason of "MissingReplyTo", and requests whose reply
+	// cannot be sent are abandoned, to be handled again. An error from the ReplyHandlerFunc is returned as is, which
+	// stops the Receiver as any Handler error would.
+	Responder struct {
+		ns      *Namespace
+		handler ReplyHandlerFunc
+		senders map[string]*Sender
+		mu      sync.Mutex
+
+		// sendReply sends a reply to an entity, and is replaceable for testing
+		sendReply func(ctx context.Context, replyTo string, reply *Message) error
+		// newSender attaches a Sender to an entity, and is replaceable for testing
+		newSender func(ctx context.Context, replyTo string) (*Sender, error)
+	}
+)
+
+const (
+	// Dead-letter reason of requests which have no ReplyTo
+	deadLetterReasonMissingReplyTo = "MissingReplyTo"
+)
+
+// NewResponder creates a Responder sending the replies of handler through ns. The Responder must be closed to release
+// the links it sends replies with.
+func NewResponder(ns *Namespace, handler ReplyHandlerFunc) *Responder {
+	r := &Responder{
+		ns:      ns,
+		handler: handler,
+		senders: make(map[string]*Sender),
+	}
+	r.sendReply = r.sendThroughNamespace
+	r.newSender = func(ctx context.Context, replyTo string) (*Sender, error) {
+		return r.ns.NewSender(ctx, replyTo)
+	}
+	return r
+}
+
+// Handle handles a request and sends its reply back.
+func (r *Responder) Handle(ctx context.Context, request *Message) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.Responder.Handle")
+	defer span.End()
+
+	if request.ReplyTo == "" {
+		err := errors.New("request has no ReplyTo to send the reply to")
+		return deadLetterWithReason(ctx, request, ErrorPreconditionFailed, deadLetterReasonMissingReplyTo, err)
+	}
+
+	reply, err := r.handler(ctx, request)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+
+	if err := r.respond(ctx, request, reply); err != nil {
+		tab.For(ctx).Error(err)
+		return request.Abandon(ctx)
+	}
+	return request.Complete(ctx)
+}
+
+// Close closes the links replies were sent with, returning the last error encountered.
+func (r *Responder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var lastErr error
+	for replyTo, sender := range r.senders {
+		if err := sender.Close(ctx); err != nil {
+			tab.For(ctx).Error(err)
+			lastErr = err
+		}
+		delete(r.senders, replyTo)
+	}
+	return lastErr
+}
+
+// respond correlates reply to request and sends it to the ReplyTo of the request. A nil reply is not sent.
+func (r *Responder) respond(ctx context.Context, request, reply *Message) error {
+	if reply == nil {
+		return nil
+	}
+
+	reply.CorrelationID = request.ID
+	if request.ReplyToGroupID != "" {
+		sessionID := request.ReplyToGroupID
+		reply.SessionID = &sessionID
+	}
+	return r.sendReply(ctx, request.ReplyTo, reply)
+}
+
+// sendThroughNamespace sends reply with a Sender to replyTo, created on first use and kept until the Responder is
+// closed.
+func (r *Responder) sendThroughNamespace(ctx context.Context, replyTo string, reply *Message) error {
+	sender, err := r.replySender(ctx, replyTo)
+	if err != nil {
+		return err
+	}
+	return sender.Send(ctx, reply)
+}
+
+// replySender returns the Sender to replyTo. The link is attached without holding the lock, so a slow entity does not
+// hold up the replies to other ones, and the first Sender stored wins if the same entity is attached concurrently.
+func (r *Responder) replySender(ctx context.Context, replyTo string) (*Sender, error) {
+	r.mu.Lock()
+	sender, ok := r.senders[replyTo]
+	r.mu.Unlock()
+	if ok {
+		return sender, nil
+	}
+
+	sender, err := r.newSender(ctx, replyTo)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	existing, ok := r.senders[replyTo]
+	if !ok {
+		r.senders[replyTo] = sender
+	}
+	r.mu.Unlock()
+
+	if ok {
+		if err := sender.Close(ctx); err != nil {
+			tab.For(ctx).Error(err)
+		}
+		return existing, nil
+	}
+	return sender, nil
+}
@@ -0,0 +1,110 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponder_Respond(t *testing.T) {
+	var sentTo string
+	var sent *Message
+	r := NewResponder(nil, nil)
+	r.sendReply = func(_ context.Context, replyTo string, reply *Message) error {
+		sentTo, sent = replyTo, reply
+		return nil
+	}
+
+	request := &Message{ID: "request", ReplyTo: "replies", ReplyToGroupID: "session"}
+	require.NoError(t, r.respond(context.Background(), request, NewMessageFromString("reply")))
+
+	assert.Equal(t, "replies", sentTo)
+	assert.Equal(t, "request", sent.CorrelationID)
+	require.NotNil(t, sent.SessionID)
+	assert.Equal(t, "session", *sent.SessionID)
+
+	// no reply is sent for a nil reply
+	sent = nil
+	require.NoError(t, r.respond(context.Background(), request, nil))
+	assert.Nil(t, sent)
+
+	r.sendReply = func(context.Context, string, *Message) error {
+		return errors.New("send failed")
+	}
+	assert.Error(t, r.respond(context.Background(), request, NewMessageFromString("reply")))
+}
+
+func TestResponder_RoundTrip(t *testing.T) {
+	requester, err := newRequester("replies")
+	require.NoError(t, err)
+
+	responder := NewResponder(nil, func(_ context.Context, request *Message) (*Message, error) {
+		return NewMessageFromString(strings.ToUpper(string(request.Data))), nil
+	})
+	responder.sendReply = func(_ context.Context, replyTo string, reply *Message) error {
+		assert.Equal(t, "replies", replyTo)
+		assert.Equal(t, requester.SessionID(), *reply.SessionID)
+		requester.deliver(reply)
+		return nil
+	}
+
+	requester.send = func(ctx context.Context, request *Message) error {
+		reply, err := responder.handler(ctx, request)
+		if err != nil {
+			return err
+		}
+		return responder.respond(ctx, request, reply)
+	}
+
+	reply, err := requester.Request(context.Background(), NewMessageFromString("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, "PING", string(reply.Data))
+}
+
+func TestResponder_ReplySender(t *testing.T) {
+	r := NewResponder(nil, nil)
+
+	// the attach to "slow" blocks until released, without holding up the attach to "fast"
+	release := make(chan struct{})
+	attached := make(chan struct{}, 2)
+	r.newSender = func(_ context.Context, replyTo string) (*Sender, error) {
+		if replyTo == "slow" {
+			attached <- struct{}{}
+			<-release
+		}
+		return &Sender{namespace: new(Namespace), Name: replyTo}, nil
+	}
+
+	senders := make(chan *Sender, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			sender, err := r.replySender(context.Background(), "slow")
+			assert.NoError(t, err)
+			senders <- sender
+		}()
+	}
+	<-attached
+	<-attached
+
+	fast, err := r.replySender(context.Background(), "fast")
+	require.NoError(t, err)
+	assert.Equal(t, "fast", fast.Name)
+
+	// both racing attaches get the first Sender stored
+	close(release)
+	first, second := <-senders, <-senders
+	assert.Same(t, first, second)
+	assert.Same(t, first, r.senders["slow"])
+	assert.Len(t, r.senders, 2)
+
+	r.newSender = func(context.Context, string) (*Sender, error) {
+		return nil, errors.New("attach failed")
+	}
+	_, err = r.replySender(context.Background(), "other")
+	assert.Error(t, err)
+	assert.Len(t, r.senders, 2)
+}
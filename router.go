@@ -0,0 +1,263 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/devigned/tab"
+)
+
+type (
+	// MessagePredicate reports whether a message matches a route of a Router
+	MessagePredicate func(*Message) bool
+
+	// Router is a Handler dispatching each message to the handler of the first route it matches, in the order the
+	// routes were added. Messages which match no route are handed to the fallback handler if one is configured, or
+	// else settled with the unrouted disposition of the Router. Routes can be added while messages are handled.
+	Router struct {
+		routes           []messageRoute
+		routesMu         sync.RWMutex
+		fallback         Handler
+		unrouted         UnroutedDisposition
+		deadLetterReason string
+	}
+
+	// RouterOption provides a way to customize a Router
+	RouterOption func(*Router) error
+
+	// UnroutedDisposition is the way a Router settles the messages which match no route when it has no fallback
+	UnroutedDisposition int
+
+	messageRoute struct {
+		match   MessagePredicate
+		handler Handler
+	}
+)
+
+const (
+	// UnroutedDeadLetter dead-letters unrouted messages, with the dead-letter reason of the Router
+	UnroutedDeadLetter UnroutedDisposition = iota
+	// UnroutedAbandon abandons unrouted messages, so that they are redelivered, to another receiver of the entity for
+	// instance
+	UnroutedAbandon
+	// UnroutedComplete completes unrouted messages, discarding them
+	UnroutedComplete
+	// UnroutedError returns an error for unrouted messages, which stops the Receiver as any Handler error would
+	UnroutedError
+
+	// Dead-letter reason of messages which match no route by default
+	deadLetterReasonNoRoute = "NoRoute"
+)
+
+// RouterWithFallback configures the handler of the messages which match no route.
+func RouterWithFallback(handler Handler) RouterOption {
+	return func(r *Router) error {
+		if handler == nil {
+			return errors.New("fallback handler must not be nil")
+		}
+
+		r.fallback = handler
+		return nil
+	}
+}
+
+// RouterWithUnroutedDisposition configures how the messages which match no route are settled when the Router has no
+// fallback. The default is UnroutedDeadLetter.
+func RouterWithUnroutedDisposition(disposition UnroutedDisposition) RouterOption {
+	return func(r *Router) error {
+		if disposition < UnroutedDeadLetter || disposition > UnroutedError {
+			return fmt.Errorf("unknown unrouted disposition %d", disposition)
+		}
+
+		r.unrouted = disposition
+		return nil
+	}
+}
+
+// RouterWithDeadLetterReason configures the DeadLetterReason of the messages dead-lettered because they match no
+// route. The default is "NoRoute".
+func RouterWithDeadLetterReason(reason string) RouterOption {
+	return func(r *Router) error {
+		if reason == "" {
+			return errors.New("dead-letter reason must not be empty")
+		}
+
+		r.deadLetterReason = reason
+		return nil
+	}
+}
+
+// NewRouter creates a Router without any route.
+func NewRouter(opts ...RouterOption) (*Router, error) {
+	r := &Router{
+		unrouted:         UnroutedDeadLetter,
+		deadLetterReason: deadLetterReasonNoRoute,
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Route adds a route handing the messages which match to handler, unless they match a route added before. A message
+// matches when it matches every predicate; a route without predicates matches every message.
+func (r *Router) Route(handler Handler, match ...MessagePredicate) error {
+	if handler == nil {
+		return errors.New("handler must not be nil")
+	}
+
+	for _, m := range match {
+		if m == nil {
+			return errors.New("predicate must not be nil")
+		}
+	}
+
+	r.routesMu.Lock()
+	defer r.routesMu.Unlock()
+
+	r.routes = append(r.routes, messageRoute{
+		match:   MatchAll(match...),
+		handler: handler,
+	})
+	return nil
+}
+
+// Handle dispatches msg to the handler of the first route it matches.
+func (r *Router) Handle(ctx context.Context, msg *Message) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.Router.Handle")
+	defer span.End()
+
+	if handler := r.handlerFor(msg); handler != nil {
+		return handler.Handle(ctx, msg)
+	}
+
+	if r.fallback != nil {
+		return r.fallback.Handle(ctx, msg)
+	}
+
+	err := fmt.Errorf("no route matches message %q with label %q", msg.ID, msg.Label)
+	switch r.unrouted {
+	case UnroutedAbandon:
+		tab.For(ctx).Debug(err.Error())
+		return msg.Abandon(ctx)
+	case UnroutedComplete:
+		tab.For(ctx).Debug(err.Error())
+		return msg.Complete(ctx)
+	case UnroutedError:
+		tab.For(ctx).Error(err)
+		return err
+	default:
+		return deadLetterWithReason(ctx, msg, ErrorNotImplemented, r.deadLetterReason, err)
+	}
+}
+
+// handlerFor returns the handler of the first route msg matches, or nil.
+func (r *Router) handlerFor(msg *Message) Handler {
+	r.routesMu.RLock()
+	defer r.routesMu.RUnlock()
+
+	for _, route := range r.routes {
+		if route.match(msg) {
+			return route.handler
+		}
+	}
+	return nil
+}
+
+// MatchAll matches the messages which match every predicate, or every message if there are no predicates.
+func MatchAll(predicates ...MessagePredicate) MessagePredicate {
+	return func(msg *Message) bool {
+		for _, p := range predicates {
+			if !p(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny matches the messages which match any of the predicates.
+func MatchAny(predicates ...MessagePredicate) MessagePredicate {
+	return func(msg *Message) bool {
+		for _, p := range predicates {
+			if p(msg) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchLabel matches the messages whose Label is exactly label.
+func MatchLabel(label string) MessagePredicate {
+	return func(msg *Message) bool {
+		return msg.Label == label
+	}
+}
+
+// MatchLabelGlob matches the messages whose Label matches pattern, in which * stands for any sequence of characters
+// and ? for any single character. Matching is case-sensitive.
+func MatchLabelGlob(pattern string) MessagePredicate {
+	return func(msg *Message) bool {
+		return globMatch(pattern, msg.Label)
+	}
+}
+
+// MatchContentType matches the messages whose ContentType has the media type of contentType, ignoring parameters
+// such as the charset, and case.
+func MatchContentType(contentType string) MessagePredicate {
+	mediaType := normalizeContentType(contentType)
+	return func(msg *Message) bool {
+		return normalizeContentType(msg.ContentType) == mediaType
+	}
+}
+
+// MatchUserProperty matches the messages with a user property key equal to value. Numbers are compared by value,
+// whatever their type.
+func MatchUserProperty(key string, value interface{}) MessagePredicate {
+	return func(msg *Message) bool {
+		actual, ok := msg.UserProperties[key]
+		return ok && propertyValuesEqual(value, actual)
+	}
+}
+
+// MatchCorrelation matches the messages which filter matches, as a subscription rule with filter would.
+func MatchCorrelation(filter CorrelationFilter) MessagePredicate {
+	return filter.Match
+}
+
+// globMatch reports whether s matches pattern, in which * stands for any sequence of characters and ? for any single
+// character.
+func globMatch(pattern, s string) bool {
+	p, str := []rune(pattern), []rune(s)
+	// position of the last * in the pattern, and of the character of s it currently stands up to
+	star, match := -1, 0
+
+	i, j := 0, 0
+	for j < len(str) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == str[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, match = i, j
+			i++
+		case star >= 0:
+			// let the last * stand for one more character
+			match++
+			i, j = star+1, match
+		default:
+			return false
+		}
+	}
+
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
@@ -0,0 +1,133 @@
+package servicebus
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingHandler struct {
+	name    string
+	handled *[]string
+}
+
+func (h recordingHandler) Handle(_ context.Context, _ *Message) error {
+	*h.handled = append(*h.handled, h.name)
+	return nil
+}
+
+func TestRouter_FirstMatchingRoute(t *testing.T) {
+	ctx := context.Background()
+	var handled []string
+	handler := func(name string) Handler {
+		return recordingHandler{name: name, handled: &handled}
+	}
+
+	router, err := NewRouter(RouterWithFallback(handler("fallback")))
+	require.NoError(t, err)
+	require.NoError(t, router.Route(handler("created"), MatchLabel("order.created")))
+	require.NoError(t, router.Route(handler("order"), MatchLabelGlob("order.*")))
+	require.NoError(t, router.Route(handler("json"), MatchContentType("application/json")))
+	require.NoError(t, router.Route(handler("eu"), MatchUserProperty("region", "eu"), MatchUserProperty("priority", 1)))
+	require.NoError(t, router.Route(handler("reply"), MatchCorrelation(CorrelationFilter{ReplyTo: ptrString("replies")})))
+
+	messages := []*Message{
+		{Label: "order.created"},
+		{Label: "order.shipped"},
+		{Label: "invoice", ContentType: "Application/JSON; charset=utf-8"},
+		{UserProperties: map[string]interface{}{"region": "eu", "priority": int64(1)}},
+		{UserProperties: map[string]interface{}{"region": "eu", "priority": int64(2)}, ReplyTo: "replies"},
+		{Label: "invoice"},
+	}
+	for _, msg := range messages {
+		require.NoError(t, router.Handle(ctx, msg))
+	}
+
+	assert.Equal(t, []string{"created", "order", "json", "eu", "reply", "fallback"}, handled)
+}
+
+func TestRouter_Unrouted(t *testing.T) {
+	router, err := NewRouter(RouterWithUnroutedDisposition(UnroutedError))
+	require.NoError(t, err)
+	assert.Error(t, router.Handle(context.Background(), &Message{Label: "foo"}))
+
+	_, err = NewRouter(RouterWithUnroutedDisposition(UnroutedDisposition(42)))
+	assert.Error(t, err)
+	_, err = NewRouter(RouterWithDeadLetterReason(""))
+	assert.Error(t, err)
+	_, err = NewRouter(RouterWithFallback(nil))
+	assert.Error(t, err)
+
+	assert.Error(t, router.Route(nil))
+	assert.Error(t, router.Route(HandlerFunc(func(context.Context, *Message) error { return nil }), nil))
+}
+
+func TestMatchAny(t *testing.T) {
+	match := MatchAny(MatchLabel("a"), MatchLabel("b"))
+	assert.True(t, match(&Message{Label: "a"}))
+	assert.True(t, match(&Message{Label: "b"}))
+	assert.False(t, match(&Message{Label: "c"}))
+	assert.False(t, MatchAny()(&Message{}))
+	assert.True(t, MatchAll()(&Message{}))
+}
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"*", "anything/at.all", true},
+		{"order.*", "order.created", true},
+		{"order.*", "order.", true},
+		{"order.*", "orders.created", false},
+		{"*.created", "order.created", true},
+		{"*.created", "order.created.late", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"Order.*", "order.created", false},
+		{"*é", "café", true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.match, globMatch(c.pattern, c.s), "%q against %q", c.s, c.pattern)
+	}
+}
+
+func TestCorrelationFilter_Match(t *testing.T) {
+	msg := &Message{
+		ID:             "id",
+		CorrelationID:  "correlation",
+		Label:          "label",
+		To:             "to",
+		ReplyTo:        "replyTo",
+		ReplyToGroupID: "replyToSession",
+		SessionID:      ptrString("session"),
+		ContentType:    "application/json",
+		UserProperties: map[string]interface{}{"count": int32(3), "name": "foo"},
+	}
+
+	assert.True(t, CorrelationFilter{}.Match(msg))
+	assert.True(t, CorrelationFilter{
+		CorrelationID:    ptrString("correlation"),
+		MessageID:        ptrString("id"),
+		To:               ptrString("to"),
+		ReplyTo:          ptrString("replyTo"),
+		Label:            ptrString("label"),
+		SessionID:        ptrString("session"),
+		ReplyToSessionID: ptrString("replyToSession"),
+		ContentType:      ptrString("application/json"),
+		Properties:       map[string]interface{}{"count": 3, "name": "foo"},
+	}.Match(msg))
+
+	assert.False(t, CorrelationFilter{Label: ptrString("LABEL")}.Match(msg))
+	assert.False(t, CorrelationFilter{SessionID: ptrString("session")}.Match(&Message{}))
+	assert.False(t, CorrelationFilter{Properties: map[string]interface{}{"count": 4}}.Match(msg))
+	assert.False(t, CorrelationFilter{Properties: map[string]interface{}{"missing": "foo"}}.Match(msg))
+	assert.False(t, CorrelationFilter{Properties: map[string]interface{}{"name": 3}}.Match(msg))
+}
@@ -0,0 +1,205 @@
+package servicebus
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/devigned/tab"
+)
+
+type (
+	// RoutingPreview describes the subscriptions of a topic a message would be selected into, as computed by
+	// Topic.PreviewRouting.
+	RoutingPreview struct {
+		// Subscriptions lists the subscriptions with at least one rule matching the message
+		Subscriptions []*SubscriptionRoutingPreview
+		// Errors lists the rules whose filter or action could not be evaluated locally
+		Errors []*RuleEvaluationError
+		// Unmatched is true when the message matches no rule of any subscription, so the topic would drop it
+		Unmatched bool
+	}
+
+	// SubscriptionRoutingPreview describes the copies of a message selected into a subscription, one per matching rule.
+	SubscriptionRoutingPreview struct {
+		Name  string
+		Rules []*RuleMatch
+	}
+
+	// RuleMatch describes a rule matching a message, and the copy of the message its action produces.
+	RuleMatch struct {
+		Name string
+		// Modified holds the properties the action of the rule sets to a new value, by name; system properties are
+		// prefixed with "sys.", as in "sys.Label"
+		Modified map[string]interface{}
+		// Removed lists the properties the action of the rule removes, or sets to NULL
+		Removed []string
+		// Message is the copy of the message selected into the subscription, once the action is applied
+		Message *Message
+	}
+
+	// RuleEvaluationError is a rule whose filter or action could not be evaluated locally, such as a filter dividing by
+	// zero. Service Bus does not select the message through such a rule, and dead-letters it if the subscription is
+	// configured to dead-letter on filter evaluation exceptions.
+	RuleEvaluationError struct {
+		Subscription string
+		Rule         string
+		Err          error
+	}
+)
+
+const (
+	// number of subscriptions listed per request when previewing routing
+	routingPreviewPageSize = 100
+)
+
+var (
+	// system properties a rule action can set, as named in SQL expressions
+	settableSystemProperties = []string{
+		"MessageId", "CorrelationId", "To", "ReplyTo", "Label", "SessionId", "ReplyToSessionId", "ContentType",
+	}
+)
+
+func (e *RuleEvaluationError) Error() string {
+	return "rule " + e.Rule + " of subscription " + e.Subscription + ": " + e.Err.Error()
+}
+
+func (e *RuleEvaluationError) Unwrap() error {
+	return e.Err
+}
+
+// PreviewRouting evaluates the rules of every subscription of the topic against msg locally, to find the subscriptions
+// which would receive it and how the action of each matching rule would modify it. Subscriptions are listed with
+// SubscriptionManager.List and rules with SubscriptionManager.ListRules; nothing is sent. msg is left unmodified.
+//
+// True, false, SQL and correlation filters are evaluated; see SQLFilter.Match for the SQL grammar supported. Rules
+// which cannot be evaluated are reported in the Errors of the preview rather than failing it.
+func (t *Topic) PreviewRouting(ctx context.Context, msg *Message) (*RoutingPreview, error) {
+	ctx, span := t.startSpanFromContext(ctx, "sb.Topic.PreviewRouting")
+	defer span.End()
+
+	sm := t.NewSubscriptionManager()
+	preview := new(RoutingPreview)
+	for skip := 0; ; skip += routingPreviewPageSize {
+		subs, err := sm.List(ctx, ListSubscriptionsWithSkip(skip), ListSubscriptionsWithTop(routingPreviewPageSize))
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return nil, err
+		}
+
+		for _, sub := range subs {
+			rules, err := sm.ListRules(ctx, sub.Name)
+			if err != nil {
+				tab.For(ctx).Error(err)
+				return nil, err
+			}
+			preview.addSubscription(sub.Name, rules, msg)
+		}
+
+		if len(subs) < routingPreviewPageSize {
+			break
+		}
+	}
+
+	preview.Unmatched = len(preview.Subscriptions) == 0
+	return preview, nil
+}
+
+// addSubscription evaluates the rules of a subscription against msg, adding the subscription to the preview if any
+// rule matches.
+func (p *RoutingPreview) addSubscription(name string, rules []*RuleEntity, msg *Message) {
+	sub := &SubscriptionRoutingPreview{Name: name}
+	for _, rule := range rules {
+		match, err := previewRule(rule, msg)
+		if err != nil {
+			p.Errors = append(p.Errors, &RuleEvaluationError{Subscription: name, Rule: rule.Name, Err: err})
+			continue
+		}
+
+		if match != nil {
+			sub.Rules = append(sub.Rules, match)
+		}
+	}
+
+	if len(sub.Rules) > 0 {
+		p.Subscriptions = append(p.Subscriptions, sub)
+	}
+}
+
+// previewRule returns how rule selects msg, or nil if it does not match.
+func previewRule(rule *RuleEntity, msg *Message) (*RuleMatch, error) {
+	matched, err := rule.Filter.Match(msg)
+	if err != nil || !matched {
+		return nil, err
+	}
+
+	selected := previewCopy(msg)
+	if rule.Action != nil {
+		if err := rule.Action.Apply(selected); err != nil {
+			return nil, err
+		}
+	}
+
+	match := &RuleMatch{
+		Name:     rule.Name,
+		Modified: make(map[string]interface{}),
+		Message:  selected,
+	}
+	match.diff(msg, selected)
+	return match, nil
+}
+
+// diff records the properties which differ between the original message and the copy selected by the rule.
+func (m *RuleMatch) diff(original, selected *Message) {
+	for _, name := range settableSystemProperties {
+		get := sqlSystemProperties[strings.ToLower(name)].get
+		before, after := get(original), get(selected)
+		m.record("sys."+name, before, after, before != nil, after != nil)
+	}
+
+	for key, after := range selected.UserProperties {
+		before, existed := original.UserProperties[key]
+		m.record(key, before, after, existed, true)
+	}
+
+	for key := range original.UserProperties {
+		if _, ok := selected.UserProperties[key]; !ok {
+			m.Removed = append(m.Removed, key)
+		}
+	}
+	sort.Strings(m.Removed)
+}
+
+func (m *RuleMatch) record(name string, before, after interface{}, existed, exists bool) {
+	switch {
+	case !exists || after == nil:
+		if existed && before != nil {
+			m.Removed = append(m.Removed, name)
+		}
+	case !existed || !reflect.DeepEqual(sqlValue(before), sqlValue(after)):
+		m.Modified[name] = after
+	}
+}
+
+// previewCopy copies the message for a rule action to modify, keeping the system properties filters may refer to.
+// The copy is not tied to the receiver of msg, so it cannot be settled.
+func previewCopy(msg *Message) *Message {
+	selected := *msg
+	selected.LockToken = nil
+	selected.message = nil
+	selected.ec = nil
+	selected.receiver = nil
+	if msg.SessionID != nil {
+		sessionID := *msg.SessionID
+		selected.SessionID = &sessionID
+	}
+
+	if msg.UserProperties != nil {
+		selected.UserProperties = make(map[string]interface{}, len(msg.UserProperties))
+		for key, val := range msg.UserProperties {
+			selected.UserProperties[key] = val
+		}
+	}
+	return &selected
+}
@@ -0,0 +1,76 @@
+package servicebus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRule(name string, filter FilterDescriber, action ActionDescriber) *RuleEntity {
+	rd := &RuleDescription{Filter: filter.ToFilterDescription()}
+	if action != nil {
+		ad := action.ToActionDescription()
+		rd.Action = &ad
+	}
+	return &RuleEntity{RuleDescription: rd, Entity: &Entity{Name: name}}
+}
+
+func TestRoutingPreview_AddSubscription(t *testing.T) {
+	msg := &Message{
+		Label:          "order",
+		UserProperties: map[string]interface{}{"region": "eu", "quantity": 3, "internal": true},
+	}
+
+	preview := new(RoutingPreview)
+	preview.addSubscription("all", []*RuleEntity{testRule("$Default", TrueFilter{}, nil)}, msg)
+	preview.addSubscription("eu", []*RuleEntity{
+		testRule("eu", SQLFilter{Expression: "region = 'eu'"},
+			&SQLAction{Expression: "SET sys.Label = 'eu.' + sys.Label; SET quantity = 3; SET total = quantity * 2; REMOVE internal"}),
+		testRule("us", SQLFilter{Expression: "region = 'us'"}, nil),
+		testRule("orders", CorrelationFilter{Label: ptrString("order")}, nil),
+	}, msg)
+	preview.addSubscription("none", []*RuleEntity{testRule("never", FalseFilter{}, nil)}, msg)
+	preview.addSubscription("broken", []*RuleEntity{
+		testRule("division", SQLFilter{Expression: "quantity / 0 = 1"}, nil),
+		testRule("syntax", SQLFilter{Expression: "quantity ="}, nil),
+	}, msg)
+
+	require.Len(t, preview.Subscriptions, 2)
+	assert.Equal(t, "all", preview.Subscriptions[0].Name)
+	require.Len(t, preview.Subscriptions[0].Rules, 1)
+	assert.Empty(t, preview.Subscriptions[0].Rules[0].Modified)
+	assert.Empty(t, preview.Subscriptions[0].Rules[0].Removed)
+
+	eu := preview.Subscriptions[1]
+	assert.Equal(t, "eu", eu.Name)
+	require.Len(t, eu.Rules, 2)
+	assert.Equal(t, "eu", eu.Rules[0].Name)
+	assert.Equal(t, map[string]interface{}{"sys.Label": "eu.order", "total": int64(6)}, eu.Rules[0].Modified)
+	assert.Equal(t, []string{"internal"}, eu.Rules[0].Removed)
+	assert.Equal(t, "eu.order", eu.Rules[0].Message.Label)
+	assert.Equal(t, "orders", eu.Rules[1].Name)
+
+	// the original message is left unmodified
+	assert.Equal(t, "order", msg.Label)
+	assert.Equal(t, map[string]interface{}{"region": "eu", "quantity": 3, "internal": true}, msg.UserProperties)
+
+	require.Len(t, preview.Errors, 2)
+	assert.Equal(t, "broken", preview.Errors[0].Subscription)
+	assert.Equal(t, "division", preview.Errors[0].Rule)
+	var syntaxErr ErrSQLSyntax
+	assert.True(t, errors.As(preview.Errors[1], &syntaxErr))
+}
+
+func TestRoutingPreview_RemovedSystemProperty(t *testing.T) {
+	sessionID := "s1"
+	msg := &Message{SessionID: &sessionID, ReplyTo: "replies"}
+
+	match, err := previewRule(testRule("clear", TrueFilter{}, &SQLAction{Expression: "SET sys.SessionId = NULL; SET sys.ReplyTo = 'other'"}), msg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sys.SessionId"}, match.Removed)
+	assert.Equal(t, map[string]interface{}{"sys.ReplyTo": "other"}, match.Modified)
+	assert.Nil(t, match.Message.SessionID)
+	assert.Equal(t, "s1", *msg.SessionID)
+}
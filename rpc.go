@@ -201,7 +201,12 @@ func (r *rpcClient) doRPCWithRetry(ctx context.Context, address string, msg *amq
 	// this is to avoid a potential infinite loop if the returned error
 	// is always transient and Recover() doesn't fail.
 	sendCount := 0
+	throttle := r.ec.Namespace().throttle
 	for {
+		if err := throttle.wait(ctx, 1, 0); err != nil {
+			return nil, err
+		}
+
 		r.clientMu.RLock()
 		client := r.client
 		r.clientMu.RUnlock()
@@ -233,10 +238,15 @@ func (r *rpcClient) doRPCWithRetry(ctx context.Context, address string, msg *amq
 			}()
 			rsp, err = link.RetryableRPC(ctx, times, delay, msg)
 			if err == nil {
+				throttle.onSuccess(1)
 				return rsp, nil
 			}
 		}
 
+		if isServerBusy(err) {
+			throttle.onServerBusy()
+		}
+
 		if sendCount >= amqpRetryDefaultTimes || !isAMQPTransientError(ctx, err) {
 			return nil, err
 		}
@@ -390,20 +400,28 @@ func (r *rpcClient) ReceiveDeferred(ctx context.Context, mode ReceiveMode, seque
 	return transformedMessages, nil
 }
 
-func (r *rpcClient) GetNextPage(ctx context.Context, fromSequenceNumber int64, messageCount int32) ([]*Message, error) {
+// GetNextPage peeks at up to messageCount messages starting at fromSequenceNumber. If sessionID is not nil, only
+// messages belonging to that session are returned. The session is not locked by peeking.
+func (r *rpcClient) GetNextPage(ctx context.Context, fromSequenceNumber int64, messageCount int32, sessionID *string) ([]*Message, error) {
 	ctx, span := startConsumerSpanFromContext(ctx, "sb.rpcClient.GetNextPage")
 	defer span.End()
 
 	const messagesField, messageField = "messages", "message"
 
+	values := map[string]interface{}{
+		"from-sequence-number": fromSequenceNumber,
+		"message-count":        messageCount,
+	}
+
+	if sessionID != nil {
+		values["session-id"] = *sessionID
+	}
+
 	msg := &amqp.Message{
 		ApplicationProperties: map[string]interface{}{
 			operationFieldName: peekMessageOperationID,
 		},
-		Value: map[string]interface{}{
-			"from-sequence-number": fromSequenceNumber,
-			"message-count":        messageCount,
-		},
+		Value: values,
 	}
 
 	if deadline, ok := ctx.Deadline(); ok {
@@ -714,6 +732,135 @@ func (r *rpcClient) CancelScheduled(ctx context.Context, seq ...int64) error {
 	return nil
 }
 
+// AddRule adds a rule to the subscription the client manages, selecting the messages filter matches and applying
+// action to them. action may be nil.
+func (r *rpcClient) AddRule(ctx context.Context, name string, filter FilterDescription, action *ActionDescription) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.rpcClient.AddRule")
+	defer span.End()
+
+	rd, err := ruleDescriptionToAMQP(name, filter, action)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+
+	msg := &amqp.Message{
+		ApplicationProperties: map[string]interface{}{
+			operationFieldName: addRuleOperationID,
+		},
+		Value: map[string]interface{}{
+			ruleNameFieldName:        name,
+			ruleDescriptionFieldName: rd,
+		},
+	}
+
+	return r.doRuleRPC(ctx, msg)
+}
+
+// RemoveRule removes the rule with name from the subscription the client manages.
+func (r *rpcClient) RemoveRule(ctx context.Context, name string) error {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.rpcClient.RemoveRule")
+	defer span.End()
+
+	msg := &amqp.Message{
+		ApplicationProperties: map[string]interface{}{
+			operationFieldName: removeRuleOperationID,
+		},
+		Value: map[string]interface{}{
+			ruleNameFieldName: name,
+		},
+	}
+
+	return r.doRuleRPC(ctx, msg)
+}
+
+// GetRules returns up to top rules of the subscription the client manages, skipping the first skip rules.
+func (r *rpcClient) GetRules(ctx context.Context, skip, top int32) ([]*RuleEntity, error) {
+	ctx, span := startConsumerSpanFromContext(ctx, "sb.rpcClient.GetRules")
+	defer span.End()
+
+	msg := &amqp.Message{
+		ApplicationProperties: map[string]interface{}{
+			operationFieldName: enumerateRulesOperationID,
+		},
+		Value: map[string]interface{}{
+			"top":  top,
+			"skip": skip,
+		},
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.ApplicationProperties[serverTimeoutFieldName] = uint(time.Until(deadline) / time.Millisecond)
+	}
+
+	resp, err := r.doRPCWithRetry(ctx, r.ec.ManagementPath(), msg, 5, 5*time.Second)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	if resp.Code == 204 {
+		return nil, nil
+	}
+
+	if resp.Code != 200 {
+		return nil, ErrAMQP(*resp)
+	}
+
+	rules, err := rulesFromAMQP(resp.Message.Value)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+	return rules, nil
+}
+
+// rulesFromAMQP reads the rules of an enumerate-rules response.
+func rulesFromAMQP(value interface{}) ([]*RuleEntity, error) {
+	val, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, newErrIncorrectType("value", map[string]interface{}{}, value)
+	}
+
+	rawRules, ok := val[rulesFieldName]
+	if !ok {
+		return nil, ErrMissingField(rulesFieldName)
+	}
+
+	entries, ok := rawRules.([]interface{})
+	if !ok {
+		return nil, newErrIncorrectType(rulesFieldName, []interface{}{}, rawRules)
+	}
+
+	rules := make([]*RuleEntity, len(entries))
+	for i, entry := range entries {
+		rule, err := ruleEntityFromAMQP(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules[i] = rule
+	}
+	return rules, nil
+}
+
+// doRuleRPC sends a rule management request, expecting an OK response.
+func (r *rpcClient) doRuleRPC(ctx context.Context, msg *amqp.Message) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.ApplicationProperties[serverTimeoutFieldName] = uint(time.Until(deadline) / time.Millisecond)
+	}
+
+	resp, err := r.doRPCWithRetry(ctx, r.ec.ManagementPath(), msg, 5, 5*time.Second)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+
+	if resp.Code != 200 {
+		return ErrAMQP(*resp)
+	}
+	return nil
+}
+
 func rpcClientWithSession(sessionID *string) rpcClientOption {
 	return func(r *rpcClient) error {
 		r.sessionID = sessionID
@@ -0,0 +1,295 @@
+package servicebus
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/date"
+)
+
+// Rules are described over the $management link as AMQP described types, identified by the descriptor codes below.
+// Note that the codes of the SQL filter and of the SQL rule action differ only by a digit.
+const (
+	ruleDescriptionCode   uint64 = 0x0000013700000004
+	emptyRuleActionCode   uint64 = 0x0000013700000005
+	sqlRuleActionCode     uint64 = 0x0000013700000006
+	sqlFilterCode         uint64 = 0x0000001370000006
+	trueFilterCode        uint64 = 0x0000001370000007
+	falseFilterCode       uint64 = 0x0000001370000008
+	correlationFilterCode uint64 = 0x0000001370000009
+)
+
+var (
+	// descriptor names which may be used instead of the codes
+	ruleDescriptorNames = map[string]uint64{
+		vendorPrefix + "rule-description":   ruleDescriptionCode,
+		vendorPrefix + "empty-rule-action":  emptyRuleActionCode,
+		vendorPrefix + "sql-rule-action":    sqlRuleActionCode,
+		vendorPrefix + "sql-filter":         sqlFilterCode,
+		vendorPrefix + "true-filter":        trueFilterCode,
+		vendorPrefix + "false-filter":       falseFilterCode,
+		vendorPrefix + "correlation-filter": correlationFilterCode,
+	}
+)
+
+// ruleDescriptionToAMQP builds the rule-description of an add-rule request.
+func ruleDescriptionToAMQP(name string, filter FilterDescription, action *ActionDescription) (map[string]interface{}, error) {
+	rd := map[string]interface{}{
+		ruleNameFieldName: name,
+	}
+
+	switch filter.Type {
+	case "TrueFilter", "FalseFilter", "SqlFilter":
+		if filter.SQLExpression == nil {
+			return nil, fmt.Errorf("%s has no expression", filter.Type)
+		}
+		rd["sql-filter"] = map[string]interface{}{
+			"expression": *filter.SQLExpression,
+		}
+	case "CorrelationFilter":
+		cf := filter.CorrelationFilter
+		properties := make(map[string]interface{}, len(cf.Properties))
+		for key, value := range cf.Properties {
+			properties[key] = value
+		}
+
+		rd["correlation-filter"] = map[string]interface{}{
+			"correlation-id":      optionalAMQPString(cf.CorrelationID),
+			"message-id":          optionalAMQPString(cf.MessageID),
+			"to":                  optionalAMQPString(cf.To),
+			"reply-to":            optionalAMQPString(cf.ReplyTo),
+			"label":               optionalAMQPString(cf.Label),
+			"session-id":          optionalAMQPString(cf.SessionID),
+			"reply-to-session-id": optionalAMQPString(cf.ReplyToSessionID),
+			"content-type":        optionalAMQPString(cf.ContentType),
+			"properties":          properties,
+		}
+	default:
+		return nil, fmt.Errorf("filters of type %q cannot be added over AMQP", filter.Type)
+	}
+
+	switch {
+	case action == nil || action.Type == "" || action.Type == "EmptyRuleAction":
+		rd["sql-rule-action"] = nil
+	case action.Type == "SqlRuleAction":
+		rd["sql-rule-action"] = map[string]interface{}{
+			"expression": action.SQLExpression,
+		}
+	default:
+		return nil, fmt.Errorf("actions of type %q cannot be added over AMQP", action.Type)
+	}
+
+	return rd, nil
+}
+
+// ruleEntityFromAMQP reads an entry of the rules of an enumerate-rules response, a map holding a described rule
+// description.
+func ruleEntityFromAMQP(raw interface{}) (*RuleEntity, error) {
+	entry, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, newErrIncorrectType(rulesFieldName, map[string]interface{}{}, raw)
+	}
+
+	rawDescription, ok := entry[ruleDescriptionFieldName]
+	if !ok {
+		return nil, ErrMissingField(ruleDescriptionFieldName)
+	}
+
+	fields, err := describedFields(ruleDescriptionFieldName, rawDescription, ruleDescriptionCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("rule description has %d fields rather than at least 3", len(fields))
+	}
+
+	filter, err := filterDescriptionFromAMQP(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	action, err := actionDescriptionFromAMQP(fields[1])
+	if err != nil {
+		return nil, err
+	}
+
+	name, ok := fields[2].(string)
+	if !ok {
+		return nil, newErrIncorrectType(ruleNameFieldName, "", fields[2])
+	}
+
+	rd := &RuleDescription{
+		Filter: *filter,
+		Action: action,
+	}
+	if len(fields) > 3 {
+		if createdAt, ok := fields[3].(time.Time); ok {
+			rd.CreatedAt = &date.Time{Time: createdAt}
+		}
+	}
+
+	return &RuleEntity{
+		RuleDescription: rd,
+		Entity:          &Entity{Name: name},
+	}, nil
+}
+
+func filterDescriptionFromAMQP(raw interface{}) (*FilterDescription, error) {
+	code, fields, err := describedType("filter", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch code {
+	case trueFilterCode:
+		fd := TrueFilter{}.ToFilterDescription()
+		return &fd, nil
+	case falseFilterCode:
+		fd := FalseFilter{}.ToFilterDescription()
+		return &fd, nil
+	case sqlFilterCode:
+		expression, compatibilityLevel, err := sqlExpressionFromAMQP(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &FilterDescription{
+			Type:               "SqlFilter",
+			SQLExpression:      &expression,
+			CompatibilityLevel: compatibilityLevel,
+		}, nil
+	case correlationFilterCode:
+		cf := CorrelationFilter{}
+		targets := []**string{
+			&cf.CorrelationID, &cf.MessageID, &cf.To, &cf.ReplyTo, &cf.Label, &cf.SessionID, &cf.ReplyToSessionID,
+			&cf.ContentType,
+		}
+		for i, target := range targets {
+			if i < len(fields) {
+				if s, ok := fields[i].(string); ok {
+					*target = &s
+				}
+			}
+		}
+
+		if len(fields) > len(targets) {
+			if properties, ok := fields[len(targets)].(map[string]interface{}); ok && len(properties) > 0 {
+				cf.Properties = CorrelationProperties(properties)
+			}
+		}
+
+		fd := cf.ToFilterDescription()
+		return &fd, nil
+	default:
+		return nil, fmt.Errorf("unknown filter descriptor %#x", code)
+	}
+}
+
+func actionDescriptionFromAMQP(raw interface{}) (*ActionDescription, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	code, fields, err := describedType("action", raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch code {
+	case emptyRuleActionCode:
+		return &ActionDescription{Type: "EmptyRuleAction"}, nil
+	case sqlRuleActionCode:
+		expression, compatibilityLevel, err := sqlExpressionFromAMQP(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &ActionDescription{
+			Type:               "SqlRuleAction",
+			SQLExpression:      expression,
+			CompatibilityLevel: compatibilityLevel,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown action descriptor %#x", code)
+	}
+}
+
+// sqlExpressionFromAMQP reads the expression and the compatibility level of a SQL filter or action.
+func sqlExpressionFromAMQP(fields []interface{}) (string, int, error) {
+	if len(fields) < 1 {
+		return "", 0, ErrMissingField("expression")
+	}
+
+	expression, ok := fields[0].(string)
+	if !ok {
+		return "", 0, newErrIncorrectType("expression", "", fields[0])
+	}
+
+	var compatibilityLevel int
+	if len(fields) > 1 {
+		if level, ok := sqlValue(fields[1]).(int64); ok {
+			compatibilityLevel = int(level)
+		}
+	}
+	return expression, compatibilityLevel, nil
+}
+
+// describedFields returns the fields of a described list, checking its descriptor is expected.
+func describedFields(name string, raw interface{}, expected uint64) ([]interface{}, error) {
+	code, fields, err := describedType(name, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != expected {
+		return nil, fmt.Errorf("%s has descriptor %#x rather than %#x", name, code, expected)
+	}
+	return fields, nil
+}
+
+// describedType returns the descriptor code and the fields of a described list. go-amqp does not export its
+// described type, so it is read through reflection, as a struct with Descriptor and Value fields.
+func describedType(name string, raw interface{}) (uint64, []interface{}, error) {
+	v := reflect.ValueOf(raw)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return 0, nil, fmt.Errorf("%s is a %T rather than a described type", name, raw)
+	}
+
+	descriptorField, valueField := v.FieldByName("Descriptor"), v.FieldByName("Value")
+	if !descriptorField.IsValid() || !valueField.IsValid() {
+		return 0, nil, fmt.Errorf("%s is a %T rather than a described type", name, raw)
+	}
+
+	var code uint64
+	switch descriptor := reflect.ValueOf(descriptorField.Interface()); descriptor.Kind() {
+	case reflect.Uint64:
+		code = descriptor.Uint()
+	case reflect.String:
+		var ok bool
+		if code, ok = ruleDescriptorNames[descriptor.String()]; !ok {
+			return 0, nil, fmt.Errorf("%s has unknown descriptor %q", name, descriptor.String())
+		}
+	default:
+		return 0, nil, fmt.Errorf("%s has descriptor %v of unexpected type %T", name, descriptorField.Interface(), descriptorField.Interface())
+	}
+
+	switch fields := valueField.Interface().(type) {
+	case []interface{}:
+		return code, fields, nil
+	case nil:
+		return code, nil, nil
+	default:
+		return 0, nil, newErrIncorrectType(name, []interface{}{}, fields)
+	}
+}
+
+func optionalAMQPString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
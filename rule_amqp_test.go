@@ -0,0 +1,208 @@
+package servicebus
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	// testAMQPEncoder writes the subset of the AMQP type system the $management link describes rules with
+	testAMQPEncoder []byte
+
+	testDescribedType struct {
+		Descriptor interface{}
+		Value      interface{}
+	}
+
+	testSymbol string
+)
+
+func (e testAMQPEncoder) uint32(u uint32) testAMQPEncoder {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, u)
+	return append(e, b...)
+}
+
+func (e testAMQPEncoder) uint64(u uint64) testAMQPEncoder {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, u)
+	return append(e, b...)
+}
+
+func (e testAMQPEncoder) null() testAMQPEncoder {
+	return append(e, 0x40)
+}
+
+func (e testAMQPEncoder) str(s string) testAMQPEncoder {
+	return append(append(e, 0xb1).uint32(uint32(len(s))), s...)
+}
+
+func (e testAMQPEncoder) int(i int32) testAMQPEncoder {
+	return append(e, 0x71).uint32(uint32(i))
+}
+
+func (e testAMQPEncoder) timestamp(t time.Time) testAMQPEncoder {
+	return append(e, 0x83).uint64(uint64(t.UnixNano() / int64(time.Millisecond)))
+}
+
+func (e testAMQPEncoder) compound(code byte, count int, items testAMQPEncoder) testAMQPEncoder {
+	return append(append(e, code).uint32(uint32(4+len(items))).uint32(uint32(count)), items...)
+}
+
+func (e testAMQPEncoder) list(count int, items testAMQPEncoder) testAMQPEncoder {
+	return e.compound(0xd0, count, items)
+}
+
+func (e testAMQPEncoder) mapOf(pairs int, items testAMQPEncoder) testAMQPEncoder {
+	return e.compound(0xd1, 2*pairs, items)
+}
+
+func (e testAMQPEncoder) described(code uint64, value testAMQPEncoder) testAMQPEncoder {
+	return append(append(e, 0x00, 0x80).uint64(code), value...)
+}
+
+func TestRuleDescriptionToAMQP(t *testing.T) {
+	ad := SQLAction{Expression: "SET a = 1"}.ToActionDescription()
+	rd, err := ruleDescriptionToAMQP("sql", SQLFilter{Expression: "b = 2"}.ToFilterDescription(), &ad)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"rule-name":       "sql",
+		"sql-filter":      map[string]interface{}{"expression": "b = 2"},
+		"sql-rule-action": map[string]interface{}{"expression": "SET a = 1"},
+	}, rd)
+
+	rd, err = ruleDescriptionToAMQP("true", TrueFilter{}.ToFilterDescription(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"rule-name":       "true",
+		"sql-filter":      map[string]interface{}{"expression": "1=1"},
+		"sql-rule-action": nil,
+	}, rd)
+
+	cf := CorrelationFilter{
+		CorrelationID: to.StringPtr("c"),
+		Label:         to.StringPtr("l"),
+		Properties:    CorrelationProperties{"region": "eu", "count": int32(3)},
+	}
+	rd, err = ruleDescriptionToAMQP("correlation", cf.ToFilterDescription(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"correlation-id":      "c",
+		"message-id":          nil,
+		"to":                  nil,
+		"reply-to":            nil,
+		"label":               "l",
+		"session-id":          nil,
+		"reply-to-session-id": nil,
+		"content-type":        nil,
+		"properties":          map[string]interface{}{"region": "eu", "count": int32(3)},
+	}, rd["correlation-filter"])
+
+	// the request is encodable by go-amqp
+	request := &amqp.Message{Value: map[string]interface{}{ruleNameFieldName: "correlation", ruleDescriptionFieldName: rd}}
+	b, err := request.MarshalBinary()
+	require.NoError(t, err)
+	var decoded amqp.Message
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	assert.Equal(t, request.Value, decoded.Value)
+
+	_, err = ruleDescriptionToAMQP("unknown", FilterDescription{Type: "UnknownFilter"}, nil)
+	assert.Error(t, err)
+
+	_, err = ruleDescriptionToAMQP("unknown", TrueFilter{}.ToFilterDescription(), &ActionDescription{Type: "UnknownAction"})
+	assert.Error(t, err)
+}
+
+func TestRulesFromAMQP(t *testing.T) {
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var e testAMQPEncoder
+	sqlRule := e.mapOf(1, e.str(ruleDescriptionFieldName).described(ruleDescriptionCode, e.list(4,
+		e.described(sqlFilterCode, e.list(2, e.str("color = 'red'").int(20))).
+			described(sqlRuleActionCode, e.list(2, e.str("SET routed = TRUE").int(20))).
+			str("red").
+			timestamp(created))))
+	correlationRule := e.mapOf(1, e.str(ruleDescriptionFieldName).described(ruleDescriptionCode, e.list(3,
+		e.described(correlationFilterCode, e.list(9,
+			e.str("c").null().null().null().str("l").null().null().null().
+				mapOf(1, e.str("region").str("eu")))).
+			described(emptyRuleActionCode, e.list(0, nil)).
+			str("correlation"))))
+	defaultRule := e.mapOf(1, e.str(ruleDescriptionFieldName).described(ruleDescriptionCode, e.list(3,
+		e.described(trueFilterCode, e.list(2, e.str("1=1").int(20))).
+			described(emptyRuleActionCode, e.list(0, nil)).
+			str("$Default"))))
+
+	body := e.mapOf(1, e.str(rulesFieldName).list(3, append(append(sqlRule, correlationRule...), defaultRule...)))
+	var msg amqp.Message
+	require.NoError(t, msg.UnmarshalBinary(append([]byte{0x00, 0x53, 0x77}, body...)))
+
+	rules, err := rulesFromAMQP(msg.Value)
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+
+	assert.Equal(t, "red", rules[0].Name)
+	assert.Equal(t, "SqlFilter", rules[0].Filter.Type)
+	assert.Equal(t, "color = 'red'", *rules[0].Filter.SQLExpression)
+	assert.Equal(t, 20, rules[0].Filter.CompatibilityLevel)
+	assert.Equal(t, &ActionDescription{Type: "SqlRuleAction", SQLExpression: "SET routed = TRUE", CompatibilityLevel: 20}, rules[0].Action)
+	require.NotNil(t, rules[0].CreatedAt)
+	assert.True(t, created.Equal(rules[0].CreatedAt.Time))
+
+	assert.Equal(t, "correlation", rules[1].Name)
+	assert.Equal(t, CorrelationFilter{
+		CorrelationID: to.StringPtr("c"),
+		Label:         to.StringPtr("l"),
+		Properties:    CorrelationProperties{"region": "eu"},
+	}.ToFilterDescription(), rules[1].Filter)
+	assert.Equal(t, &ActionDescription{Type: "EmptyRuleAction"}, rules[1].Action)
+
+	assert.Equal(t, "$Default", rules[2].Name)
+	assert.Equal(t, TrueFilter{}.ToFilterDescription(), rules[2].Filter)
+	assert.Nil(t, rules[2].CreatedAt)
+}
+
+func TestRuleEntityFromAMQP_DescriptorNames(t *testing.T) {
+	rule, err := ruleEntityFromAMQP(map[string]interface{}{
+		ruleDescriptionFieldName: testDescribedType{
+			Descriptor: testSymbol("com.microsoft:rule-description"),
+			Value: []interface{}{
+				&testDescribedType{Descriptor: testSymbol("com.microsoft:false-filter"), Value: []interface{}{"1=0"}},
+				nil,
+				"never",
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "never", rule.Name)
+	assert.Equal(t, "FalseFilter", rule.Filter.Type)
+	assert.Nil(t, rule.Action)
+}
+
+func TestRulesFromAMQP_Malformed(t *testing.T) {
+	for _, value := range []interface{}{
+		nil,
+		map[string]interface{}{},
+		map[string]interface{}{rulesFieldName: "rules"},
+		map[string]interface{}{rulesFieldName: []interface{}{"rule"}},
+		map[string]interface{}{rulesFieldName: []interface{}{map[string]interface{}{}}},
+		map[string]interface{}{rulesFieldName: []interface{}{map[string]interface{}{ruleDescriptionFieldName: "rule"}}},
+		map[string]interface{}{rulesFieldName: []interface{}{map[string]interface{}{
+			ruleDescriptionFieldName: testDescribedType{Descriptor: sqlFilterCode, Value: []interface{}{"1=1"}},
+		}}},
+		map[string]interface{}{rulesFieldName: []interface{}{map[string]interface{}{
+			ruleDescriptionFieldName: testDescribedType{Descriptor: ruleDescriptionCode, Value: []interface{}{
+				testDescribedType{Descriptor: uint64(42), Value: []interface{}{}}, nil, "unknown",
+			}},
+		}}},
+	} {
+		_, err := rulesFromAMQP(value)
+		assert.Error(t, err, "%#v", value)
+	}
+}
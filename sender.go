@@ -25,6 +25,7 @@ package servicebus
 import (
 	"context"
 	"errors"
+	"math"
 	"sync"
 	"time"
 
@@ -46,6 +47,10 @@ type (
 		entityPath        string
 		Name              string
 		sessionID         *string
+		maxMessageSize    uint64
+		compression       *Compression
+		keyProvider       KeyProvider
+		claimCheck        *claimCheck
 		cancelAuthRefresh func() <-chan struct{}
 	}
 
@@ -60,6 +65,16 @@ type (
 
 	// SenderOption provides a way to customize a Sender
 	SenderOption func(*Sender) error
+
+	// outgoingMessage sends a Message with the payload transforms of a Sender applied to it, such as compression,
+	// leaving the Message itself untouched
+	outgoingMessage struct {
+		*Message
+		transforms []func(*amqp.Message) error
+		// claimCheck and reference identify the payload uploaded by the claim check, if any
+		claimCheck *claimCheck
+		reference  string
+	}
 )
 
 // NewSender creates a new Service Bus message Sender given an AMQP client and entity path
@@ -186,7 +201,108 @@ func (s *Sender) Send(ctx context.Context, msg *Message, opts ...SendOption) err
 		}
 	}
 
-	return s.trySend(ctx, msg)
+	om := s.newOutgoingMessage(ctx, msg)
+	if err := s.trySend(ctx, om); err != nil {
+		if om.reference != "" {
+			om.claimCheck.discardPayload(ctx, om.reference)
+		}
+		return err
+	}
+	return nil
+}
+
+// newOutgoingMessage wraps msg with the transforms to apply to the AMQP message built from it before it is sent, in
+// order: compression first, as ciphertext does not compress, then encryption, so that the claim check only stores
+// what is still too large once compressed, and never stores plaintext.
+func (s *Sender) newOutgoingMessage(ctx context.Context, msg *Message) *outgoingMessage {
+	om := &outgoingMessage{Message: msg}
+	if s.compression != nil {
+		om.transforms = append(om.transforms, s.compression.apply)
+	}
+	if s.keyProvider != nil {
+		om.transforms = append(om.transforms, func(amqpMsg *amqp.Message) error {
+			return encryptPayload(ctx, s.keyProvider, amqpMsg)
+		})
+	}
+	if s.claimCheck != nil {
+		om.transforms = append(om.transforms, func(amqpMsg *amqp.Message) error {
+			reference, err := s.claimCheck.apply(ctx, amqpMsg)
+			if reference != "" {
+				om.claimCheck, om.reference = s.claimCheck, reference
+			}
+			return err
+		})
+	}
+	return om
+}
+
+func (om *outgoingMessage) toMsg() (*amqp.Message, error) {
+	msg, err := om.Message.toMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, transform := range om.transforms {
+		if err := transform(msg); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// SendBatch sends a batch of messages, such as one built with NewBatch, to the entity path
+func (s *Sender) SendBatch(ctx context.Context, batch *MessageBatch) error {
+	ctx, span := s.startProducerSpanFromContext(ctx, "sb.Sender.SendBatch")
+	defer span.End()
+
+	return s.trySend(ctx, batch)
+}
+
+// NewBatch creates an empty MessageBatch sized to the maximum message size of the Sender. Messages added to it are
+// compressed and encrypted as Send would transform them, and carry the session of the Sender if it has one. Data keys
+// of encrypted messages are wrapped with ctx, so it must last as long as messages are added to the batch.
+func (s *Sender) NewBatch(ctx context.Context) (*MessageBatch, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMessageBatch(s.MaxMessageSize(), id.String(), s.batchOptions(ctx)), nil
+}
+
+// MaxMessageSize returns the maximum size of a message the broker advertised when the link of the Sender was
+// attached, or StandardMaxMessageSizeInBytes if it advertised none.
+func (s *Sender) MaxMessageSize() MaxMessageSizeInBytes {
+	s.clientMu.RLock()
+	size := s.maxMessageSize
+	s.clientMu.RUnlock()
+
+	if size == 0 {
+		return StandardMaxMessageSizeInBytes
+	}
+	if size > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return MaxMessageSizeInBytes(size)
+}
+
+// batchOptions returns the BatchOptions of the batches sent by the Sender. The data keys of messages added to the
+// batches are wrapped with ctx.
+func (s *Sender) batchOptions(ctx context.Context) *BatchOptions {
+	opts := &BatchOptions{
+		SessionID:   s.sessionID,
+		Compression: s.compression,
+		MaxSize:     s.MaxMessageSize(),
+	}
+	if s.keyProvider != nil {
+		opts.transforms = append(opts.transforms, func(msg *amqp.Message) error {
+			return encryptPayload(ctx, s.keyProvider, msg)
+		})
+	}
+	if s.claimCheck != nil {
+		opts.transforms = append(opts.transforms, s.claimCheck.refuse)
+	}
+	return opts
 }
 
 func (s *Sender) trySend(ctx context.Context, evt eventer) error {
@@ -206,7 +322,12 @@ func (s *Sender) trySend(ctx context.Context, evt eventer) error {
 	}
 
 	if msg.Properties != nil {
-		sp.AddAttributes(tab.StringAttribute("sb.message.id", msg.Properties.MessageID.(string)))
+		sp.AddAttributes(tab.StringAttribute("sb.message.id", formatAMQPID(msg.Properties.MessageID)))
+	}
+
+	count, size := 1, payloadSize(msg)
+	if mb, ok := evt.(*MessageBatch); ok {
+		count = len(mb.marshaledMessages)
 	}
 
 	for {
@@ -218,6 +339,11 @@ func (s *Sender) trySend(ctx context.Context, evt eventer) error {
 			return err
 		default:
 			// try as long as the context is not dead
+			if err := s.namespace.throttle.wait(ctx, count, size); err != nil {
+				tab.For(ctx).Error(err)
+				return err
+			}
+
 			s.clientMu.RLock()
 			if s.sender == nil {
 				// another goroutine has closed the connection
@@ -228,6 +354,7 @@ func (s *Sender) trySend(ctx context.Context, evt eventer) error {
 			s.clientMu.RUnlock()
 			if err == nil {
 				// successful send
+				s.namespace.throttle.onSuccess(count)
 				return err
 			}
 
@@ -253,6 +380,8 @@ func (s *Sender) handleAMQPError(ctx context.Context, err error) error {
 	if errors.As(err, &amqpError) {
 		switch amqpError.Condition {
 		case errorServerBusy:
+			// the throttle of the namespace slows down every later send, while this one still backs off
+			s.namespace.throttle.onServerBusy()
 			return s.retryRetryableAmqpError(ctx, amqpRetryDefaultTimes, amqpRetryBusyServerDelay)
 		case errorTimeout:
 			return s.retryRetryableAmqpError(ctx, amqpRetryDefaultTimes, amqpRetryDefaultDelay)
@@ -359,6 +488,7 @@ func (s *Sender) newSessionAndLink(ctx context.Context) error {
 	}
 
 	s.sender = amqpSender
+	s.maxMessageSize = amqpSender.MaxMessageSize()
 	return nil
 }
 
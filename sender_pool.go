@@ -0,0 +1,143 @@
+package servicebus
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/devigned/tab"
+)
+
+type (
+	// SenderPool spreads sends across several Senders to the same entity, each with a connection and link of its own,
+	// so that a slow link does not hold up every send. Messages with a SessionID, or else a PartitionKey, always go
+	// through the same Sender, which keeps them in order; other messages are spread round robin. A SenderPool is
+	// created with NewSenderPool and is safe for concurrent use.
+	SenderPool struct {
+		senders []pooledSender
+		next    uint32
+	}
+
+	// pooledSender is the part of Sender used by a SenderPool
+	pooledSender interface {
+		Send(ctx context.Context, msg *Message, opts ...SendOption) error
+		SendBatch(ctx context.Context, batch *MessageBatch) error
+		Close(ctx context.Context) error
+	}
+)
+
+// NewSenderPool creates a SenderPool of size Senders to entityPath, each created with opts.
+func (ns *Namespace) NewSenderPool(ctx context.Context, entityPath string, size int, opts ...SenderOption) (*SenderPool, error) {
+	ctx, span := ns.startSpanFromContext(ctx, "sb.Namespace.NewSenderPool")
+	defer span.End()
+
+	if size < 1 {
+		return nil, fmt.Errorf("sender pool size must be at least 1, but was %d", size)
+	}
+
+	pool := &SenderPool{
+		senders: make([]pooledSender, 0, size),
+	}
+
+	for i := 0; i < size; i++ {
+		s, err := ns.newSenderOfPool(ctx, entityPath, opts...)
+		if err != nil {
+			tab.For(ctx).Error(err)
+			_ = pool.Close(ctx)
+			return nil, err
+		}
+		pool.senders = append(pool.senders, s)
+	}
+	return pool, nil
+}
+
+// NewSenderPool creates a SenderPool of size Senders to the queue
+func (q *Queue) NewSenderPool(ctx context.Context, size int, opts ...SenderOption) (*SenderPool, error) {
+	ctx, span := q.startSpanFromContext(ctx, "sb.Queue.NewSenderPool")
+	defer span.End()
+
+	opts = append(q.senderOptions[:len(q.senderOptions):len(q.senderOptions)], opts...)
+	return q.namespace.NewSenderPool(ctx, q.Name, size, opts...)
+}
+
+// NewSenderPool creates a SenderPool of size Senders to the topic
+func (t *Topic) NewSenderPool(ctx context.Context, size int, opts ...SenderOption) (*SenderPool, error) {
+	ctx, span := t.startSpanFromContext(ctx, "sb.Topic.NewSenderPool")
+	defer span.End()
+
+	opts = append(t.senderOptions[:len(t.senderOptions):len(t.senderOptions)], opts...)
+	return t.namespace.NewSenderPool(ctx, t.Name, size, opts...)
+}
+
+// newSenderOfPool creates a Sender of a SenderPool.
+func (ns *Namespace) newSenderOfPool(ctx context.Context, entityPath string, opts ...SenderOption) (pooledSender, error) {
+	if ns.newPooledSender != nil {
+		return ns.newPooledSender(ctx, entityPath, opts...)
+	}
+	return ns.NewSender(ctx, entityPath, opts...)
+}
+
+// Size returns the number of Senders in the pool.
+func (p *SenderPool) Size() int {
+	return len(p.senders)
+}
+
+// Send sends msg through the Sender its SessionID or PartitionKey maps to, or through the next Sender.
+func (p *SenderPool) Send(ctx context.Context, msg *Message, opts ...SendOption) error {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.SenderPool.Send")
+	defer span.End()
+
+	return p.pick(orderingKey(msg)).Send(ctx, msg, opts...)
+}
+
+// SendBatch sends batch through the Sender its SessionID maps to, or through the next Sender.
+func (p *SenderPool) SendBatch(ctx context.Context, batch *MessageBatch) error {
+	ctx, span := startProducerSpanFromContext(ctx, "sb.SenderPool.SendBatch")
+	defer span.End()
+
+	var key string
+	if batch.SessionID != nil {
+		key = sessionOrderingKey(*batch.SessionID)
+	}
+	return p.pick(key).SendBatch(ctx, batch)
+}
+
+// Close closes every Sender of the pool, returning the last error encountered.
+func (p *SenderPool) Close(ctx context.Context) error {
+	var lastErr error
+	for _, s := range p.senders {
+		if err := s.Close(ctx); err != nil {
+			tab.For(ctx).Error(err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// pick returns the Sender key maps to, or the next Sender round robin if key is empty.
+func (p *SenderPool) pick(key string) pooledSender {
+	if key == "" {
+		return p.senders[(atomic.AddUint32(&p.next, 1)-1)%uint32(len(p.senders))]
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return p.senders[h.Sum32()%uint32(len(p.senders))]
+}
+
+// orderingKey returns the key which messages are kept in order by: the SessionID, or else the PartitionKey.
+func orderingKey(msg *Message) string {
+	if msg.SessionID != nil {
+		return sessionOrderingKey(*msg.SessionID)
+	}
+
+	if msg.SystemProperties != nil && msg.SystemProperties.PartitionKey != nil {
+		return "partition:" + *msg.SystemProperties.PartitionKey
+	}
+	return ""
+}
+
+func sessionOrderingKey(sessionID string) string {
+	return "session:" + sessionID
+}
@@ -0,0 +1,155 @@
+package servicebus
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePooledSender struct {
+	sent    []*Message
+	batches []*MessageBatch
+	closed  bool
+	mu      sync.Mutex
+}
+
+func (f *fakePooledSender) Send(_ context.Context, msg *Message, _ ...SendOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+func (f *fakePooledSender) SendBatch(_ context.Context, batch *MessageBatch) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.batches = append(f.batches, batch)
+	return nil
+}
+
+func (f *fakePooledSender) Close(context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return errors.New("close failed")
+}
+
+func newTestSenderPool(size int) (*SenderPool, []*fakePooledSender) {
+	pool := new(SenderPool)
+	var fakes []*fakePooledSender
+	for i := 0; i < size; i++ {
+		f := new(fakePooledSender)
+		fakes = append(fakes, f)
+		pool.senders = append(pool.senders, f)
+	}
+	return pool, fakes
+}
+
+func TestSenderPool_RoundRobin(t *testing.T) {
+	ctx := context.Background()
+	pool, fakes := newTestSenderPool(3)
+	assert.Equal(t, 3, pool.Size())
+
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, pool.Send(ctx, NewMessageFromString("foo")))
+	}
+
+	for _, f := range fakes {
+		assert.Len(t, f.sent, 2)
+	}
+}
+
+func TestSenderPool_OrderingKeys(t *testing.T) {
+	ctx := context.Background()
+	pool, fakes := newTestSenderPool(4)
+
+	senderOf := func(msg *Message) *fakePooledSender {
+		for _, f := range fakes {
+			for _, sent := range f.sent {
+				if sent == msg {
+					return f
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, session := range []string{"a", "b", "c", "d", "e"} {
+		var first *fakePooledSender
+		for i := 0; i < 5; i++ {
+			msg := NewMessageFromString("foo")
+			msg.SessionID = ptrString(session)
+			assert.NoError(t, pool.Send(ctx, msg))
+
+			if first == nil {
+				first = senderOf(msg)
+			}
+			assert.Same(t, first, senderOf(msg), "messages of session %s go through the same sender", session)
+		}
+
+		batch := NewMessageBatch(StandardMaxMessageSizeInBytes, "batch", &BatchOptions{SessionID: ptrString(session)})
+		assert.NoError(t, pool.SendBatch(ctx, batch))
+		assert.Contains(t, first.batches, batch, "batches of session %s go through the same sender as messages", session)
+	}
+
+	var first *fakePooledSender
+	for i := 0; i < 5; i++ {
+		msg := NewMessageFromString("foo")
+		msg.SystemProperties = &SystemProperties{PartitionKey: ptrString("key")}
+		assert.NoError(t, pool.Send(ctx, msg))
+
+		if first == nil {
+			first = senderOf(msg)
+		}
+		assert.Same(t, first, senderOf(msg))
+	}
+}
+
+func TestSenderPool_Close(t *testing.T) {
+	pool, fakes := newTestSenderPool(2)
+	assert.Error(t, pool.Close(context.Background()))
+	for _, f := range fakes {
+		assert.True(t, f.closed)
+	}
+
+	_, err := (&Namespace{}).NewSenderPool(context.Background(), "queue", 0)
+	assert.Error(t, err)
+}
+
+func TestTopic_NewSenderPoolAppliesTopicOptions(t *testing.T) {
+	ctx := context.Background()
+	kp := newTestKeyProvider(t, "kek-1")
+
+	ns := new(Namespace)
+	ns.newPooledSender = func(_ context.Context, entityPath string, opts ...SenderOption) (pooledSender, error) {
+		s := &Sender{entityPath: entityPath}
+		for _, opt := range opts {
+			if err := opt(s); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+
+	topic, err := ns.NewTopic("topic", TopicWithEncryption(kp))
+	require.NoError(t, err)
+	pool, err := topic.NewSenderPool(ctx, 2, SenderWithCompression(CompressionGzip, 0))
+	require.NoError(t, err)
+
+	payload := []byte("sensitive payload")
+	for _, ps := range pool.senders {
+		s := ps.(*Sender)
+		assert.Equal(t, "topic", s.entityPath)
+		assert.NotNil(t, s.compression)
+
+		amqpMsg, err := s.newOutgoingMessage(ctx, NewMessage(payload)).toMsg()
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(amqpMsg.Data[0], payload))
+		assert.Equal(t, EncryptionAlgorithmAES256GCM, amqpMsg.ApplicationProperties[EncryptionAlgorithmProperty])
+	}
+}
@@ -24,6 +24,8 @@ package servicebus
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 
@@ -213,13 +215,58 @@ func (qs *QueueSession) ReceiveDeferred(ctx context.Context, handler Handler, mo
 		return err
 	}
 
-	for _, msg := range messages {
-		if err := handler.Handle(ctx, msg); err != nil {
-			tab.For(ctx).Error(err)
-			return err
-		}
+	return qs.builder.getEntity().handleDeferred(ctx, handler, mode, messages)
+}
+
+// Peek fetches a list of Messages belonging to the session from the Service Bus broker without acquiring the session
+// lock or committing to a disposition. See Queue.Peek for a description of the returned MessageIterator. A PeekWithSession
+// option naming another session than the one of the QueueSession is rejected.
+func (qs *QueueSession) Peek(ctx context.Context, options ...PeekOption) (MessageIterator, error) {
+	ctx, span := qs.startSpanFromContext(ctx, "sb.QueueSession.Peek")
+	defer span.End()
+
+	if qs.sessionID == nil {
+		return nil, errors.New("session ID must be set to peek a session")
 	}
-	return nil
+
+	return qs.newPeekIterator(ctx, options...)
+}
+
+// PeekOne fetches a single Message belonging to the session from the Service Bus broker without acquiring the session
+// lock or committing to a disposition.
+func (qs *QueueSession) PeekOne(ctx context.Context, options ...PeekOption) (*Message, error) {
+	ctx, span := qs.startSpanFromContext(ctx, "sb.QueueSession.PeekOne")
+	defer span.End()
+
+	if qs.sessionID == nil {
+		return nil, errors.New("session ID must be set to peek a session")
+	}
+
+	it, err := qs.newPeekIterator(ctx, append(options, PeekWithPageSize(1))...)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single message is wanted, so there is no next page worth fetching in the background.
+	it.readAhead = false
+	return it.Next(ctx)
+}
+
+// newPeekIterator builds a peekIterator over the messages of the session. A PeekWithSession option naming another
+// session is rejected, as the iterator is bound to the session of qs.
+func (qs *QueueSession) newPeekIterator(ctx context.Context, options ...PeekOption) (*peekIterator, error) {
+	it, err := newPeekIterator(ctx, qs.getRPCClient, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if it.sessionID != nil && *it.sessionID != *qs.sessionID {
+		return nil, fmt.Errorf("cannot peek session %q from a QueueSession bound to session %q", *it.sessionID, *qs.sessionID)
+	}
+
+	it.sessionID = qs.sessionID
+	it.restore = qs.builder.getEntity().restorePayload
+	return it, nil
 }
 
 // Send the message to the queue within a session
@@ -298,6 +345,14 @@ func (qs *QueueSession) ensureRPCClient(ctx context.Context) error {
 	return nil
 }
 
+func (qs *QueueSession) getRPCClient(ctx context.Context) (*rpcClient, error) {
+	if err := qs.ensureRPCClient(ctx); err != nil {
+		return nil, err
+	}
+
+	return qs.rpcClient, nil
+}
+
 func (qs *QueueSession) ensureSender(ctx context.Context) error {
 	ctx, span := qs.startSpanFromContext(ctx, "sb.QueueSession.ensureSender")
 	defer span.End()
@@ -433,13 +488,7 @@ func (ss *SubscriptionSession) ReceiveDeferred(ctx context.Context, handler Hand
 		return err
 	}
 
-	for _, msg := range messages {
-		if err := handler.Handle(ctx, msg); err != nil {
-			tab.For(ctx).Error(err)
-			return err
-		}
-	}
-	return nil
+	return ss.builder.getEntity().handleDeferred(ctx, handler, mode, messages)
 }
 
 // Close the underlying connection to Service Bus
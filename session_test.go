@@ -45,3 +45,30 @@ func TestQueueSession_SessionID(t *testing.T) {
 	qs := NewQueueSession(builder, &sessionID)
 	assert.Equal(t, sessionID, *qs.sessionID)
 }
+
+func TestQueueSession_PeekRequiresSessionID(t *testing.T) {
+	qs := NewQueueSession(new(MockedBuilder), nil)
+	_, err := qs.Peek(context.Background())
+	assert.Error(t, err)
+
+	_, err = qs.PeekOne(context.Background())
+	assert.Error(t, err)
+}
+
+func TestQueueSession_PeekRejectsOtherSession(t *testing.T) {
+	sessionID := "123"
+	builder := new(MockedBuilder)
+	builder.On("getEntity").Return(new(entity))
+	qs := NewQueueSession(builder, &sessionID)
+
+	_, err := qs.Peek(context.Background(), PeekWithSession("456"))
+	assert.Error(t, err)
+
+	_, err = qs.PeekOne(context.Background(), PeekWithSession("456"))
+	assert.Error(t, err)
+
+	it, err := qs.newPeekIterator(context.Background(), PeekWithSession(sessionID))
+	if assert.NoError(t, err) {
+		assert.Equal(t, sessionID, *it.sessionID)
+	}
+}
@@ -0,0 +1,1296 @@
+package servicebus
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Azure/azure-amqp-common-go/v3/uuid"
+)
+
+// This file evaluates the SQL filter and action expressions of subscription rules locally, following the grammar of
+// https://docs.microsoft.com/en-us/azure/service-bus-messaging/service-bus-messaging-sql-filter, so that rules can be
+// tested before they are put to Service Bus.
+//
+// Values are NULL, 64 bit integers, float64 numbers, strings, booleans and times. Predicates follow three-valued
+// logic: comparing NULL, such as a property the message does not have, yields unknown, and a filter only matches when
+// its expression is true.
+
+type (
+	sqlTokenKind int
+
+	sqlToken struct {
+		kind sqlTokenKind
+		text string
+		pos  int
+	}
+
+	sqlParser struct {
+		expression string
+		tokens     []sqlToken
+		next       int
+	}
+
+	// sqlExpr is a node of a parsed SQL expression
+	sqlExpr interface {
+		eval(msg *Message) (interface{}, error)
+	}
+
+	sqlLiteral struct {
+		value interface{}
+	}
+
+	sqlProperty struct {
+		system bool
+		// name is lower case for system properties
+		name string
+	}
+
+	sqlUnary struct {
+		op string
+		x  sqlExpr
+	}
+
+	sqlBinary struct {
+		op   string
+		x, y sqlExpr
+	}
+
+	sqlIsNull struct {
+		x   sqlExpr
+		not bool
+	}
+
+	sqlIn struct {
+		x    sqlExpr
+		list []sqlExpr
+		not  bool
+	}
+
+	sqlLike struct {
+		x       sqlExpr
+		pattern *regexp.Regexp
+		not     bool
+	}
+
+	sqlExists struct {
+		property *sqlProperty
+	}
+
+	sqlNewID struct{}
+
+	// sqlStatement is a SET or REMOVE statement of a SQL action; value is nil for REMOVE
+	sqlStatement struct {
+		property *sqlProperty
+		value    sqlExpr
+	}
+
+	// sqlSystemProperty reads, and for some properties writes, a system property of a message
+	sqlSystemProperty struct {
+		get func(*Message) interface{}
+		set func(*Message, *string)
+	}
+)
+
+const (
+	sqlEOF sqlTokenKind = iota
+	sqlIdentifier
+	sqlQuotedIdentifier
+	sqlInteger
+	sqlDecimal
+	sqlString
+	sqlOperator
+)
+
+var (
+	sqlKeywords = map[string]bool{
+		"AND": true, "OR": true, "NOT": true, "IS": true, "NULL": true, "IN": true, "LIKE": true, "ESCAPE": true,
+		"EXISTS": true, "TRUE": true, "FALSE": true, "SET": true, "REMOVE": true,
+	}
+
+	sqlOperators = []string{"<>", "!=", ">=", "<=", "=", ">", "<", "+", "-", "*", "/", "%", "(", ")", ",", ".", ";"}
+
+	sqlComparisons = map[string]bool{"=": true, "<>": true, "!=": true, ">": true, ">=": true, "<": true, "<=": true}
+
+	sqlSystemProperties = map[string]sqlSystemProperty{
+		"messageid": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.ID) },
+			set: func(m *Message, v *string) { m.ID = derefString(v) },
+		},
+		"correlationid": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.CorrelationID) },
+			set: func(m *Message, v *string) { m.CorrelationID = derefString(v) },
+		},
+		"to": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.To) },
+			set: func(m *Message, v *string) { m.To = derefString(v) },
+		},
+		"replyto": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.ReplyTo) },
+			set: func(m *Message, v *string) { m.ReplyTo = derefString(v) },
+		},
+		"label": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.Label) },
+			set: func(m *Message, v *string) { m.Label = derefString(v) },
+		},
+		"sessionid": {
+			get: func(m *Message) interface{} { return sqlValue(m.SessionID) },
+			set: func(m *Message, v *string) { m.SessionID = v },
+		},
+		"replytosessionid": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.ReplyToGroupID) },
+			set: func(m *Message, v *string) { m.ReplyToGroupID = derefString(v) },
+		},
+		"contenttype": {
+			get: func(m *Message) interface{} { return optionalSQLString(m.ContentType) },
+			set: func(m *Message, v *string) { m.ContentType = derefString(v) },
+		},
+		"deliverycount": {
+			get: func(m *Message) interface{} { return int64(m.DeliveryCount) },
+		},
+		"sequencenumber": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.SequenceNumber)
+			},
+		},
+		"enqueuedsequencenumber": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.EnqueuedSequenceNumber)
+			},
+		},
+		"enqueuedtimeutc": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.EnqueuedTime)
+			},
+		},
+		"scheduledenqueuetimeutc": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.ScheduledEnqueueTime)
+			},
+		},
+		"lockeduntil": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.LockedUntil)
+			},
+		},
+		"partitionkey": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.PartitionKey)
+			},
+		},
+		"viapartitionkey": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.ViaPartitionKey)
+			},
+		},
+		"deadlettersource": {
+			get: func(m *Message) interface{} {
+				if m.SystemProperties == nil {
+					return nil
+				}
+				return sqlValue(m.SystemProperties.DeadLetterSource)
+			},
+		},
+	}
+)
+
+// MatchFilter reports whether filter selects msg, evaluating it locally as Service Bus would.
+func MatchFilter(filter FilterDescriber, msg *Message) (bool, error) {
+	return filter.ToFilterDescription().Match(msg)
+}
+
+// Match reports whether the filter selects msg, evaluating it locally as Service Bus would. True, false, SQL and
+// correlation filters are supported.
+func (fd FilterDescription) Match(msg *Message) (bool, error) {
+	switch fd.Type {
+	case "TrueFilter":
+		return true, nil
+	case "FalseFilter":
+		return false, nil
+	case "SqlFilter":
+		if fd.SQLExpression == nil {
+			return false, fmt.Errorf("SQL filter has no expression")
+		}
+		return SQLFilter{Expression: *fd.SQLExpression}.Match(msg)
+	case "CorrelationFilter":
+		return fd.CorrelationFilter.Match(msg), nil
+	default:
+		return false, fmt.Errorf("filters of type %q cannot be evaluated locally", fd.Type)
+	}
+}
+
+// Apply applies the action to msg, as Service Bus would to the copy of a message selected into a subscription. SQL
+// rule actions and empty rule actions are supported.
+func (ad ActionDescription) Apply(msg *Message) error {
+	switch ad.Type {
+	case "", "EmptyRuleAction":
+		return nil
+	case "SqlRuleAction":
+		return SQLAction{Expression: ad.SQLExpression}.Apply(msg)
+	default:
+		return fmt.Errorf("actions of type %q cannot be applied locally", ad.Type)
+	}
+}
+
+// Validate parses the expression of the filter, returning an ErrSQLSyntax if it is invalid.
+func (sf SQLFilter) Validate() error {
+	_, err := parseSQLFilter(sf.Expression)
+	return err
+}
+
+// Match reports whether the filter selects msg, evaluating its expression locally as Service Bus would. An error is
+// returned if the expression is invalid, or cannot be evaluated against msg, such as when it divides by zero.
+func (sf SQLFilter) Match(msg *Message) (bool, error) {
+	expr, err := parseSQLFilter(sf.Expression)
+	if err != nil {
+		return false, err
+	}
+
+	v, err := expr.eval(msg)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return v, nil
+	default:
+		return false, fmt.Errorf("SQL filter %q evaluates to %v rather than to a boolean", sf.Expression, v)
+	}
+}
+
+// Validate parses the expression of the action, returning an ErrSQLSyntax if it is invalid.
+func (sa SQLAction) Validate() error {
+	_, err := parseSQLAction(sa.Expression)
+	return err
+}
+
+// Apply runs the SET and REMOVE statements of the action against msg, in order, modifying its user properties and the
+// system properties which can be set: MessageId, CorrelationId, To, ReplyTo, Label, SessionId, ReplyToSessionId and
+// ContentType. Clone msg beforehand to keep the original.
+func (sa SQLAction) Apply(msg *Message) error {
+	statements, err := parseSQLAction(sa.Expression)
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range statements {
+		if err := statement.apply(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s sqlStatement) apply(msg *Message) error {
+	p := s.property
+	if s.value == nil {
+		if key, _, ok := lookupUserProperty(msg.UserProperties, p.name); ok {
+			delete(msg.UserProperties, key)
+		}
+		return nil
+	}
+
+	v, err := s.value.eval(msg)
+	if err != nil {
+		return err
+	}
+
+	if p.system {
+		switch v := v.(type) {
+		case nil:
+			sqlSystemProperties[p.name].set(msg, nil)
+		case string:
+			sqlSystemProperties[p.name].set(msg, &v)
+		default:
+			return fmt.Errorf("system property %s can only be set to a string, but was set to %v", p.name, v)
+		}
+		return nil
+	}
+
+	if msg.UserProperties == nil {
+		msg.UserProperties = make(map[string]interface{})
+	}
+
+	key := p.name
+	if existing, _, ok := lookupUserProperty(msg.UserProperties, p.name); ok {
+		key = existing
+	}
+	msg.UserProperties[key] = v
+	return nil
+}
+
+func parseSQLFilter(expression string) (sqlExpr, error) {
+	p, err := newSQLParser(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.kind != sqlEOF {
+		return nil, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return expr, nil
+}
+
+func parseSQLAction(expression string) ([]sqlStatement, error) {
+	p, err := newSQLParser(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []sqlStatement
+	for {
+		tok := p.peek()
+		if tok.kind == sqlEOF {
+			break
+		}
+
+		switch {
+		case p.acceptKeyword("SET"):
+			property, err := p.parseProperty()
+			if err != nil {
+				return nil, err
+			}
+
+			if property.system && sqlSystemProperties[property.name].set == nil {
+				return nil, p.errorf(tok, "system property %s cannot be set", property.name)
+			}
+
+			if err := p.expectOperator("="); err != nil {
+				return nil, err
+			}
+
+			value, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, sqlStatement{property: property, value: value})
+		case p.acceptKeyword("REMOVE"):
+			property, err := p.parseProperty()
+			if err != nil {
+				return nil, err
+			}
+
+			if property.system {
+				return nil, p.errorf(tok, "system property %s cannot be removed", property.name)
+			}
+			statements = append(statements, sqlStatement{property: property})
+		default:
+			return nil, p.errorf(tok, "expected SET or REMOVE, but found %q", tok.text)
+		}
+
+		if !p.acceptOperator(";") {
+			if tok := p.peek(); tok.kind != sqlEOF {
+				return nil, p.errorf(tok, "expected ; between statements, but found %q", tok.text)
+			}
+		}
+	}
+
+	if len(statements) == 0 {
+		return nil, ErrSQLSyntax{Expression: expression, Reason: "action has no statement"}
+	}
+	return statements, nil
+}
+
+func newSQLParser(expression string) (*sqlParser, error) {
+	tokens, err := lexSQL(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlParser{expression: expression, tokens: tokens}, nil
+}
+
+// lexSQL splits a SQL expression into tokens, ending with an EOF token.
+func lexSQL(expression string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	fail := func(pos int, reason string) error {
+		return ErrSQLSyntax{Expression: expression, Position: pos, Reason: reason}
+	}
+
+	i := 0
+	for i < len(expression) {
+		r, size := utf8.DecodeRuneInString(expression[i:])
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '\'':
+			var sb strings.Builder
+			i++
+			for {
+				end := strings.IndexByte(expression[i:], '\'')
+				if end < 0 {
+					return nil, fail(start, "unterminated string")
+				}
+				sb.WriteString(expression[i : i+end])
+				i += end + 1
+				// a quote is escaped by doubling it
+				if i < len(expression) && expression[i] == '\'' {
+					sb.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			tokens = append(tokens, sqlToken{kind: sqlString, text: sb.String(), pos: start})
+		case r == '[':
+			end := strings.IndexByte(expression[i:], ']')
+			if end < 0 {
+				return nil, fail(start, "unterminated quoted identifier")
+			}
+			name := expression[i+1 : i+end]
+			if name == "" {
+				return nil, fail(start, "empty quoted identifier")
+			}
+			tokens = append(tokens, sqlToken{kind: sqlQuotedIdentifier, text: name, pos: start})
+			i += end + 1
+		case r >= '0' && r <= '9' || r == '.' && i+1 < len(expression) && expression[i+1] >= '0' && expression[i+1] <= '9':
+			kind := sqlInteger
+			for i < len(expression) && expression[i] >= '0' && expression[i] <= '9' {
+				i++
+			}
+			if i < len(expression) && expression[i] == '.' {
+				kind = sqlDecimal
+				i++
+				for i < len(expression) && expression[i] >= '0' && expression[i] <= '9' {
+					i++
+				}
+			}
+			if i < len(expression) && (expression[i] == 'e' || expression[i] == 'E') {
+				kind = sqlDecimal
+				i++
+				if i < len(expression) && (expression[i] == '+' || expression[i] == '-') {
+					i++
+				}
+				digits := i
+				for i < len(expression) && expression[i] >= '0' && expression[i] <= '9' {
+					i++
+				}
+				if i == digits {
+					return nil, fail(start, "malformed number")
+				}
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: expression[start:i], pos: start})
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(expression) {
+				r, size := utf8.DecodeRuneInString(expression[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, sqlToken{kind: sqlIdentifier, text: expression[start:i], pos: start})
+		default:
+			op := ""
+			for _, candidate := range sqlOperators {
+				if strings.HasPrefix(expression[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fail(start, fmt.Sprintf("unexpected character %q", r))
+			}
+			tokens = append(tokens, sqlToken{kind: sqlOperator, text: op, pos: start})
+			i += len(op)
+		}
+	}
+	return append(tokens, sqlToken{kind: sqlEOF, text: "end of expression", pos: len(expression)}), nil
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.next]
+}
+
+func (p *sqlParser) peekAt(offset int) sqlToken {
+	if p.next+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.next+offset]
+}
+
+func (p *sqlParser) advance() sqlToken {
+	tok := p.tokens[p.next]
+	if tok.kind != sqlEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *sqlParser) errorf(tok sqlToken, format string, args ...interface{}) error {
+	return ErrSQLSyntax{Expression: p.expression, Position: tok.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func isSQLKeyword(tok sqlToken, keyword string) bool {
+	return tok.kind == sqlIdentifier && strings.EqualFold(tok.text, keyword)
+}
+
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if isSQLKeyword(p.peek(), keyword) {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		tok := p.peek()
+		return p.errorf(tok, "expected %s, but found %q", keyword, tok.text)
+	}
+	return nil
+}
+
+func (p *sqlParser) acceptOperator(op string) bool {
+	if tok := p.peek(); tok.kind == sqlOperator && tok.text == op {
+		p.advance()
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectOperator(op string) error {
+	if !p.acceptOperator(op) {
+		tok := p.peek()
+		return p.errorf(tok, "expected %q, but found %q", op, tok.text)
+	}
+	return nil
+}
+
+func (p *sqlParser) parseOr() (sqlExpr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = &sqlBinary{op: "OR", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *sqlParser) parseAnd() (sqlExpr, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = &sqlBinary{op: "AND", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *sqlParser) parseNot() (sqlExpr, error) {
+	if p.acceptKeyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlUnary{op: "NOT", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *sqlParser) parseComparison() (sqlExpr, error) {
+	x, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == sqlOperator && sqlComparisons[tok.text]:
+		p.advance()
+		y, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlBinary{op: tok.text, x: x, y: y}, nil
+	case isSQLKeyword(tok, "IS"):
+		p.advance()
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &sqlIsNull{x: x, not: not}, nil
+	case isSQLKeyword(tok, "NOT") && (isSQLKeyword(p.peekAt(1), "IN") || isSQLKeyword(p.peekAt(1), "LIKE")):
+		p.advance()
+		return p.parseInOrLike(x, true)
+	case isSQLKeyword(tok, "IN") || isSQLKeyword(tok, "LIKE"):
+		return p.parseInOrLike(x, false)
+	}
+	return x, nil
+}
+
+func (p *sqlParser) parseInOrLike(x sqlExpr, not bool) (sqlExpr, error) {
+	if p.acceptKeyword("IN") {
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+
+		in := &sqlIn{x: x, not: not}
+		for {
+			item, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			in.list = append(in.list, item)
+
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+		return in, p.expectOperator(")")
+	}
+
+	if err := p.expectKeyword("LIKE"); err != nil {
+		return nil, err
+	}
+
+	patternTok := p.advance()
+	if patternTok.kind != sqlString {
+		return nil, p.errorf(patternTok, "LIKE expects a string pattern, but found %q", patternTok.text)
+	}
+
+	var escape rune
+	if p.acceptKeyword("ESCAPE") {
+		escapeTok := p.advance()
+		if escapeTok.kind != sqlString || utf8.RuneCountInString(escapeTok.text) != 1 {
+			return nil, p.errorf(escapeTok, "ESCAPE expects a string of one character, but found %q", escapeTok.text)
+		}
+		escape, _ = utf8.DecodeRuneInString(escapeTok.text)
+	}
+
+	pattern, err := compileSQLLikePattern(patternTok.text, escape)
+	if err != nil {
+		return nil, p.errorf(patternTok, "%v", err)
+	}
+	return &sqlLike{x: x, pattern: pattern, not: not}, nil
+}
+
+func (p *sqlParser) parseAdditive() (sqlExpr, error) {
+	x, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.kind != sqlOperator || (tok.text != "+" && tok.text != "-") {
+			return x, nil
+		}
+		p.advance()
+
+		y, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		x = &sqlBinary{op: tok.text, x: x, y: y}
+	}
+}
+
+func (p *sqlParser) parseMultiplicative() (sqlExpr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok := p.peek()
+		if tok.kind != sqlOperator || (tok.text != "*" && tok.text != "/" && tok.text != "%") {
+			return x, nil
+		}
+		p.advance()
+
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = &sqlBinary{op: tok.text, x: x, y: y}
+	}
+}
+
+func (p *sqlParser) parseUnary() (sqlExpr, error) {
+	tok := p.peek()
+	if tok.kind == sqlOperator && (tok.text == "+" || tok.text == "-") {
+		p.advance()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &sqlUnary{op: tok.text, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *sqlParser) parsePrimary() (sqlExpr, error) {
+	tok := p.peek()
+	switch tok.kind {
+	case sqlString:
+		p.advance()
+		return &sqlLiteral{value: tok.text}, nil
+	case sqlInteger:
+		p.advance()
+		i, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "integer %s is out of range", tok.text)
+		}
+		return &sqlLiteral{value: i}, nil
+	case sqlDecimal:
+		p.advance()
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, p.errorf(tok, "number %s is out of range", tok.text)
+		}
+		return &sqlLiteral{value: f}, nil
+	case sqlOperator:
+		if p.acceptOperator("(") {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expectOperator(")")
+		}
+	case sqlIdentifier:
+		switch {
+		case p.acceptKeyword("TRUE"):
+			return &sqlLiteral{value: true}, nil
+		case p.acceptKeyword("FALSE"):
+			return &sqlLiteral{value: false}, nil
+		case p.acceptKeyword("NULL"):
+			return &sqlLiteral{value: nil}, nil
+		case p.acceptKeyword("EXISTS"):
+			if err := p.expectOperator("("); err != nil {
+				return nil, err
+			}
+			property, err := p.parseProperty()
+			if err != nil {
+				return nil, err
+			}
+			return &sqlExists{property: property}, p.expectOperator(")")
+		}
+
+		if next := p.peekAt(1); next.kind == sqlOperator && next.text == "(" {
+			if !strings.EqualFold(tok.text, "newid") {
+				return nil, p.errorf(tok, "unknown function %s", tok.text)
+			}
+			p.advance()
+			p.advance()
+			return &sqlNewID{}, p.expectOperator(")")
+		}
+		return p.parseProperty()
+	case sqlQuotedIdentifier:
+		return p.parseProperty()
+	}
+	return nil, p.errorf(tok, "unexpected %q", tok.text)
+}
+
+// parseProperty parses a property reference: a name, optionally scoped by sys. or user., and optionally quoted in
+// square brackets. Unscoped names refer to user properties.
+func (p *sqlParser) parseProperty() (*sqlProperty, error) {
+	tok := p.advance()
+	if !isSQLName(tok) {
+		return nil, p.errorf(tok, "expected a property name, but found %q", tok.text)
+	}
+
+	next := p.peek()
+	if tok.kind != sqlIdentifier || next.kind != sqlOperator || next.text != "." {
+		return &sqlProperty{name: tok.text}, nil
+	}
+
+	scope := strings.ToLower(tok.text)
+	if scope != "sys" && scope != "user" {
+		return nil, p.errorf(tok, "unknown property scope %s", tok.text)
+	}
+	p.advance()
+
+	nameTok := p.advance()
+	if !isSQLName(nameTok) {
+		return nil, p.errorf(nameTok, "expected a property name, but found %q", nameTok.text)
+	}
+
+	if scope == "user" {
+		return &sqlProperty{name: nameTok.text}, nil
+	}
+
+	name := strings.ToLower(nameTok.text)
+	if _, ok := sqlSystemProperties[name]; !ok {
+		return nil, p.errorf(nameTok, "unknown system property %s", nameTok.text)
+	}
+	return &sqlProperty{system: true, name: name}, nil
+}
+
+func isSQLName(tok sqlToken) bool {
+	return tok.kind == sqlQuotedIdentifier || tok.kind == sqlIdentifier && !sqlKeywords[strings.ToUpper(tok.text)]
+}
+
+// compileSQLLikePattern compiles a LIKE pattern, in which % stands for any sequence of characters and _ for any single
+// character, unless preceded by the escape character.
+func compileSQLLikePattern(pattern string, escape rune) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != 0 && r == escape:
+			escaped = true
+		case r == '%':
+			sb.WriteString(".*")
+		case r == '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("LIKE pattern %q ends with its escape character", pattern)
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+func (l *sqlLiteral) eval(*Message) (interface{}, error) {
+	return l.value, nil
+}
+
+func (p *sqlProperty) eval(msg *Message) (interface{}, error) {
+	if p.system {
+		return sqlSystemProperties[p.name].get(msg), nil
+	}
+
+	_, v, _ := lookupUserProperty(msg.UserProperties, p.name)
+	return sqlValue(v), nil
+}
+
+func (u *sqlUnary) eval(msg *Message) (interface{}, error) {
+	x, err := u.x.eval(msg)
+	if err != nil || x == nil {
+		return nil, err
+	}
+
+	switch x := x.(type) {
+	case bool:
+		if u.op == "NOT" {
+			return !x, nil
+		}
+	case int64:
+		switch u.op {
+		case "-":
+			return -x, nil
+		case "+":
+			return x, nil
+		}
+	case float64:
+		switch u.op {
+		case "-":
+			return -x, nil
+		case "+":
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s cannot be applied to %v", u.op, x)
+}
+
+func (b *sqlBinary) eval(msg *Message) (interface{}, error) {
+	x, err := b.x.eval(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.op == "AND" || b.op == "OR" {
+		return b.evalLogical(msg, x)
+	}
+
+	y, err := b.y.eval(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if sqlComparisons[b.op] {
+		return sqlCompare(b.op, x, y)
+	}
+	return sqlArithmetic(b.op, x, y)
+}
+
+// evalLogical evaluates AND and OR in three-valued logic, where nil is unknown.
+func (b *sqlBinary) evalLogical(msg *Message, x interface{}) (interface{}, error) {
+	xb, err := sqlBoolean(b.op, x)
+	if err != nil {
+		return nil, err
+	}
+
+	// false AND anything is false, and true OR anything is true
+	if xb != nil && *xb == (b.op == "OR") {
+		return *xb, nil
+	}
+
+	y, err := b.y.eval(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	yb, err := sqlBoolean(b.op, y)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case yb != nil && *yb == (b.op == "OR"):
+		return *yb, nil
+	case xb == nil || yb == nil:
+		return nil, nil
+	default:
+		return *xb, nil
+	}
+}
+
+func sqlBoolean(op string, v interface{}) (*bool, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return &v, nil
+	default:
+		return nil, fmt.Errorf("operator %s cannot be applied to %v, which is not a boolean", op, v)
+	}
+}
+
+func (n *sqlIsNull) eval(msg *Message) (interface{}, error) {
+	x, err := n.x.eval(msg)
+	if err != nil {
+		return nil, err
+	}
+	return (x == nil) != n.not, nil
+}
+
+func (in *sqlIn) eval(msg *Message) (interface{}, error) {
+	x, err := in.x.eval(msg)
+	if err != nil || x == nil {
+		return nil, err
+	}
+
+	unknown := false
+	for _, item := range in.list {
+		y, err := item.eval(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		eq, err := sqlCompare("=", x, y)
+		if err != nil {
+			return nil, err
+		}
+
+		switch eq {
+		case true:
+			return !in.not, nil
+		case nil:
+			unknown = true
+		}
+	}
+
+	if unknown {
+		return nil, nil
+	}
+	return in.not, nil
+}
+
+func (l *sqlLike) eval(msg *Message) (interface{}, error) {
+	x, err := l.x.eval(msg)
+	if err != nil || x == nil {
+		return nil, err
+	}
+
+	s, ok := x.(string)
+	if !ok {
+		return nil, fmt.Errorf("LIKE cannot be applied to %v, which is not a string", x)
+	}
+	return l.pattern.MatchString(s) != l.not, nil
+}
+
+func (e *sqlExists) eval(msg *Message) (interface{}, error) {
+	if e.property.system {
+		return sqlSystemProperties[e.property.name].get(msg) != nil, nil
+	}
+
+	_, _, ok := lookupUserProperty(msg.UserProperties, e.property.name)
+	return ok, nil
+}
+
+func (*sqlNewID) eval(*Message) (interface{}, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	return id.String(), nil
+}
+
+// sqlCompare compares two values, returning nil, for unknown, if either is NULL or if they are of different types.
+func sqlCompare(op string, x, y interface{}) (interface{}, error) {
+	if x == nil || y == nil {
+		return nil, nil
+	}
+
+	var cmp int
+	switch xv := x.(type) {
+	case int64:
+		switch yv := y.(type) {
+		case int64:
+			switch {
+			case xv < yv:
+				cmp = -1
+			case xv > yv:
+				cmp = 1
+			}
+		case float64:
+			cmp = compareOrdered(float64(xv), yv)
+		default:
+			return nil, nil
+		}
+	case float64:
+		switch yv := y.(type) {
+		case int64:
+			cmp = compareOrdered(xv, float64(yv))
+		case float64:
+			cmp = compareOrdered(xv, yv)
+		default:
+			return nil, nil
+		}
+	case string:
+		yv, ok := y.(string)
+		if !ok {
+			return nil, nil
+		}
+		cmp = strings.Compare(xv, yv)
+	case time.Time:
+		yv, ok := y.(time.Time)
+		if !ok {
+			return nil, nil
+		}
+		switch {
+		case xv.Before(yv):
+			cmp = -1
+		case xv.After(yv):
+			cmp = 1
+		}
+	default:
+		if reflect.TypeOf(x) != reflect.TypeOf(y) {
+			return nil, nil
+		}
+
+		switch op {
+		case "=":
+			return reflect.DeepEqual(x, y), nil
+		case "<>", "!=":
+			return !reflect.DeepEqual(x, y), nil
+		default:
+			return nil, fmt.Errorf("operator %s cannot be applied to %v and %v", op, x, y)
+		}
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0, nil
+	case "<>", "!=":
+		return cmp != 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	default:
+		return cmp <= 0, nil
+	}
+}
+
+func compareOrdered(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// sqlArithmetic applies an arithmetic operator, returning nil if either value is NULL. Integers stay integers, unless
+// combined with a float; strings can be concatenated with +.
+func sqlArithmetic(op string, x, y interface{}) (interface{}, error) {
+	if x == nil || y == nil {
+		return nil, nil
+	}
+
+	xi, xIsInt := x.(int64)
+	yi, yIsInt := y.(int64)
+	if xIsInt && yIsInt {
+		switch op {
+		case "+":
+			return xi + yi, nil
+		case "-":
+			return xi - yi, nil
+		case "*":
+			return xi * yi, nil
+		case "/", "%":
+			if yi == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return xi / yi, nil
+			}
+			return xi % yi, nil
+		}
+	}
+
+	xf, xIsNumber := sqlFloat(x)
+	yf, yIsNumber := sqlFloat(y)
+	if xIsNumber && yIsNumber {
+		switch op {
+		case "+":
+			return xf + yf, nil
+		case "-":
+			return xf - yf, nil
+		case "*":
+			return xf * yf, nil
+		case "/", "%":
+			if yf == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return xf / yf, nil
+			}
+			return math.Mod(xf, yf), nil
+		}
+	}
+
+	if xs, ok := x.(string); ok && op == "+" {
+		if ys, ok := y.(string); ok {
+			return xs + ys, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s cannot be applied to %v and %v", op, x, y)
+}
+
+func sqlFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// sqlValue converts a property value into the SQL type it is evaluated as: integers become int64, floats float64,
+// and pointers are dereferenced. Values of other types are left as is.
+func sqlValue(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+		v = rv.Interface()
+	}
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if u := rv.Uint(); u <= math.MaxInt64 {
+			return int64(u)
+		}
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return rv.Bool()
+	}
+	return v
+}
+
+func optionalSQLString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// lookupUserProperty finds a user property by name, preferring an exact match over a case-insensitive one.
+func lookupUserProperty(props map[string]interface{}, name string) (string, interface{}, bool) {
+	if v, ok := props[name]; ok {
+		return name, v, true
+	}
+
+	for key, v := range props {
+		if strings.EqualFold(key, name) {
+			return key, v, true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,223 @@
+package servicebus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLFilter_Match(t *testing.T) {
+	enqueued := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	seq := int64(42)
+	sessionID := "session-1"
+	msg := &Message{
+		ID:            "id-1",
+		Label:         "order.created",
+		ContentType:   "application/json",
+		SessionID:     &sessionID,
+		DeliveryCount: 2,
+		SystemProperties: &SystemProperties{
+			SequenceNumber: &seq,
+			EnqueuedTime:   &enqueued,
+		},
+		UserProperties: map[string]interface{}{
+			"color":    "red",
+			"Quantity": int32(5),
+			"price":    2.5,
+			"priority": uint8(1),
+			"urgent":   true,
+			"note":     "50% off_now",
+		},
+	}
+
+	cases := map[string]bool{
+		"1=1":                                     true,
+		"sys.Label = 'order.created'":             true,
+		"sys.label LIKE 'order.%'":                true,
+		"sys.Label LIKE 'order._reated'":          true,
+		"sys.Label NOT LIKE 'invoice%'":           true,
+		"sys.To = 'somewhere'":                    false,
+		"sys.To IS NULL":                          true,
+		"sys.SessionId = 'session-1'":             true,
+		"sys.DeliveryCount > 1":                   true,
+		"sys.SequenceNumber = 42":                 true,
+		"sys.ReplyTo IS NOT NULL":                 false,
+		"color = 'red'":                           true,
+		"user.color = 'red' AND quantity >= 5":    true,
+		"[color] IN ('blue', 'red')":              true,
+		"color NOT IN ('blue', 'red')":            false,
+		"quantity * price = 12.5":                 true,
+		"quantity / 2 = 2":                        true,
+		"quantity % 2 = 1":                        true,
+		"-quantity + 10 = 5":                      true,
+		"quantity - 1.5 > 3":                      true,
+		"color + 'dish' = 'reddish'":              true,
+		"urgent":                                  true,
+		"NOT urgent OR priority = 1":              true,
+		"EXISTS(color) AND NOT EXISTS(size)":      true,
+		"EXISTS(sys.ReplyTo)":                     false,
+		"size = 'L'":                              false,
+		"NOT size = 'L'":                          false,
+		"size = 'L' OR color = 'red'":             true,
+		"size = 'L' AND color = 'red'":            false,
+		"size IN ('L', 'M')":                      false,
+		"color IN ('blue', size)":                 false,
+		"note LIKE '50!% off!_%' ESCAPE '!'":      true,
+		"note LIKE '50!%off%' ESCAPE '!'":         false,
+		"color = 1":                               false,
+		"(quantity > 1 OR size > 1) AND urgent":   true,
+		"color = 'it''s'":                         false,
+		"quantity = 5.0":                          true,
+		"price > 2e0 and PRICE < .3E1":            true,
+		"NOT (size = 'L') OR TRUE":                true,
+		"sys.Label <> 'invoice' and color != 'b'": true,
+	}
+
+	for expr, expected := range cases {
+		expr, expected := expr, expected
+		t.Run(expr, func(t *testing.T) {
+			actual, err := SQLFilter{Expression: expr}.Match(msg)
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestSQLFilter_MatchTime(t *testing.T) {
+	enqueued := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := &Message{
+		SystemProperties: &SystemProperties{EnqueuedTime: &enqueued},
+		UserProperties:   map[string]interface{}{"deadline": enqueued.Add(time.Hour)},
+	}
+
+	matched, err := SQLFilter{Expression: "sys.EnqueuedTimeUtc < deadline"}.Match(msg)
+	require.NoError(t, err)
+	assert.True(t, matched)
+}
+
+func TestSQLFilter_MatchErrors(t *testing.T) {
+	msg := &Message{UserProperties: map[string]interface{}{"quantity": 5, "color": "red"}}
+
+	for _, expr := range []string{
+		"quantity / 0 = 1",
+		"quantity % 0 = 1",
+		"quantity",
+		"color AND TRUE",
+		"quantity LIKE '5'",
+		"-color = 1",
+		"color * 2 = 1",
+	} {
+		_, err := SQLFilter{Expression: expr}.Match(msg)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSQLFilter_Validate(t *testing.T) {
+	valid := []string{
+		"1=1",
+		"sys.Label = 'a' AND user.[my property] IS NOT NULL",
+		"a IN (1, 2.5, 'c') OR b NOT LIKE 'x%' ESCAPE '\\'",
+		"newid() IS NOT NULL",
+	}
+	for _, expr := range valid {
+		assert.NoError(t, SQLFilter{Expression: expr}.Validate(), expr)
+	}
+
+	invalid := map[string]int{
+		"":                  0,
+		"a =":               3,
+		"a = 'open":         4,
+		"sys.Unknown = 1":   4,
+		"other.a = 1":       0,
+		"a = 1 b":           6,
+		"a IN 1":            5,
+		"a LIKE b":          7,
+		"(a = 1":            6,
+		"a = #":             4,
+		"foo() = 1":         0,
+		"a LIKE 'x' ESCAPE": 17,
+		"AND = 1":           0,
+	}
+	for expr, position := range invalid {
+		err := SQLFilter{Expression: expr}.Validate()
+		var syntaxErr ErrSQLSyntax
+		if assert.True(t, errors.As(err, &syntaxErr), expr) {
+			assert.Equal(t, position, syntaxErr.Position, expr)
+			assert.Equal(t, expr, syntaxErr.Expression)
+		}
+	}
+}
+
+func TestSQLAction_Apply(t *testing.T) {
+	msg := &Message{
+		Label:          "order",
+		UserProperties: map[string]interface{}{"Quantity": 2, "color": "red", "internal": true},
+	}
+
+	action := SQLAction{Expression: "SET quantity = quantity * 10; SET sys.Label = sys.Label + '.large'; " +
+		"SET routed = TRUE; REMOVE internal; SET sys.SessionId = color"}
+	require.NoError(t, action.Validate())
+	require.NoError(t, action.Apply(msg))
+
+	assert.Equal(t, "order.large", msg.Label)
+	require.NotNil(t, msg.SessionID)
+	assert.Equal(t, "red", *msg.SessionID)
+	assert.Equal(t, map[string]interface{}{"Quantity": int64(20), "color": "red", "routed": true}, msg.UserProperties)
+}
+
+func TestSQLAction_ApplyToMessageWithoutProperties(t *testing.T) {
+	msg := &Message{}
+	require.NoError(t, SQLAction{Expression: "SET a = 1; REMOVE b"}.Apply(msg))
+	assert.Equal(t, map[string]interface{}{"a": int64(1)}, msg.UserProperties)
+}
+
+func TestSQLAction_Validate(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"SET",
+		"SET a",
+		"SET a = ",
+		"SET a = 1 SET b = 2",
+		"REMOVE sys.Label",
+		"SET sys.DeliveryCount = 1",
+		"DELETE a",
+	} {
+		var syntaxErr ErrSQLSyntax
+		assert.True(t, errors.As(SQLAction{Expression: expr}.Validate(), &syntaxErr), expr)
+	}
+
+	assert.Error(t, SQLAction{Expression: "SET sys.Label = 1"}.Apply(&Message{}))
+}
+
+func TestMatchFilter(t *testing.T) {
+	msg := &Message{Label: "a", UserProperties: map[string]interface{}{"color": "red"}}
+
+	cases := []struct {
+		filter   FilterDescriber
+		expected bool
+	}{
+		{filter: TrueFilter{}, expected: true},
+		{filter: FalseFilter{}, expected: false},
+		{filter: SQLFilter{Expression: "color = 'red'"}, expected: true},
+		{filter: CorrelationFilter{Label: ptrString("a")}, expected: true},
+		{filter: CorrelationFilter{Label: ptrString("b")}, expected: false},
+	}
+
+	for _, c := range cases {
+		matched, err := MatchFilter(c.filter, msg)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, matched, "%#v", c.filter)
+	}
+}
+
+func TestActionDescription_Apply(t *testing.T) {
+	msg := &Message{}
+	require.NoError(t, ActionDescription{}.Apply(msg))
+	require.NoError(t, (&SQLAction{Expression: "SET a = 'b'"}).ToActionDescription().Apply(msg))
+	assert.Equal(t, "b", msg.UserProperties["a"])
+
+	assert.Error(t, ActionDescription{Type: "UnknownAction"}.Apply(msg))
+}
@@ -24,6 +24,7 @@ package servicebus
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"sync"
 
@@ -36,17 +37,23 @@ type (
 	//Messages are received from a subscription identically to the way they are received from a queue.
 	Subscription struct {
 		*receivingEntity
-		Topic         *Topic
-		receiver      *Receiver
-		receiverMu    sync.Mutex
-		receiveMode   ReceiveMode
-		prefetchCount *uint32
+		Topic           *Topic
+		receiver        *Receiver
+		receiverMu      sync.Mutex
+		receiveMode     ReceiveMode
+		prefetchCount   *uint32
+		receiverOptions []ReceiverOption
 	}
 
 	// SubscriptionOption configures the Subscription Azure Service Bus client
 	SubscriptionOption func(*Subscription) error
 )
 
+const (
+	// number of rules read per request by GetRules
+	rulesPageSize = 100
+)
+
 // SubscriptionWithReceiveAndDelete configures a subscription to pop and delete messages off of the queue upon receiving the message.
 // This differs from the default, PeekLock, where PeekLock receives a message, locks it for a period of time, then sends
 // a disposition to the broker when the message has been processed.
@@ -137,6 +144,7 @@ func (s *Subscription) NewReceiver(ctx context.Context, opts ...ReceiverOption)
 	defer span.End()
 
 	opts = append(opts, ReceiverWithReceiveMode(s.receiveMode))
+	opts = append(opts, s.receiverOptions...)
 
 	if s.prefetchCount != nil {
 		opts = append(opts, ReceiverWithPrefetchCount(*s.prefetchCount))
@@ -200,6 +208,78 @@ func (s *Subscription) NewTransferDeadLetterReceiver(ctx context.Context, opts .
 	return s.namespace.NewReceiver(ctx, transferDeadLetterEntityPath, opts...)
 }
 
+// AddRule adds a rule to the subscription over the AMQP $management link, selecting into the subscription the topic
+// messages filter matches, and applying action to the copy of each message selected. action may be nil for a rule
+// without an action. True, false, SQL and correlation filters are supported, along with SQL actions.
+//
+// Unlike SubscriptionManager.PutRule, AddRule only needs the AMQP connection of the namespace, so it works over
+// websockets without any HTTP access to the management API.
+func (s *Subscription) AddRule(ctx context.Context, name string, filter FilterDescriber, action ActionDescriber) error {
+	ctx, span := s.startSpanFromContext(ctx, "sb.Subscription.AddRule")
+	defer span.End()
+
+	if name == "" {
+		return errors.New("rule name must not be empty")
+	}
+
+	if filter == nil {
+		return errors.New("filter must not be nil")
+	}
+
+	var ad *ActionDescription
+	if action != nil {
+		description := action.ToActionDescription()
+		ad = &description
+	}
+
+	client, err := s.entity.GetRPCClient(ctx)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+	return client.AddRule(ctx, name, filter.ToFilterDescription(), ad)
+}
+
+// RemoveRule removes the rule with name from the subscription over the AMQP $management link.
+func (s *Subscription) RemoveRule(ctx context.Context, name string) error {
+	ctx, span := s.startSpanFromContext(ctx, "sb.Subscription.RemoveRule")
+	defer span.End()
+
+	client, err := s.entity.GetRPCClient(ctx)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return err
+	}
+	return client.RemoveRule(ctx, name)
+}
+
+// GetRules returns every rule of the subscription, read over the AMQP $management link. The rules are described as
+// SubscriptionManager.ListRules describes them, except that they have no ID.
+func (s *Subscription) GetRules(ctx context.Context) ([]*RuleEntity, error) {
+	ctx, span := s.startSpanFromContext(ctx, "sb.Subscription.GetRules")
+	defer span.End()
+
+	client, err := s.entity.GetRPCClient(ctx)
+	if err != nil {
+		tab.For(ctx).Error(err)
+		return nil, err
+	}
+
+	var rules []*RuleEntity
+	for skip := int32(0); ; skip += rulesPageSize {
+		page, err := client.GetRules(ctx, skip, rulesPageSize)
+		if err != nil {
+			tab.For(ctx).Error(err)
+			return nil, err
+		}
+
+		rules = append(rules, page...)
+		if len(page) < rulesPageSize {
+			return rules, nil
+		}
+	}
+}
+
 // Close the underlying connection to Service Bus
 func (s *Subscription) Close(ctx context.Context) error {
 	ctx, span := s.startSpanFromContext(ctx, "sb.Subscription.Close")
@@ -188,7 +188,7 @@ func ListSubscriptionsWithTop(top int) ListSubscriptionsOption {
 // NewSubscriptionManager creates a new SubscriptionManager for a Service Bus Topic
 func (t *Topic) NewSubscriptionManager() *SubscriptionManager {
 	return &SubscriptionManager{
-		entityManager: newEntityManager(t.namespace.getHTTPSHostURI(), t.namespace.TokenProvider),
+		entityManager: newNamespaceEntityManager(t.namespace),
 		Topic:         t,
 	}
 }
@@ -200,7 +200,7 @@ func (ns *Namespace) NewSubscriptionManager(topicName string) (*SubscriptionMana
 		return nil, err
 	}
 	return &SubscriptionManager{
-		entityManager: newEntityManager(t.namespace.getHTTPSHostURI(), t.namespace.TokenProvider),
+		entityManager: newNamespaceEntityManager(t.namespace),
 		Topic:         t,
 	}, nil
 }
@@ -417,26 +417,11 @@ func (sm *SubscriptionManager) putRule(ctx context.Context, subscriptionName, ru
 	ctx, span := sm.startSpanFromContext(ctx, "sb.SubscriptionManager.putRule")
 	defer span.End()
 
-	re := &ruleEntry{
-		Entry: &atom.Entry{
-			AtomSchema: atomSchema,
-		},
-		Content: &ruleContent{
-			Type:            applicationXML,
-			RuleDescription: *rd,
-		},
-	}
-
-	reqBytes, err := xml.Marshal(re)
+	reqBytes, err := marshalRuleEntry(rd)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: fix the unmarshal / marshal of xml with this attribute or ask the service to fix it. This is sad, but works.
-	str := string(reqBytes)
-	str = strings.Replace(str, `xmlns:XMLSchema-instance="`+schemaInstance+`" XMLSchema-instance:type`, "i:type", -1)
-
-	reqBytes = xmlDoc([]byte(str))
 	res, err := sm.entityManager.Put(ctx, sm.getRuleResourceURI(subscriptionName, ruleName), reqBytes)
 	defer closeRes(ctx, res)
 
@@ -457,6 +442,33 @@ func (sm *SubscriptionManager) putRule(ctx context.Context, subscriptionName, ru
 	return ruleEntryToEntity(&entry), nil
 }
 
+// marshalRuleEntry builds the body of a request putting a rule
+func marshalRuleEntry(rd *RuleDescription) ([]byte, error) {
+	re := &ruleEntry{
+		Entry: &atom.Entry{
+			AtomSchema: atomSchema,
+		},
+		Content: &ruleContent{
+			Type:            applicationXML,
+			RuleDescription: *rd,
+		},
+	}
+
+	reqBytes, err := xml.Marshal(re)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: fix the unmarshal / marshal of xml with this attribute or ask the service to fix it. This is sad, but works.
+	str := string(reqBytes)
+	// newer versions of encoding/xml prefix the namespace with an underscore, as prefixes starting with xml are reserved
+	for _, prefix := range []string{"XMLSchema-instance", "_XMLSchema-instance"} {
+		str = strings.Replace(str, `xmlns:`+prefix+`="`+schemaInstance+`" `+prefix+`:type`, "i:type", -1)
+	}
+
+	return xmlDoc([]byte(str)), nil
+}
+
 // DeleteRule will delete a rule on the subscription
 func (sm *SubscriptionManager) DeleteRule(ctx context.Context, subscriptionName, ruleName string) error {
 	ctx, span := sm.startSpanFromContext(ctx, "sb.SubscriptionManager.DeleteRule")
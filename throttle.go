@@ -0,0 +1,288 @@
+package servicebus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-amqp"
+)
+
+type (
+	// throttle is a token bucket shared by every sender, receiver, RPC and management call of a Namespace. It limits
+	// the rate of messages and bytes to the maximums configured on the Namespace, and adapts the rate of messages to
+	// server-busy errors: the rate is halved when Service Bus reports it is busy, and grows back additively with each
+	// success until it is no longer limited.
+	//
+	// A nil throttle never limits anything.
+	throttle struct {
+		mu sync.Mutex
+
+		// configured limits, 0 when unlimited
+		maxRate     float64
+		maxByteRate float64
+
+		// adaptive limit of messages per second, 0 when Service Bus has not been busy
+		limit float64
+		// rate above which the adaptive limit is lifted
+		recoverTo    float64
+		lastDecrease time.Time
+
+		tokens     float64
+		byteTokens float64
+		last       time.Time
+
+		// rate of messages observed over the last full window, used as the starting point of the adaptive limit
+		windowStart  time.Time
+		windowCount  float64
+		observedRate float64
+
+		// replaceable for testing
+		now func() time.Time
+	}
+)
+
+const (
+	// the adaptive limit is multiplied by throttleDecrease on server-busy, at most once per throttleDecreaseInterval
+	throttleDecrease         = 0.5
+	throttleDecreaseInterval = time.Second
+	// the adaptive limit grows by throttleIncrease messages per second for each second spent at the limit
+	throttleIncrease = 5.0
+	// the adaptive limit never goes below throttleMinRate messages per second
+	throttleMinRate = 1.0
+	// the adaptive limit starts from throttleDefaultRate when no rate was observed before server-busy
+	throttleDefaultRate = 100.0
+
+	throttleWindow = time.Second
+)
+
+// NamespaceWithMaxRate limits the rate of messages sent and received through the namespace, as well as of RPC and
+// management calls, to messagesPerSecond. Bursts of up to a second's worth of messages are allowed.
+func NamespaceWithMaxRate(messagesPerSecond float64) NamespaceOption {
+	return func(ns *Namespace) error {
+		if messagesPerSecond <= 0 {
+			return fmt.Errorf("max rate must be positive, but was %v", messagesPerSecond)
+		}
+		ns.throttle.maxRate = messagesPerSecond
+		return nil
+	}
+}
+
+// NamespaceWithMaxByteRate limits the rate of message payload bytes sent through the namespace to bytesPerSecond.
+// Bursts of up to a second's worth of bytes are allowed, and a message larger than that waits for a full bucket.
+func NamespaceWithMaxByteRate(bytesPerSecond float64) NamespaceOption {
+	return func(ns *Namespace) error {
+		if bytesPerSecond <= 0 {
+			return fmt.Errorf("max byte rate must be positive, but was %v", bytesPerSecond)
+		}
+		ns.throttle.maxByteRate = bytesPerSecond
+		return nil
+	}
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		now: time.Now,
+	}
+}
+
+// wait blocks until messages and bytes may be sent, or until ctx is done. Tokens are reserved up front, so that
+// concurrent callers are served in turn.
+func (t *throttle) wait(ctx context.Context, messages, bytes int) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := t.now()
+	t.refill(now)
+	t.observe(now, float64(messages))
+
+	var delay time.Duration
+	if rate := t.rate(); rate > 0 {
+		t.tokens -= float64(messages)
+		if t.tokens < 0 {
+			delay = time.Duration(-t.tokens / rate * float64(time.Second))
+		}
+	}
+
+	if t.maxByteRate > 0 && bytes > 0 {
+		t.byteTokens -= float64(bytes)
+		if t.byteTokens < 0 {
+			if byteDelay := time.Duration(-t.byteTokens / t.maxByteRate * float64(time.Second)); byteDelay > delay {
+				delay = byteDelay
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// onServerBusy multiplicatively decreases the adaptive limit.
+func (t *throttle) onServerBusy() {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if now.Sub(t.lastDecrease) < throttleDecreaseInterval {
+		// every caller in flight sees the same busy period, which only warrants one decrease
+		return
+	}
+	t.lastDecrease = now
+
+	base := t.limit
+	if base == 0 {
+		base = t.observedRate
+		if t.maxRate > 0 && (base == 0 || base > t.maxRate) {
+			base = t.maxRate
+		}
+		if base == 0 {
+			base = throttleDefaultRate
+		}
+		t.recoverTo = base
+		t.tokens = 0
+		t.last = now
+	}
+
+	t.limit = base * throttleDecrease
+	if t.limit < throttleMinRate {
+		t.limit = throttleMinRate
+	}
+}
+
+// onSuccess additively increases the adaptive limit after messages went through, lifting it once it is back to the
+// rate it started from.
+func (t *throttle) onSuccess(messages int) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.limit == 0 {
+		return
+	}
+
+	// at the limit, this adds throttleIncrease messages per second for each second
+	t.limit += throttleIncrease * float64(messages) / t.limit
+	if t.limit >= t.recoverTo {
+		t.limit = 0
+	}
+}
+
+// currentRate returns the messages per second currently allowed, or 0 if unlimited.
+func (t *throttle) currentRate() float64 {
+	if t == nil {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rate()
+}
+
+// rate returns the messages per second allowed, or 0 if unlimited. Callers must hold the lock.
+func (t *throttle) rate() float64 {
+	rate := t.maxRate
+	if t.limit > 0 && (rate == 0 || t.limit < rate) {
+		rate = t.limit
+	}
+	return rate
+}
+
+// refill adds the tokens accrued since the last call, up to a second's worth. Callers must hold the lock.
+func (t *throttle) refill(now time.Time) {
+	if t.last.IsZero() {
+		t.tokens = t.rate()
+		t.byteTokens = t.maxByteRate
+		t.last = now
+		return
+	}
+
+	elapsed := now.Sub(t.last).Seconds()
+	t.last = now
+
+	if rate := t.rate(); rate > 0 {
+		t.tokens += elapsed * rate
+		if t.tokens > rate {
+			t.tokens = rate
+		}
+	}
+
+	if t.maxByteRate > 0 {
+		t.byteTokens += elapsed * t.maxByteRate
+		if t.byteTokens > t.maxByteRate {
+			t.byteTokens = t.maxByteRate
+		}
+	}
+}
+
+// observe records messages in the current window, rolling the window over once it is complete. Callers must hold the
+// lock.
+func (t *throttle) observe(now time.Time, messages float64) {
+	if elapsed := now.Sub(t.windowStart); elapsed >= throttleWindow {
+		if !t.windowStart.IsZero() {
+			t.observedRate = t.windowCount / elapsed.Seconds()
+		}
+		t.windowStart = now
+		t.windowCount = 0
+	}
+	t.windowCount += messages
+}
+
+// throttleMiddleware is a MiddlewareFunc making management calls consult the throttle, and reporting responses which
+// show Service Bus is busy to it.
+func (t *throttle) throttleMiddleware(next RestHandler) RestHandler {
+	return func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		if err := t.wait(ctx, 1, 0); err != nil {
+			return nil, err
+		}
+
+		res, err := next(ctx, req)
+		if err == nil {
+			switch res.StatusCode {
+			case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+				t.onServerBusy()
+			default:
+				t.onSuccess(1)
+			}
+		}
+		return res, err
+	}
+}
+
+// isServerBusy reports whether err is the server-busy error of Service Bus.
+func isServerBusy(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Condition == errorServerBusy
+}
+
+// payloadSize returns the number of payload bytes of an AMQP message.
+func payloadSize(msg *amqp.Message) int {
+	size := 0
+	for _, data := range msg.Data {
+		size += len(data)
+	}
+	return size
+}
@@ -0,0 +1,173 @@
+package servicebus
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestThrottle() (*throttle, *time.Time) {
+	now := time.Unix(1000, 0)
+	t := newThrottle()
+	t.now = func() time.Time { return now }
+	return t, &now
+}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestThrottle_Nil(t *testing.T) {
+	var th *throttle
+	assert.NoError(t, th.wait(cancelledContext(), 100, 100))
+	th.onServerBusy()
+	th.onSuccess(1)
+	assert.Equal(t, 0.0, th.currentRate())
+}
+
+func TestThrottle_UnlimitedByDefault(t *testing.T) {
+	th, _ := newTestThrottle()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, th.wait(cancelledContext(), 1, 1024))
+	}
+	assert.Equal(t, 0.0, th.currentRate())
+}
+
+func TestThrottle_MaxRate(t *testing.T) {
+	th, now := newTestThrottle()
+	th.maxRate = 10
+
+	// a second's worth of messages goes through at once
+	for i := 0; i < 10; i++ {
+		require.NoError(t, th.wait(cancelledContext(), 1, 0))
+	}
+	assert.Equal(t, context.Canceled, th.wait(cancelledContext(), 1, 0))
+
+	// the reserved message is owed, so the next one needs 200ms
+	*now = now.Add(100 * time.Millisecond)
+	assert.Equal(t, context.Canceled, th.wait(cancelledContext(), 1, 0))
+	*now = now.Add(300 * time.Millisecond)
+	assert.NoError(t, th.wait(cancelledContext(), 1, 0))
+}
+
+func TestThrottle_MaxByteRate(t *testing.T) {
+	th, now := newTestThrottle()
+	th.maxByteRate = 1000
+
+	require.NoError(t, th.wait(cancelledContext(), 1, 600))
+	assert.Equal(t, context.Canceled, th.wait(cancelledContext(), 1, 600))
+
+	*now = now.Add(time.Second)
+	assert.NoError(t, th.wait(cancelledContext(), 1, 100))
+	// messages are not limited
+	assert.Equal(t, 0.0, th.currentRate())
+}
+
+func TestThrottle_WaitsForTokens(t *testing.T) {
+	th := newThrottle()
+	th.maxRate = 100
+
+	start := time.Now()
+	for i := 0; i < 110; i++ {
+		require.NoError(t, th.wait(context.Background(), 1, 0))
+	}
+	assert.True(t, time.Since(start) >= 90*time.Millisecond, "waited %v", time.Since(start))
+}
+
+func TestThrottle_DecreasesOnServerBusy(t *testing.T) {
+	th, now := newTestThrottle()
+
+	// observe a rate of 100 messages per second
+	for i := 0; i < 100; i++ {
+		require.NoError(t, th.wait(context.Background(), 1, 0))
+	}
+	*now = now.Add(time.Second)
+	require.NoError(t, th.wait(context.Background(), 1, 0))
+
+	th.onServerBusy()
+	assert.Equal(t, 50.0, th.currentRate())
+
+	// busy errors seen by concurrent callers only count once
+	th.onServerBusy()
+	assert.Equal(t, 50.0, th.currentRate())
+
+	*now = now.Add(throttleDecreaseInterval)
+	th.onServerBusy()
+	assert.Equal(t, 25.0, th.currentRate())
+
+	for i := 0; i < 10; i++ {
+		*now = now.Add(throttleDecreaseInterval)
+		th.onServerBusy()
+	}
+	assert.Equal(t, throttleMinRate, th.currentRate())
+}
+
+func TestThrottle_DecreasesFromDefaultOrMaxRate(t *testing.T) {
+	th, _ := newTestThrottle()
+	th.onServerBusy()
+	assert.Equal(t, throttleDefaultRate*throttleDecrease, th.currentRate())
+
+	th, _ = newTestThrottle()
+	th.maxRate = 40
+	th.onServerBusy()
+	assert.Equal(t, 20.0, th.currentRate())
+}
+
+func TestThrottle_RecoversOnSuccess(t *testing.T) {
+	th, _ := newTestThrottle()
+	th.maxRate = 40
+	th.onServerBusy()
+
+	last := th.currentRate()
+	for i := 0; i < 1000 && th.currentRate() != 40; i++ {
+		th.onSuccess(1)
+		assert.True(t, th.currentRate() > last || th.currentRate() == 40)
+		last = th.currentRate()
+	}
+
+	// the adaptive limit is lifted, leaving the configured one
+	assert.Equal(t, 40.0, th.currentRate())
+	assert.Equal(t, 0.0, th.limit)
+}
+
+func TestThrottle_Middleware(t *testing.T) {
+	th, _ := newTestThrottle()
+	status := http.StatusServiceUnavailable
+	handler := th.throttleMiddleware(func(ctx context.Context, req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status}, nil
+	})
+
+	_, err := handler(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, throttleDefaultRate*throttleDecrease, th.currentRate())
+
+	status = http.StatusOK
+	_, err = handler(context.Background(), nil)
+	require.NoError(t, err)
+	assert.True(t, th.currentRate() > throttleDefaultRate*throttleDecrease)
+}
+
+func TestIsServerBusy(t *testing.T) {
+	assert.True(t, isServerBusy(&amqp.Error{Condition: errorServerBusy}))
+	assert.False(t, isServerBusy(&amqp.Error{Condition: errorTimeout}))
+	assert.False(t, isServerBusy(context.Canceled))
+}
+
+func TestNamespaceWithMaxRate(t *testing.T) {
+	ns, err := NewNamespace(NamespaceWithMaxRate(10), NamespaceWithMaxByteRate(1024))
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, ns.throttle.maxRate)
+	assert.Equal(t, 1024.0, ns.throttle.maxByteRate)
+
+	_, err = NewNamespace(NamespaceWithMaxRate(0))
+	assert.Error(t, err)
+	_, err = NewNamespace(NamespaceWithMaxByteRate(-1))
+	assert.Error(t, err)
+}
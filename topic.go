@@ -46,8 +46,9 @@ type (
 	// Messages are received from a subscription identically to the way they are received from a queue.
 	Topic struct {
 		*sendingEntity
-		sender   *Sender
-		senderMu sync.Mutex
+		sender        *Sender
+		senderMu      sync.Mutex
+		senderOptions []SenderOption
 	}
 
 	// TopicDescription is the content type for Topic management requests
@@ -123,9 +124,7 @@ func (t *Topic) SendBatch(ctx context.Context, iterator BatchIterator) error {
 			return err
 		}
 
-		batch, err := iterator.Next(id.String(), &BatchOptions{
-			SessionID: t.sender.sessionID,
-		})
+		batch, err := iterator.Next(id.String(), t.sender.batchOptions(ctx))
 		if err != nil {
 			tab.For(ctx).Error(err)
 			return err
@@ -152,7 +151,8 @@ func (t *Topic) NewSession(sessionID *string) *TopicSession {
 
 // NewSender will create a new Sender for sending messages to the queue
 func (t *Topic) NewSender(ctx context.Context, opts ...SenderOption) (*Sender, error) {
-	return t.namespace.NewSender(ctx, t.Name)
+	opts = append(t.senderOptions[:len(t.senderOptions):len(t.senderOptions)], opts...)
+	return t.namespace.NewSender(ctx, t.Name, opts...)
 }
 
 // Close the underlying connection to Service Bus
@@ -211,7 +211,7 @@ func (t *Topic) ensureSender(ctx context.Context) error {
 		return nil
 	}
 
-	s, err := t.namespace.NewSender(ctx, t.Name)
+	s, err := t.NewSender(ctx)
 	if err != nil {
 		tab.For(ctx).Error(err)
 		return err
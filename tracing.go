@@ -122,6 +122,13 @@ func startConsumerSpanFromContext(ctx context.Context, operationName string) (co
 	return ctx, span
 }
 
+func startProducerSpanFromContext(ctx context.Context, operationName string) (context.Context, tab.Spanner) {
+	ctx, span := tab.StartSpan(ctx, operationName)
+	applyComponentInfo(span)
+	span.AddAttributes(tab.StringAttribute("span.kind", "producer"))
+	return ctx, span
+}
+
 func applyComponentInfo(span tab.Spanner) {
 	span.AddAttributes(
 		tab.StringAttribute("component", "github.com/Azure/azure-service-bus-go"),